@@ -83,10 +83,8 @@ func (r *AggregateStore) Save(ctx context.Context, aggregate eh.Aggregate) error
 		events := a.UncommittedEvents()
 		a.ClearUncommittedEvents()
 
-		for _, e := range events {
-			if err := r.eventHandler.HandleEvent(ctx, e); err != nil {
-				return err
-			}
+		if err := eh.HandleEvents(ctx, r.eventHandler, events); err != nil {
+			return err
 		}
 	}
 
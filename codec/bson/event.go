@@ -38,6 +38,7 @@ func (c *EventCodec) MarshalEvent(ctx context.Context, event eh.Event) ([]byte,
 		AggregateID:   event.AggregateID().String(),
 		Version:       event.Version(),
 		Metadata:      event.Metadata(),
+		MetadataTypes: eh.MetadataTypes(event.Metadata()),
 		Context:       eh.MarshalContext(ctx),
 	}
 
@@ -86,6 +87,9 @@ func (c *EventCodec) UnmarshalEvent(ctx context.Context, b []byte) (eh.Event, co
 		aggregateID = uuid.Nil
 	}
 
+	// Restore the metadata types lost in BSON.
+	RestoreMetadata(e.Metadata, e.MetadataTypes)
+
 	event := eh.NewEvent(
 		e.EventType,
 		e.data,
@@ -114,5 +118,6 @@ type evt struct {
 	AggregateID   string                 `bson:"_id"`
 	Version       int                    `bson:"version"`
 	Metadata      map[string]interface{} `bson:"metadata"`
+	MetadataTypes map[string]string      `bson:"metadata_types,omitempty"`
 	Context       map[string]interface{} `bson:"context"`
 }
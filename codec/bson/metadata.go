@@ -0,0 +1,76 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bson
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	eh "github.com/reidlai/eventhorizon"
+)
+
+// RestoreMetadata converts metadata decoded by the MongoDB driver in place to
+// the types that the JSON codec uses, and restores the types saved with
+// eventhorizon.MetadataTypes. Documents and arrays are converted to maps and
+// slices, and BSON date times to time.Time, also in nested values.
+func RestoreMetadata(metadata map[string]interface{}, types map[string]string) {
+	for k, v := range metadata {
+		metadata[k] = fromBSON(v)
+	}
+
+	eh.RestoreMetadataTypes(metadata, types)
+}
+
+// Converts BSON values decoded into interface{} to their plain Go types.
+func fromBSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case primitive.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = fromBSON(e.Value)
+		}
+
+		return m
+	case primitive.M:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[k] = fromBSON(vv)
+		}
+
+		return m
+	case map[string]interface{}:
+		for k, vv := range v {
+			v[k] = fromBSON(vv)
+		}
+
+		return v
+	case primitive.A:
+		s := make([]interface{}, len(v))
+		for i, vv := range v {
+			s[i] = fromBSON(vv)
+		}
+
+		return s
+	case []interface{}:
+		for i, vv := range v {
+			v[i] = fromBSON(vv)
+		}
+
+		return v
+	case primitive.DateTime:
+		return v.Time().UTC()
+	}
+
+	return v
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+const (
+	// SpecVersion is the CloudEvents spec version used by the codec.
+	SpecVersion = "1.0"
+	// DefaultSource is the source attribute used if none is set on the codec.
+	DefaultSource = "eventhorizon"
+	// StructuredContentType is the content type of events in structured mode.
+	StructuredContentType = "application/cloudevents+json"
+	// DataContentType is the content type of the event data.
+	DataContentType = "application/json"
+)
+
+// Names of the CloudEvents context attributes and the extensions used for the
+// parts of an event that have no corresponding context attribute.
+const (
+	AttrSpecVersion     = "specversion"
+	AttrID              = "id"
+	AttrSource          = "source"
+	AttrType            = "type"
+	AttrSubject         = "subject"
+	AttrTime            = "time"
+	AttrDataContentType = "datacontenttype"
+	AttrAggregateType   = "ehaggregatetype"
+	AttrAggregateID     = "ehaggregateid"
+	AttrVersion         = "ehversion"
+	AttrMetadata        = "ehmetadata"
+	AttrMetadataTypes   = "ehmetadatatypes"
+	AttrContext         = "ehcontext"
+)
+
+// ErrUnsupportedSpecVersion is returned when unmarshaling an event with another
+// spec version than SpecVersion.
+var ErrUnsupportedSpecVersion = errors.New("unsupported CloudEvents spec version")
+
+// Attributes are the context attributes and extensions of an event in binary
+// mode, keyed by attribute name.
+type Attributes map[string]string
+
+// EventCodec is a codec for marshaling and unmarshaling events to and from
+// CloudEvents 1.0. MarshalEvent and UnmarshalEvent use the structured JSON
+// mode, MarshalEventBinary and UnmarshalEventBinary the binary mode where the
+// attributes are sent as headers by the transport.
+//
+// Metadata and the marshaled context are JSON encoded into the string
+// extensions "ehmetadata" and "ehcontext", as extensions can not hold maps. The
+// types of metadata values that JSON can not represent are kept in the
+// "ehmetadatatypes" extension.
+type EventCodec struct {
+	// Source is the source attribute of marshaled events, DefaultSource if empty.
+	Source string
+}
+
+// MarshalEvent marshals an event into bytes in CloudEvents structured JSON mode.
+func (c *EventCodec) MarshalEvent(ctx context.Context, event eh.Event) ([]byte, error) {
+	e, err := c.newEvt(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal event: %w", err)
+	}
+
+	return b, nil
+}
+
+// UnmarshalEvent unmarshals an event from bytes in CloudEvents structured
+// JSON mode.
+func (c *EventCodec) UnmarshalEvent(ctx context.Context, b []byte) (eh.Event, context.Context, error) {
+	var e evt
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, nil, fmt.Errorf("could not unmarshal event: %w", err)
+	}
+
+	return e.event(ctx)
+}
+
+// MarshalEventBinary marshals an event in CloudEvents binary mode, returning
+// the attributes to be set as headers by the transport and the event data.
+func (c *EventCodec) MarshalEventBinary(ctx context.Context, event eh.Event) (Attributes, []byte, error) {
+	e, err := c.newEvt(ctx, event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attrs := Attributes{
+		AttrSpecVersion: e.SpecVersion,
+		AttrID:          e.ID,
+		AttrSource:      e.Source,
+		AttrType:        string(e.Type),
+		AttrTime:        e.Time.Format(time.RFC3339Nano),
+		AttrVersion:     strconv.Itoa(e.Version),
+	}
+
+	if e.Subject != "" {
+		attrs[AttrSubject] = e.Subject
+	}
+
+	if e.DataContentType != "" {
+		attrs[AttrDataContentType] = e.DataContentType
+	}
+
+	if e.AggregateType != "" {
+		attrs[AttrAggregateType] = string(e.AggregateType)
+	}
+
+	if e.AggregateID != "" {
+		attrs[AttrAggregateID] = e.AggregateID
+	}
+
+	if e.Metadata != "" {
+		attrs[AttrMetadata] = e.Metadata
+	}
+
+	if e.MetadataTypes != "" {
+		attrs[AttrMetadataTypes] = e.MetadataTypes
+	}
+
+	if e.Context != "" {
+		attrs[AttrContext] = e.Context
+	}
+
+	return attrs, e.Data, nil
+}
+
+// UnmarshalEventBinary unmarshals an event in CloudEvents binary mode from the
+// attributes read from the headers by the transport and the event data.
+func (c *EventCodec) UnmarshalEventBinary(ctx context.Context, attrs Attributes, data []byte) (eh.Event, context.Context, error) {
+	e := evt{
+		SpecVersion:     attrs[AttrSpecVersion],
+		ID:              attrs[AttrID],
+		Source:          attrs[AttrSource],
+		Type:            eh.EventType(attrs[AttrType]),
+		Subject:         attrs[AttrSubject],
+		DataContentType: attrs[AttrDataContentType],
+		Data:            data,
+		AggregateType:   eh.AggregateType(attrs[AttrAggregateType]),
+		AggregateID:     attrs[AttrAggregateID],
+		Metadata:        attrs[AttrMetadata],
+		MetadataTypes:   attrs[AttrMetadataTypes],
+		Context:         attrs[AttrContext],
+	}
+
+	if v, ok := attrs[AttrTime]; ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse time attribute: %w", err)
+		}
+
+		e.Time = t
+	}
+
+	if v, ok := attrs[AttrVersion]; ok {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse version attribute: %w", err)
+		}
+
+		e.Version = version
+	}
+
+	return e.event(ctx)
+}
+
+// Creates the wire event from an event.
+func (c *EventCodec) newEvt(ctx context.Context, event eh.Event) (*evt, error) {
+	source := c.Source
+	if source == "" {
+		source = DefaultSource
+	}
+
+	e := &evt{
+		SpecVersion:   SpecVersion,
+		ID:            eventID(event),
+		Source:        source,
+		Type:          event.EventType(),
+		Time:          event.Timestamp(),
+		AggregateType: event.AggregateType(),
+		Version:       event.Version(),
+	}
+
+	if event.AggregateID() != uuid.Nil {
+		e.Subject = event.AggregateID().String()
+		e.AggregateID = e.Subject
+	}
+
+	// Marshal event data if there is any.
+	if event.Data() != nil {
+		var err error
+		if e.Data, err = json.Marshal(event.Data()); err != nil {
+			return nil, fmt.Errorf("could not marshal event data: %w", err)
+		}
+
+		e.DataContentType = DataContentType
+	}
+
+	if len(event.Metadata()) > 0 {
+		b, err := json.Marshal(event.Metadata())
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal event metadata: %w", err)
+		}
+
+		e.Metadata = string(b)
+
+		if types := eh.MetadataTypes(event.Metadata()); types != nil {
+			if b, err = json.Marshal(types); err != nil {
+				return nil, fmt.Errorf("could not marshal event metadata types: %w", err)
+			}
+
+			e.MetadataTypes = string(b)
+		}
+	}
+
+	if vals := eh.MarshalContext(ctx); len(vals) > 0 {
+		b, err := json.Marshal(vals)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal event context: %w", err)
+		}
+
+		e.Context = string(b)
+	}
+
+	return e, nil
+}
+
+// Returns an ID that is unique for the aggregate and version, to let consumers
+// detect duplicates, or a random ID for events without an aggregate.
+func eventID(event eh.Event) string {
+	if event.AggregateID() == uuid.Nil {
+		return uuid.New().String()
+	}
+
+	return fmt.Sprintf("%s.%d", event.AggregateID(), event.Version())
+}
+
+// evt is the internal event used on the wire only.
+type evt struct {
+	SpecVersion     string           `json:"specversion"`
+	ID              string           `json:"id"`
+	Source          string           `json:"source"`
+	Type            eh.EventType     `json:"type"`
+	Subject         string           `json:"subject,omitempty"`
+	Time            time.Time        `json:"time"`
+	DataContentType string           `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage  `json:"data,omitempty"`
+	AggregateType   eh.AggregateType `json:"ehaggregatetype,omitempty"`
+	AggregateID     string           `json:"ehaggregateid,omitempty"`
+	Version         int              `json:"ehversion"`
+	Metadata        string           `json:"ehmetadata,omitempty"`
+	MetadataTypes   string           `json:"ehmetadatatypes,omitempty"`
+	Context         string           `json:"ehcontext,omitempty"`
+}
+
+// Builds the event and context from the wire event.
+func (e *evt) event(ctx context.Context) (eh.Event, context.Context, error) {
+	if e.SpecVersion != SpecVersion {
+		return nil, nil, fmt.Errorf("%w: '%s'", ErrUnsupportedSpecVersion, e.SpecVersion)
+	}
+
+	if e.ID == "" || e.Source == "" || e.Type == "" {
+		return nil, nil, errors.New("missing required CloudEvents attribute")
+	}
+
+	// Create an event of the correct type and decode from raw JSON.
+	var data eh.EventData
+
+	if len(e.Data) > 0 {
+		if e.DataContentType != "" && e.DataContentType != DataContentType {
+			return nil, nil, fmt.Errorf("unsupported data content type: '%s'", e.DataContentType)
+		}
+
+		var err error
+		if data, err = eh.CreateEventData(e.Type); err != nil {
+			return nil, nil, fmt.Errorf("could not create event data: %w", err)
+		}
+
+		if err := json.Unmarshal(e.Data, data); err != nil {
+			return nil, nil, fmt.Errorf("could not unmarshal event data: %w", err)
+		}
+	}
+
+	// Decode numbers in metadata as json.Number, to not lose the precision of
+	// large integers before the types are restored.
+	var metadata map[string]interface{}
+	if e.Metadata != "" {
+		d := json.NewDecoder(strings.NewReader(e.Metadata))
+		d.UseNumber()
+
+		if err := d.Decode(&metadata); err != nil {
+			return nil, nil, fmt.Errorf("could not unmarshal event metadata: %w", err)
+		}
+	}
+
+	var types map[string]string
+	if e.MetadataTypes != "" {
+		if err := json.Unmarshal([]byte(e.MetadataTypes), &types); err != nil {
+			return nil, nil, fmt.Errorf("could not unmarshal event metadata types: %w", err)
+		}
+	}
+
+	eh.RestoreMetadataTypes(metadata, types)
+
+	var vals map[string]interface{}
+	if e.Context != "" {
+		if err := json.Unmarshal([]byte(e.Context), &vals); err != nil {
+			return nil, nil, fmt.Errorf("could not unmarshal event context: %w", err)
+		}
+	}
+
+	// Build the event.
+	aggregateID, err := uuid.Parse(e.AggregateID)
+	if err != nil {
+		aggregateID = uuid.Nil
+	}
+
+	event := eh.NewEvent(
+		e.Type,
+		data,
+		e.Time,
+		eh.ForAggregate(
+			e.AggregateType,
+			aggregateID,
+			e.Version,
+		),
+		eh.WithMetadata(metadata),
+	)
+
+	// Unmarshal the context.
+	ctx = eh.UnmarshalContext(ctx, vals)
+
+	return event, ctx, nil
+}
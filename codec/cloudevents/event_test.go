@@ -0,0 +1,137 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/codec"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+func TestEventCodec(t *testing.T) {
+	c := &EventCodec{}
+
+	expectedBytes := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(`
+	{
+		"specversion": "1.0",
+		"id": "10a7ec0f-7f2b-46f5-bca1-877b6e33c9fd.1",
+		"source": "eventhorizon",
+		"type": "CodecEvent",
+		"subject": "10a7ec0f-7f2b-46f5-bca1-877b6e33c9fd",
+		"time": "2009-11-10T23:00:00Z",
+		"datacontenttype": "application/json",
+		"data": {
+		  "Bool": true,
+		  "String": "string",
+		  "Number": 42,
+		  "Slice": ["a", "b"],
+		  "Map": { "key": "value" },
+		  "Time": "2009-11-10T23:00:00Z",
+		  "TimeRef": "2009-11-10T23:00:00Z",
+		  "NullTime": null,
+		  "Struct": { "Bool": true, "String": "string", "Number": 42 },
+		  "StructRef": { "Bool": true, "String": "string", "Number": 42 },
+		  "NullStruct": null
+		},
+		"ehaggregatetype": "Aggregate",
+		"ehaggregateid": "10a7ec0f-7f2b-46f5-bca1-877b6e33c9fd",
+		"ehversion": 1,
+		"ehmetadata": "{\"num\":42}",
+		"ehcontext": "{\"context_one\":\"testval\"}"
+	}`, " ", ""), "\n", ""), "\t", "")
+
+	codec.EventCodecAcceptanceTest(t, c, []byte(expectedBytes))
+}
+
+func TestEventCodecBinary(t *testing.T) {
+	c := &EventCodec{Source: "/test"}
+
+	ctx := mocks.WithContextOne(context.Background(), "testval")
+	id := uuid.MustParse("10a7ec0f-7f2b-46f5-bca1-877b6e33c9fd")
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(codec.EventType, &codec.EventData{String: "string"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 3),
+		eh.WithMetadata(map[string]interface{}{"key": "a \"quoted\" välue"}),
+	)
+
+	attrs, data, err := c.MarshalEventBinary(ctx, event)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if attrs[AttrSource] != "/test" {
+		t.Error("the source should be correct:", attrs[AttrSource])
+	}
+
+	if attrs[AttrID] != id.String()+".3" {
+		t.Error("the id should be correct:", attrs[AttrID])
+	}
+
+	if attrs[AttrVersion] != "3" {
+		t.Error("the version should be correct:", attrs[AttrVersion])
+	}
+
+	// Transport the attributes as HTTP headers.
+	h := http.Header{}
+	SetHTTPHeaders(h, attrs)
+
+	if h.Get("Content-Type") != DataContentType {
+		t.Error("the content type header should be correct:", h.Get("Content-Type"))
+	}
+
+	if h.Get("ce-specversion") != SpecVersion {
+		t.Error("the spec version header should be correct:", h.Get("ce-specversion"))
+	}
+
+	if IsStructuredHTTP(h) {
+		t.Error("the headers should not be in structured mode")
+	}
+
+	httpAttrs, err := HTTPAttributes(h)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Transport the attributes as Kafka headers.
+	kafkaAttrs := KafkaAttributes(KafkaHeaders(attrs))
+
+	for name, attrs := range map[string]Attributes{"http": httpAttrs, "kafka": kafkaAttrs} {
+		decodedEvent, decodedContext, err := c.UnmarshalEventBinary(context.Background(), attrs, data)
+		if err != nil {
+			t.Fatal(name, "there should be no error:", err)
+		}
+
+		if err := eh.CompareEvents(decodedEvent, event); err != nil {
+			t.Error(name, "the decoded event was incorrect:", err)
+		}
+
+		if val, ok := mocks.ContextOne(decodedContext); !ok || val != "testval" {
+			t.Error(name, "the decoded context was incorrect:", decodedContext)
+		}
+	}
+
+	// Unsupported spec versions should fail.
+	attrs[AttrSpecVersion] = "0.3"
+	if _, _, err := c.UnmarshalEventBinary(context.Background(), attrs, data); err == nil {
+		t.Error("there should be an error")
+	}
+}
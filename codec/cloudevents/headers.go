@@ -0,0 +1,141 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// HTTPHeaderPrefix is the prefix of attribute headers in the HTTP binding.
+	HTTPHeaderPrefix = "ce-"
+	// KafkaHeaderPrefix is the prefix of attribute headers in the Kafka binding.
+	KafkaHeaderPrefix = "ce_"
+	// KafkaContentTypeHeader is the content type header in the Kafka binding.
+	KafkaContentTypeHeader = "content-type"
+)
+
+// SetHTTPHeaders sets the attributes as HTTP headers in binary mode, using the
+// Content-Type header for the data content type.
+func SetHTTPHeaders(h http.Header, attrs Attributes) {
+	for name, v := range attrs {
+		if name == AttrDataContentType {
+			h.Set("Content-Type", v)
+
+			continue
+		}
+
+		h.Set(HTTPHeaderPrefix+name, encodeHTTPHeaderValue(v))
+	}
+}
+
+// HTTPAttributes returns the attributes from HTTP headers in binary mode.
+func HTTPAttributes(h http.Header) (Attributes, error) {
+	attrs := Attributes{}
+
+	for k := range h {
+		name := strings.ToLower(k)
+		if !strings.HasPrefix(name, HTTPHeaderPrefix) {
+			continue
+		}
+
+		v, err := url.PathUnescape(h.Get(k))
+		if err != nil {
+			return nil, fmt.Errorf("could not decode header %s: %w", k, err)
+		}
+
+		attrs[strings.TrimPrefix(name, HTTPHeaderPrefix)] = v
+	}
+
+	if ct := h.Get("Content-Type"); ct != "" {
+		attrs[AttrDataContentType] = ct
+	}
+
+	return attrs, nil
+}
+
+// IsStructuredHTTP returns true if the HTTP headers have the content type of an
+// event in structured mode, in which case the body should be unmarshaled with
+// UnmarshalEvent instead of UnmarshalEventBinary.
+func IsStructuredHTTP(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), StructuredContentType)
+}
+
+// KafkaHeaders returns the attributes as Kafka message headers in binary mode.
+func KafkaHeaders(attrs Attributes) []kafka.Header {
+	headers := make([]kafka.Header, 0, len(attrs))
+
+	for name, v := range attrs {
+		key := KafkaHeaderPrefix + name
+		if name == AttrDataContentType {
+			key = KafkaContentTypeHeader
+		}
+
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(v)})
+	}
+
+	return headers
+}
+
+// KafkaAttributes returns the attributes from Kafka message headers in binary mode.
+func KafkaAttributes(headers []kafka.Header) Attributes {
+	attrs := Attributes{}
+
+	for _, h := range headers {
+		switch {
+		case h.Key == KafkaContentTypeHeader:
+			attrs[AttrDataContentType] = string(h.Value)
+		case strings.HasPrefix(h.Key, KafkaHeaderPrefix):
+			attrs[strings.TrimPrefix(h.Key, KafkaHeaderPrefix)] = string(h.Value)
+		}
+	}
+
+	return attrs
+}
+
+// IsStructuredKafka returns true if the Kafka message headers have the content
+// type of an event in structured mode.
+func IsStructuredKafka(headers []kafka.Header) bool {
+	for _, h := range headers {
+		if h.Key == KafkaContentTypeHeader {
+			return strings.HasPrefix(string(h.Value), StructuredContentType)
+		}
+	}
+
+	return false
+}
+
+// Percent encodes spaces, double quotes, percent signs and all characters
+// outside of printable ASCII, as required by the HTTP binding.
+func encodeHTTPHeaderValue(v string) string {
+	var sb strings.Builder
+
+	for _, b := range []byte(v) {
+		if b <= ' ' || b > '~' || b == '"' || b == '%' {
+			fmt.Fprintf(&sb, "%%%02X", b)
+
+			continue
+		}
+
+		sb.WriteByte(b)
+	}
+
+	return sb.String()
+}
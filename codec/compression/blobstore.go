@@ -0,0 +1,61 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compression
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrBlobNotFound is returned by the MemoryBlobStore for unknown keys.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// MemoryBlobStore is a BlobStore in memory, useful for testing and when the
+// producer and consumers are in the same process.
+type MemoryBlobStore struct {
+	blobs   map[string][]byte
+	blobsMu sync.RWMutex
+}
+
+// NewMemoryBlobStore creates a new MemoryBlobStore.
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{
+		blobs: map[string][]byte{},
+	}
+}
+
+// PutBlob implements the PutBlob method of the BlobStore interface.
+func (s *MemoryBlobStore) PutBlob(ctx context.Context, key string, data []byte) error {
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+
+	s.blobs[key] = append([]byte(nil), data...)
+
+	return nil
+}
+
+// GetBlob implements the GetBlob method of the BlobStore interface.
+func (s *MemoryBlobStore) GetBlob(ctx context.Context, key string) ([]byte, error) {
+	s.blobsMu.RLock()
+	defer s.blobsMu.RUnlock()
+
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+
+	return data, nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm is a compression algorithm, stored in the payload header.
+type Algorithm byte
+
+const (
+	// None is used for uncompressed payloads.
+	None Algorithm = iota
+	// Gzip compresses with gzip.
+	Gzip
+	// Zstd compresses with Zstandard.
+	Zstd
+	// Snappy compresses with the Snappy block format.
+	Snappy
+)
+
+// String implements the String method of the fmt.Stringer interface.
+func (a Algorithm) String() string {
+	switch a {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(a))
+	}
+}
+
+// ErrUnknownAlgorithm is returned for unknown compression algorithms.
+var ErrUnknownAlgorithm = errors.New("unknown compression algorithm")
+
+// ErrDecompressedTooLarge is returned when a payload decompresses to more than
+// the max decompressed size.
+var ErrDecompressedTooLarge = errors.New("decompressed event too large")
+
+// The zstd encoder is safe for concurrent use with EncodeAll, and is expensive
+// to create, so it is shared.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	})
+
+	return zstdErr
+}
+
+// decompressor decompresses payloads up to a max size. The zstd decoder is
+// safe for concurrent use with DecodeAll, and is created on first use.
+type decompressor struct {
+	maxSize     int
+	zstdOnce    sync.Once
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+}
+
+func (d *decompressor) initZstd() error {
+	d.zstdOnce.Do(func() {
+		d.zstdDecoder, d.zstdErr = zstd.NewReader(nil,
+			zstd.WithDecoderMaxMemory(uint64(d.maxSize)))
+	})
+
+	return d.zstdErr
+}
+
+func compress(algorithm Algorithm, b []byte) ([]byte, error) {
+	switch algorithm {
+	case Gzip:
+		var buf bytes.Buffer
+
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+
+		return zstdEncoder.EncodeAll(b, nil), nil
+	case Snappy:
+		return snappy.Encode(nil, b), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+}
+
+func (d *decompressor) decompress(algorithm Algorithm, b []byte) ([]byte, error) {
+	switch algorithm {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		// Read one byte more than the max size to detect larger payloads.
+		data, err := io.ReadAll(io.LimitReader(r, int64(d.maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) > d.maxSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", ErrDecompressedTooLarge, d.maxSize)
+		}
+
+		return data, nil
+	case Zstd:
+		if err := d.initZstd(); err != nil {
+			return nil, err
+		}
+
+		data, err := d.zstdDecoder.DecodeAll(b, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, fmt.Errorf("%w: more than %d bytes", ErrDecompressedTooLarge, d.maxSize)
+		}
+
+		return data, err
+	case Snappy:
+		n, err := snappy.DecodedLen(b)
+		if err != nil {
+			return nil, err
+		}
+
+		if n > d.maxSize {
+			return nil, fmt.Errorf("%w: %d bytes", ErrDecompressedTooLarge, n)
+		}
+
+		return snappy.Decode(nil, b)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+// ErrMessageTooLarge is returned when a marshaled event is larger than the max
+// size after compression and no blob store is set for claim checks.
+var ErrMessageTooLarge = errors.New("marshaled event too large")
+
+// ErrInvalidHeader is returned when unmarshaling a payload with a header that
+// can not be decoded.
+var ErrInvalidHeader = errors.New("invalid compression header")
+
+// The header prefixed to payloads that are compressed or claim checks, the
+// magic bytes are followed by a mode and an algorithm byte. Payloads without
+// the header are passed as is to the wrapped codec, which keeps small events
+// readable by consumers not using this codec.
+var magic = []byte{0xEE, 'E', 'H'}
+
+const headerLen = 5
+
+const (
+	modeInline     byte = 1
+	modeClaimCheck byte = 2
+)
+
+// DefaultMaxDecompressedSize is the default max size in bytes of decompressed
+// events, which protects consumers from payloads that decompress to huge sizes.
+var DefaultMaxDecompressedSize = 64 << 20
+
+// BlobStore is a store for oversized payloads in claim check mode.
+type BlobStore interface {
+	// PutBlob stores the data with the key.
+	PutBlob(ctx context.Context, key string, data []byte) error
+	// GetBlob returns the data stored with the key.
+	GetBlob(ctx context.Context, key string) ([]byte, error)
+}
+
+// EventCodec is an event codec wrapper that compresses the events marshaled by
+// the wrapped codec when they are larger than a threshold, and optionally
+// stores events that are still larger than a max size in a blob store, sending
+// only a reference to it (the claim check pattern). Unmarshaling detects the
+// mode and algorithm from a small header, so it is automatic.
+type EventCodec struct {
+	codec        eh.EventCodec
+	algorithm    Algorithm
+	threshold    int
+	maxSize      int
+	blobStore    BlobStore
+	newBlobKey   func(eh.Event) string
+	decompressor decompressor
+}
+
+// NewEventCodec creates an EventCodec wrapping the codec, with optional settings.
+// Without options events are never compressed, but compressed events and claim
+// checks can still be unmarshaled.
+func NewEventCodec(codec eh.EventCodec, options ...Option) (*EventCodec, error) {
+	if codec == nil {
+		return nil, errors.New("missing codec")
+	}
+
+	c := &EventCodec{
+		codec:        codec,
+		newBlobKey:   func(eh.Event) string { return uuid.New().String() },
+		decompressor: decompressor{maxSize: DefaultMaxDecompressedSize},
+	}
+
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+
+		if err := option(c); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	if c.blobStore != nil && c.maxSize == 0 {
+		return nil, errors.New("max size must be set for claim checks")
+	}
+
+	return c, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventCodec) error
+
+// WithCompression compresses marshaled events larger than the threshold in
+// bytes with the algorithm.
+func WithCompression(algorithm Algorithm, threshold int) Option {
+	return func(c *EventCodec) error {
+		if algorithm == None || algorithm > Snappy {
+			return fmt.Errorf("%w: %d", ErrUnknownAlgorithm, algorithm)
+		}
+
+		if threshold < 0 {
+			return errors.New("threshold must not be negative")
+		}
+
+		c.algorithm = algorithm
+		c.threshold = threshold
+
+		return nil
+	}
+}
+
+// WithMaxSize sets the max size in bytes of marshaled events, after compression.
+// Larger events are stored in the blob store if one is set with WithClaimCheck,
+// otherwise ErrMessageTooLarge is returned.
+func WithMaxSize(maxSize int) Option {
+	return func(c *EventCodec) error {
+		if maxSize <= headerLen {
+			return fmt.Errorf("max size must be greater than %d", headerLen)
+		}
+
+		c.maxSize = maxSize
+
+		return nil
+	}
+}
+
+// WithClaimCheck stores events larger than the max size in the blob store,
+// sending only a reference to them. The max size must be set with WithMaxSize.
+func WithClaimCheck(store BlobStore) Option {
+	return func(c *EventCodec) error {
+		if store == nil {
+			return errors.New("missing blob store")
+		}
+
+		c.blobStore = store
+
+		return nil
+	}
+}
+
+// WithMaxDecompressedSize sets the max size in bytes of decompressed events,
+// larger events fail to unmarshal with ErrDecompressedTooLarge.
+//
+// Defaults to: DefaultMaxDecompressedSize
+func WithMaxDecompressedSize(maxSize int) Option {
+	return func(c *EventCodec) error {
+		if maxSize <= 0 {
+			return errors.New("max decompressed size must be positive")
+		}
+
+		c.decompressor.maxSize = maxSize
+
+		return nil
+	}
+}
+
+// WithBlobKey sets a function that creates the blob store keys for events in
+// claim check mode, the default is a random UUID.
+func WithBlobKey(f func(eh.Event) string) Option {
+	return func(c *EventCodec) error {
+		if f == nil {
+			return errors.New("missing blob key function")
+		}
+
+		c.newBlobKey = f
+
+		return nil
+	}
+}
+
+// MarshalEvent implements the MarshalEvent method of the
+// eventhorizon.EventCodec interface.
+func (c *EventCodec) MarshalEvent(ctx context.Context, event eh.Event) ([]byte, error) {
+	b, err := c.codec.MarshalEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := None
+
+	if c.algorithm != None && len(b) > c.threshold {
+		if b, err = compress(c.algorithm, b); err != nil {
+			return nil, fmt.Errorf("could not compress event: %w", err)
+		}
+
+		algorithm = c.algorithm
+	}
+
+	if c.maxSize > 0 && len(b)+headerLen > c.maxSize {
+		if c.blobStore == nil {
+			return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(b))
+		}
+
+		key := c.newBlobKey(event)
+		if err := c.blobStore.PutBlob(ctx, key, b); err != nil {
+			return nil, fmt.Errorf("could not store event in blob store: %w", err)
+		}
+
+		return appendHeader(modeClaimCheck, algorithm, []byte(key)), nil
+	}
+
+	if algorithm == None {
+		return b, nil
+	}
+
+	return appendHeader(modeInline, algorithm, b), nil
+}
+
+// UnmarshalEvent implements the UnmarshalEvent method of the
+// eventhorizon.EventCodec interface.
+func (c *EventCodec) UnmarshalEvent(ctx context.Context, b []byte) (eh.Event, context.Context, error) {
+	if !bytes.HasPrefix(b, magic) {
+		return c.codec.UnmarshalEvent(ctx, b)
+	}
+
+	if len(b) < headerLen {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	mode, algorithm, payload := b[len(magic)], Algorithm(b[len(magic)+1]), b[headerLen:]
+
+	switch mode {
+	case modeInline:
+	case modeClaimCheck:
+		if c.blobStore == nil {
+			return nil, nil, errors.New("could not load event: missing blob store")
+		}
+
+		var err error
+		if payload, err = c.blobStore.GetBlob(ctx, string(payload)); err != nil {
+			return nil, nil, fmt.Errorf("could not load event from blob store: %w", err)
+		}
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown mode %d", ErrInvalidHeader, mode)
+	}
+
+	if algorithm != None {
+		var err error
+		if payload, err = c.decompressor.decompress(algorithm, payload); err != nil {
+			return nil, nil, fmt.Errorf("could not decompress event: %w", err)
+		}
+	}
+
+	return c.codec.UnmarshalEvent(ctx, payload)
+}
+
+func appendHeader(mode byte, algorithm Algorithm, payload []byte) []byte {
+	b := make([]byte, 0, headerLen+len(payload))
+	b = append(b, magic...)
+	b = append(b, mode, byte(algorithm))
+
+	return append(b, payload...)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/codec"
+	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+func TestEventCodecCompression(t *testing.T) {
+	for _, algorithm := range []Algorithm{Gzip, Zstd, Snappy} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			c, err := NewEventCodec(&json.EventCodec{}, WithCompression(algorithm, 512))
+			if err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			// Small events should not be compressed.
+			small := newEvent("small")
+
+			b, err := c.MarshalEvent(context.Background(), small)
+			if err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			if bytes.HasPrefix(b, magic) {
+				t.Error("small events should not have a header")
+			}
+
+			testRoundTrip(t, c, b, small)
+
+			// Large events should be compressed.
+			large := newEvent(strings.Repeat("large", 1000))
+
+			b, err = c.MarshalEvent(context.Background(), large)
+			if err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			if !bytes.HasPrefix(b, magic) || Algorithm(b[4]) != algorithm {
+				t.Error("large events should have a header with the algorithm")
+			}
+
+			if len(b) > 1000 {
+				t.Error("large events should be compressed:", len(b))
+			}
+
+			testRoundTrip(t, c, b, large)
+
+			// Compressed events should be decoded without any options.
+			plain, err := NewEventCodec(&json.EventCodec{})
+			if err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			testRoundTrip(t, plain, b, large)
+
+			// Events larger than the max decompressed size should fail.
+			limited, err := NewEventCodec(&json.EventCodec{}, WithMaxDecompressedSize(1000))
+			if err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			if _, _, err := limited.UnmarshalEvent(context.Background(), b); !errors.Is(err, ErrDecompressedTooLarge) {
+				t.Error("the error should be correct:", err)
+			}
+		})
+	}
+}
+
+func TestEventCodecClaimCheck(t *testing.T) {
+	event := newEvent(strings.Repeat("large", 1000))
+
+	c, err := NewEventCodec(&json.EventCodec{}, WithMaxSize(1000))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := c.MarshalEvent(context.Background(), event); !errors.Is(err, ErrMessageTooLarge) {
+		t.Error("the error should be correct:", err)
+	}
+
+	store := NewMemoryBlobStore()
+
+	if _, err := NewEventCodec(&json.EventCodec{}, WithClaimCheck(store)); err == nil {
+		t.Error("there should be an error without a max size")
+	}
+
+	c, err = NewEventCodec(&json.EventCodec{},
+		WithMaxSize(1000),
+		WithClaimCheck(store),
+		WithBlobKey(func(e eh.Event) string { return e.AggregateID().String() }),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	b, err := c.MarshalEvent(context.Background(), event)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !bytes.HasPrefix(b, magic) || b[3] != modeClaimCheck {
+		t.Error("the event should be a claim check")
+	}
+
+	if string(b[headerLen:]) != event.AggregateID().String() {
+		t.Error("the claim check should reference the blob:", string(b[headerLen:]))
+	}
+
+	testRoundTrip(t, c, b, event)
+}
+
+func newEvent(s string) eh.Event {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	return eh.NewEvent(codec.EventType, &codec.EventData{String: s}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1),
+	)
+}
+
+func testRoundTrip(t *testing.T, c eh.EventCodec, b []byte, event eh.Event) {
+	t.Helper()
+
+	decodedEvent, _, err := c.UnmarshalEvent(context.Background(), b)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := eh.CompareEvents(decodedEvent, event); err != nil {
+		t.Error("the decoded event was incorrect:", err)
+	}
+}
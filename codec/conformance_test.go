@@ -0,0 +1,105 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/codec"
+	"github.com/reidlai/eventhorizon/codec/bson"
+	"github.com/reidlai/eventhorizon/codec/cloudevents"
+	"github.com/reidlai/eventhorizon/codec/compression"
+	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/codec/protobuf"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+// Tests that all codecs preserve the types of metadata values.
+func TestMetadataConformance(t *testing.T) {
+	compressed, err := compression.NewEventCodec(&json.EventCodec{},
+		compression.WithCompression(compression.Zstd, 0),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	codecs := map[string]eh.EventCodec{
+		"json":        &json.EventCodec{},
+		"bson":        &bson.EventCodec{},
+		"cloudevents": &cloudevents.EventCodec{},
+		"protobuf":    &protobuf.EventCodec{},
+		"compression": compressed,
+	}
+
+	// NOTE: BSON stores times with millisecond precision.
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 123000000, time.UTC)
+	metadata := map[string]interface{}{
+		"string":  "string",
+		"bool":    true,
+		"float":   1.5,
+		"int":     42,
+		"int32":   int32(-3),
+		"int64":   int64(1) << 40,
+		"uint":    uint(7),
+		"float32": float32(0.5),
+		"time":    timestamp,
+		"map":     map[string]interface{}{"key": "value", "num": 1.0},
+		"slice":   []interface{}{"a", 2.0},
+	}
+
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			md := map[string]interface{}{}
+			for k, v := range metadata {
+				md[k] = v
+			}
+
+			event := eh.NewEvent(codec.EventType, &codec.EventData{String: "string"}, timestamp,
+				eh.ForAggregate(mocks.AggregateType, uuid.New(), 1),
+				eh.WithMetadata(md),
+				eh.WithGlobalPosition(12),
+			)
+
+			b, err := c.MarshalEvent(context.Background(), event)
+			if err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			decodedEvent, _, err := c.UnmarshalEvent(context.Background(), b)
+			if err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			for k, v := range event.Metadata() {
+				if dv := decodedEvent.Metadata()[k]; !reflect.DeepEqual(dv, v) {
+					t.Errorf("the metadata value for %s should be correct: %#v (should be %#v)", k, dv, v)
+				}
+			}
+
+			if pos, ok := decodedEvent.MetadataInt("position"); !ok || pos != 12 {
+				t.Error("the position should be correct:", pos)
+			}
+
+			if ts, ok := decodedEvent.MetadataTime("time"); !ok || !ts.Equal(timestamp) {
+				t.Error("the time should be correct:", ts)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@
 package json
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -37,6 +38,7 @@ func (c *EventCodec) MarshalEvent(ctx context.Context, event eh.Event) ([]byte,
 		AggregateID:   event.AggregateID().String(),
 		Version:       event.Version(),
 		Metadata:      event.Metadata(),
+		MetadataTypes: eh.MetadataTypes(event.Metadata()),
 		Context:       eh.MarshalContext(ctx),
 	}
 
@@ -85,6 +87,9 @@ func (c *EventCodec) UnmarshalEvent(ctx context.Context, b []byte) (eh.Event, co
 		aggregateID = uuid.Nil
 	}
 
+	// Restore the metadata types lost in JSON.
+	eh.RestoreMetadataTypes(e.Metadata, e.MetadataTypes)
+
 	event := eh.NewEvent(
 		e.EventType,
 		e.data,
@@ -112,6 +117,27 @@ type evt struct {
 	AggregateType eh.AggregateType       `json:"aggregate_type"`
 	AggregateID   string                 `json:"aggregate_id"`
 	Version       int                    `json:"version"`
-	Metadata      map[string]interface{} `json:"metadata"`
+	Metadata      metadata               `json:"metadata"`
+	MetadataTypes map[string]string      `json:"metadata_types,omitempty"`
 	Context       map[string]interface{} `json:"context"`
 }
+
+// metadata is decoded with json.Number for numbers, to not lose the precision
+// of large integers before the types are restored.
+type metadata map[string]interface{}
+
+// UnmarshalJSON implements the UnmarshalJSON method of the json.Unmarshaler
+// interface.
+func (m *metadata) UnmarshalJSON(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var vals map[string]interface{}
+	if err := d.Decode(&vals); err != nil {
+		return err
+	}
+
+	*m = vals
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	eh "github.com/reidlai/eventhorizon"
+)
+
+// Field numbers of the Command envelope, see envelope.proto.
+const (
+	commandTypeField     protowire.Number = 1
+	commandDataField     protowire.Number = 2
+	commandEncodingField protowire.Number = 3
+	commandContextField  protowire.Number = 4
+)
+
+// CommandCodec is a codec for marshaling and unmarshaling commands to and from
+// bytes in a protobuf envelope. Commands implementing proto.Message are
+// marshaled as protobuf, other commands are embedded as JSON.
+type CommandCodec struct{}
+
+// MarshalCommand marshals a command into bytes in a protobuf envelope.
+func (_ CommandCodec) MarshalCommand(ctx context.Context, cmd eh.Command) ([]byte, error) {
+	var b []byte
+
+	b = appendBytes(b, commandTypeField, []byte(cmd.CommandType()))
+
+	data, enc, err := marshalData(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal command data: %w", err)
+	}
+
+	b = appendBytes(b, commandDataField, data)
+	b = appendVarint(b, commandEncodingField, uint64(enc))
+
+	if vals := eh.MarshalContext(ctx); len(vals) > 0 {
+		c, err := marshalStruct(vals)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal command context: %w", err)
+		}
+
+		b = appendBytes(b, commandContextField, c)
+	}
+
+	return b, nil
+}
+
+// UnmarshalCommand unmarshals a command from bytes in a protobuf envelope.
+func (_ CommandCodec) UnmarshalCommand(ctx context.Context, b []byte) (eh.Command, context.Context, error) {
+	var (
+		commandType eh.CommandType
+		rawData     []byte
+		enc         Encoding
+		vals        map[string]interface{}
+	)
+
+	if err := consumeFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		var err error
+
+		switch num {
+		case commandTypeField:
+			commandType = eh.CommandType(v)
+		case commandDataField:
+			rawData = v
+		case commandEncodingField:
+			enc = Encoding(x)
+		case commandContextField:
+			vals, err = unmarshalStruct(v)
+		}
+
+		return err
+	}); err != nil {
+		return nil, nil, fmt.Errorf("could not unmarshal command: %w", err)
+	}
+
+	cmd, err := eh.CreateCommand(commandType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create command: %w", err)
+	}
+
+	if len(rawData) > 0 {
+		if err := unmarshalData(rawData, enc, cmd); err != nil {
+			return nil, nil, fmt.Errorf("could not unmarshal command data: %w", err)
+		}
+	}
+
+	ctx = eh.UnmarshalContext(ctx, vals)
+
+	return cmd, ctx, nil
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/reidlai/eventhorizon/codec"
+)
+
+func TestCommandCodec(t *testing.T) {
+	c := &CommandCodec{}
+
+	expectedBytes, err := base64.StdEncoding.DecodeString("CgxDb2RlY0NvbW1hbmQSzQJ7IklEIjoiMTBhN2VjMGYtN2YyYi00NmY1LWJjYTEtODc3YjZlMzNjOWZkIiwiQm9vbCI6dHJ1ZSwiU3RyaW5nIjoic3RyaW5nIiwiTnVtYmVyIjo0MiwiU2xpY2UiOlsiYSIsImIiXSwiTWFwIjp7ImtleSI6InZhbHVlIn0sIlRpbWUiOiIyMDA5LTExLTEwVDIzOjAwOjAwWiIsIlRpbWVSZWYiOiIyMDA5LTExLTEwVDIzOjAwOjAwWiIsIk51bGxUaW1lIjpudWxsLCJTdHJ1Y3QiOnsiQm9vbCI6dHJ1ZSwiU3RyaW5nIjoic3RyaW5nIiwiTnVtYmVyIjo0Mn0sIlN0cnVjdFJlZiI6eyJCb29sIjp0cnVlLCJTdHJpbmciOiJzdHJpbmciLCJOdW1iZXIiOjQyfSwiTnVsbFN0cnVjdCI6bnVsbH0YASIaChgKC2NvbnRleHRfb25lEgkaB3Rlc3R2YWw=")
+	if err != nil {
+		t.Error("could not decode expected bytes:", err)
+	}
+
+	codec.CommandCodecAcceptanceTest(t, c, expectedBytes)
+}
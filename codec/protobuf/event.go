@@ -0,0 +1,213 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+// Field numbers of the Event envelope, see envelope.proto.
+const (
+	eventTypeField     protowire.Number = 1
+	eventDataField     protowire.Number = 2
+	eventEncodingField protowire.Number = 3
+	eventTimeField     protowire.Number = 4
+	aggregateTypeField protowire.Number = 5
+	aggregateIDField   protowire.Number = 6
+	versionField       protowire.Number = 7
+	metadataField      protowire.Number = 8
+	eventContextField  protowire.Number = 9
+	metadataTypesField protowire.Number = 10
+)
+
+// EventCodec is a codec for marshaling and unmarshaling events to and from
+// bytes in a protobuf envelope. Event data implementing proto.Message is
+// marshaled as protobuf, other event data is embedded as JSON.
+type EventCodec struct{}
+
+// MarshalEvent marshals an event into bytes in a protobuf envelope.
+func (c *EventCodec) MarshalEvent(ctx context.Context, event eh.Event) ([]byte, error) {
+	var b []byte
+
+	b = appendBytes(b, eventTypeField, []byte(event.EventType()))
+
+	// Marshal event data if there is any.
+	if event.Data() != nil {
+		data, enc, err := marshalData(event.Data())
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal event data: %w", err)
+		}
+
+		// Always add the data field, also when empty as for proto messages with
+		// only default values, to create the event data when unmarshaling.
+		b = protowire.AppendTag(b, eventDataField, protowire.BytesType)
+		b = protowire.AppendBytes(b, data)
+		b = appendVarint(b, eventEncodingField, uint64(enc))
+	}
+
+	ts, err := marshalOptions.Marshal(timestamppb.New(event.Timestamp()))
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal event timestamp: %w", err)
+	}
+
+	b = appendBytes(b, eventTimeField, ts)
+	b = appendBytes(b, aggregateTypeField, []byte(event.AggregateType()))
+
+	if event.AggregateID() != uuid.Nil {
+		b = appendBytes(b, aggregateIDField, []byte(event.AggregateID().String()))
+	}
+
+	b = appendVarint(b, versionField, uint64(event.Version()))
+
+	if len(event.Metadata()) > 0 {
+		metadata, err := marshalStruct(event.Metadata())
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal event metadata: %w", err)
+		}
+
+		b = appendBytes(b, metadataField, metadata)
+
+		// Keep the types of values that a Struct can not represent.
+		types := eh.MetadataTypes(event.Metadata())
+		keys := make([]string, 0, len(types))
+
+		for k := range types {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			b = appendBytes(b, metadataTypesField, appendMapEntry(nil, k, types[k]))
+		}
+	}
+
+	if vals := eh.MarshalContext(ctx); len(vals) > 0 {
+		c, err := marshalStruct(vals)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal event context: %w", err)
+		}
+
+		b = appendBytes(b, eventContextField, c)
+	}
+
+	return b, nil
+}
+
+// UnmarshalEvent unmarshals an event from bytes in a protobuf envelope.
+func (c *EventCodec) UnmarshalEvent(ctx context.Context, b []byte) (eh.Event, context.Context, error) {
+	var (
+		eventType     eh.EventType
+		rawData       []byte
+		hasData       bool
+		enc           Encoding
+		timestamp     time.Time
+		aggregateType eh.AggregateType
+		aggregateID   = uuid.Nil
+		version       int
+		metadata      map[string]interface{}
+		types         map[string]string
+		vals          map[string]interface{}
+	)
+
+	if err := consumeFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		var err error
+
+		switch num {
+		case eventTypeField:
+			eventType = eh.EventType(v)
+		case eventDataField:
+			rawData = v
+			hasData = true
+		case eventEncodingField:
+			enc = Encoding(x)
+		case eventTimeField:
+			var ts timestamppb.Timestamp
+			if err = proto.Unmarshal(v, &ts); err == nil {
+				timestamp = ts.AsTime()
+			}
+		case aggregateTypeField:
+			aggregateType = eh.AggregateType(v)
+		case aggregateIDField:
+			if aggregateID, err = uuid.Parse(string(v)); err != nil {
+				err = fmt.Errorf("could not parse aggregate ID: %w", err)
+			}
+		case versionField:
+			version = int(int64(x))
+		case metadataField:
+			metadata, err = unmarshalStruct(v)
+		case eventContextField:
+			vals, err = unmarshalStruct(v)
+		case metadataTypesField:
+			var k, t string
+			if k, t, err = consumeMapEntry(v); err == nil {
+				if types == nil {
+					types = map[string]string{}
+				}
+
+				types[k] = t
+			}
+		}
+
+		return err
+	}); err != nil {
+		return nil, nil, fmt.Errorf("could not unmarshal event: %w", err)
+	}
+
+	// Create an event of the correct type and decode the data.
+	var data eh.EventData
+
+	if hasData {
+		var err error
+		if data, err = eh.CreateEventData(eventType); err != nil {
+			return nil, nil, fmt.Errorf("could not create event data: %w", err)
+		}
+
+		if err := unmarshalData(rawData, enc, data); err != nil {
+			return nil, nil, fmt.Errorf("could not unmarshal event data: %w", err)
+		}
+	}
+
+	// Restore the metadata types lost in the Struct.
+	eh.RestoreMetadataTypes(metadata, types)
+
+	// Build the event.
+	event := eh.NewEvent(
+		eventType,
+		data,
+		timestamp,
+		eh.ForAggregate(
+			aggregateType,
+			aggregateID,
+			version,
+		),
+		eh.WithMetadata(metadata),
+	)
+
+	// Unmarshal the context.
+	ctx = eh.UnmarshalContext(ctx, vals)
+
+	return event, ctx, nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/codec"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+const protoEventType eh.EventType = "ProtoCodecEvent"
+
+func init() {
+	eh.RegisterEventData(protoEventType, func() eh.EventData { return &wrapperspb.StringValue{} })
+}
+
+func TestEventCodec(t *testing.T) {
+	c := &EventCodec{}
+
+	expectedBytes, err := base64.StdEncoding.DecodeString("CgpDb2RlY0V2ZW50EqECeyJCb29sIjp0cnVlLCJTdHJpbmciOiJzdHJpbmciLCJOdW1iZXIiOjQyLCJTbGljZSI6WyJhIiwiYiJdLCJNYXAiOnsia2V5IjoidmFsdWUifSwiVGltZSI6IjIwMDktMTEtMTBUMjM6MDA6MDBaIiwiVGltZVJlZiI6IjIwMDktMTEtMTBUMjM6MDA6MDBaIiwiTnVsbFRpbWUiOm51bGwsIlN0cnVjdCI6eyJCb29sIjp0cnVlLCJTdHJpbmciOiJzdHJpbmciLCJOdW1iZXIiOjQyfSwiU3RydWN0UmVmIjp7IkJvb2wiOnRydWUsIlN0cmluZyI6InN0cmluZyIsIk51bWJlciI6NDJ9LCJOdWxsU3RydWN0IjpudWxsfRgBIgYI8ODn1wQqCUFnZ3JlZ2F0ZTIkMTBhN2VjMGYtN2YyYi00NmY1LWJjYTEtODc3YjZlMzNjOWZkOAFCEgoQCgNudW0SCREAAAAAAABFQEoaChgKC2NvbnRleHRfb25lEgkaB3Rlc3R2YWw=")
+	if err != nil {
+		t.Error("could not decode expected bytes:", err)
+	}
+
+	codec.EventCodecAcceptanceTest(t, c, expectedBytes)
+}
+
+func TestEventCodecProtoData(t *testing.T) {
+	c := &EventCodec{}
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(protoEventType, wrapperspb.String("value"), timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 2),
+	)
+
+	b, err := c.MarshalEvent(context.Background(), event)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	decodedEvent, _, err := c.UnmarshalEvent(context.Background(), b)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	data, ok := decodedEvent.Data().(*wrapperspb.StringValue)
+	if !ok {
+		t.Fatalf("the event data should be a proto message: %T", decodedEvent.Data())
+	}
+
+	if !proto.Equal(data, wrapperspb.String("value")) {
+		t.Error("the event data should be correct:", data)
+	}
+
+	if decodedEvent.AggregateID() != id || decodedEvent.Version() != 2 {
+		t.Error("the aggregate should be correct:", decodedEvent)
+	}
+
+	if !decodedEvent.Timestamp().Equal(timestamp) {
+		t.Error("the timestamp should be correct:", decodedEvent.Timestamp())
+	}
+}
+
+func TestEventCodecEmptyProtoData(t *testing.T) {
+	c := &EventCodec{}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(protoEventType, &wrapperspb.StringValue{}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1),
+	)
+
+	b, err := c.MarshalEvent(context.Background(), event)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	decodedEvent, _, err := c.UnmarshalEvent(context.Background(), b)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	data, ok := decodedEvent.Data().(*wrapperspb.StringValue)
+	if !ok {
+		t.Fatalf("the event data should be a proto message: %T", decodedEvent.Data())
+	}
+
+	if !proto.Equal(data, &wrapperspb.StringValue{}) {
+		t.Error("the event data should be correct:", data)
+	}
+}
+
+func TestEventCodecInvalidAggregateID(t *testing.T) {
+	c := &EventCodec{}
+
+	b := appendBytes(nil, eventTypeField, []byte(protoEventType))
+	b = appendBytes(b, aggregateIDField, []byte("not-a-uuid"))
+
+	if _, _, err := c.UnmarshalEvent(context.Background(), b); err == nil {
+		t.Error("there should be an error")
+	}
+}
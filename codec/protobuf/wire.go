@@ -0,0 +1,197 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Encoding is the encoding of the event data or command in the envelope.
+type Encoding uint64
+
+const (
+	// EncodingProto is used for data implementing proto.Message.
+	EncodingProto Encoding = iota
+	// EncodingJSON is used for all other data.
+	EncodingJSON
+)
+
+// ErrInvalidEnvelope is returned when the envelope can not be decoded.
+var ErrInvalidEnvelope = errors.New("invalid protobuf envelope")
+
+// Deterministic marshaling keeps the map order of structs stable.
+var marshalOptions = proto.MarshalOptions{Deterministic: true}
+
+// Marshals the data as a proto.Message if possible, otherwise as JSON.
+func marshalData(v interface{}) ([]byte, Encoding, error) {
+	if m, ok := v.(proto.Message); ok {
+		b, err := marshalOptions.Marshal(m)
+
+		return b, EncodingProto, err
+	}
+
+	b, err := json.Marshal(v)
+
+	return b, EncodingJSON, err
+}
+
+// Unmarshals the data into v, which must be a pointer, with the encoding used
+// when marshaling.
+func unmarshalData(b []byte, enc Encoding, v interface{}) error {
+	switch enc {
+	case EncodingProto:
+		m, ok := v.(proto.Message)
+		if !ok {
+			return fmt.Errorf("%T does not implement proto.Message", v)
+		}
+
+		return proto.Unmarshal(b, m)
+	case EncodingJSON:
+		return json.Unmarshal(b, v)
+	default:
+		return fmt.Errorf("unknown encoding: %d", enc)
+	}
+}
+
+// Marshals a map as a google.protobuf.Struct. Values not supported by
+// structpb are normalized through JSON first, as the JSON codec would do.
+func marshalStruct(vals map[string]interface{}) ([]byte, error) {
+	s, err := structpb.NewStruct(vals)
+	if err != nil {
+		b, err := json.Marshal(vals)
+		if err != nil {
+			return nil, err
+		}
+
+		var normalized map[string]interface{}
+		if err := json.Unmarshal(b, &normalized); err != nil {
+			return nil, err
+		}
+
+		if s, err = structpb.NewStruct(normalized); err != nil {
+			return nil, err
+		}
+	}
+
+	return marshalOptions.Marshal(s)
+}
+
+// Unmarshals a google.protobuf.Struct into a map.
+func unmarshalStruct(b []byte) (map[string]interface{}, error) {
+	var s structpb.Struct
+	if err := proto.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	return s.AsMap(), nil
+}
+
+// Appends a length delimited field, omitted if empty as in proto3.
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendBytes(b, v)
+}
+
+// Appends a varint field, omitted if zero as in proto3.
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+
+	return protowire.AppendVarint(b, v)
+}
+
+// Iterates over the fields of an envelope, calling f with the value of each
+// length delimited and varint field. Fields of other types are skipped.
+func consumeFields(b []byte, f func(num protowire.Number, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidEnvelope, protowire.ParseError(n))
+		}
+
+		b = b[n:]
+
+		var (
+			v []byte
+			x uint64
+		)
+
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidEnvelope, protowire.ParseError(n))
+		}
+
+		b = b[n:]
+
+		if typ != protowire.BytesType && typ != protowire.VarintType {
+			continue
+		}
+
+		if err := f(num, v, x); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Appends a map<string, string> entry message.
+func appendMapEntry(b []byte, k, v string) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, k)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+
+	return protowire.AppendString(b, v)
+}
+
+// Consumes a map<string, string> entry message.
+func consumeMapEntry(b []byte) (string, string, error) {
+	var k, v string
+
+	err := consumeFields(b, func(num protowire.Number, b []byte, _ uint64) error {
+		switch num {
+		case 1:
+			k = string(b)
+		case 2:
+			v = string(b)
+		}
+
+		return nil
+	})
+
+	return k, v, err
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+)
+
+// ErrorHandler handles async errors from event buses, outboxes and other
+// components working in the background. It is called with every error, before
+// the error is sent on the errors channel of the component, and never misses
+// errors when that channel is full. It must be safe for concurrent use.
+//
+// Errors are typically of type *EventBusError or *OutboxError, which contain
+// the context, event and handler type of the error.
+type ErrorHandler interface {
+	// HandleError handles an error.
+	HandleError(error)
+}
+
+// ErrorHandlerFunc is a function that can be used as an error handler.
+type ErrorHandlerFunc func(error)
+
+// HandleError implements the HandleError method of the ErrorHandler.
+func (f ErrorHandlerFunc) HandleError(err error) {
+	f(err)
+}
+
+// IsRetryable returns true if the error is an EventBusError or OutboxError
+// for an operation that will be retried.
+func IsRetryable(err error) bool {
+	var busErr *EventBusError
+	if errors.As(err, &busErr) {
+		return busErr.Retryable
+	}
+
+	var outboxErr *OutboxError
+	if errors.As(err, &outboxErr) {
+		return outboxErr.Retryable
+	}
+
+	return false
+}
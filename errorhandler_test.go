@@ -0,0 +1,78 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorHandlerFunc(t *testing.T) {
+	var handled error
+
+	h := ErrorHandlerFunc(func(err error) {
+		handled = err
+	})
+
+	err := errors.New("error")
+	h.HandleError(err)
+
+	if handled != err {
+		t.Error("the error should be handled:", handled)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	testCases := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"plain error": {
+			errors.New("error"),
+			false,
+		},
+		"event bus error": {
+			&EventBusError{Err: errors.New("error")},
+			false,
+		},
+		"retryable event bus error": {
+			&EventBusError{Err: errors.New("error"), Retryable: true},
+			true,
+		},
+		"wrapped retryable event bus error": {
+			fmt.Errorf("wrapped: %w", &EventBusError{Err: errors.New("error"), Retryable: true}),
+			true,
+		},
+		"outbox error": {
+			&OutboxError{Err: errors.New("error")},
+			false,
+		},
+		"retryable outbox error": {
+			&OutboxError{Err: errors.New("error"), Retryable: true},
+			true,
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			if IsRetryable(tc.err) != tc.retryable {
+				t.Errorf("the error should be classified as retryable %v: %s", tc.retryable, tc.err)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ package eventhorizon
 import (
 	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -48,6 +49,14 @@ type Event interface {
 
 	// Metadata is app-specific metadata such as request ID, originating user etc.
 	Metadata() map[string]interface{}
+	// MetadataInt returns an integral numeric metadata value as an int.
+	MetadataInt(key string) (int, bool)
+	// MetadataFloat returns a numeric metadata value as a float64.
+	MetadataFloat(key string) (float64, bool)
+	// MetadataString returns a string metadata value.
+	MetadataString(key string) (string, bool)
+	// MetadataTime returns a time metadata value, also parsing RFC 3339 strings.
+	MetadataTime(key string) (time.Time, bool)
 
 	// A string representation of the event.
 	String() string
@@ -195,6 +204,33 @@ func (e event) Metadata() map[string]interface{} {
 	return e.metadata
 }
 
+// MetadataInt implements the MetadataInt method of the Event interface.
+func (e event) MetadataInt(key string) (int, bool) {
+	i, ok := metadataInt(e.metadata[key])
+	if !ok || i < math.MinInt || i > math.MaxInt {
+		return 0, false
+	}
+
+	return int(i), true
+}
+
+// MetadataFloat implements the MetadataFloat method of the Event interface.
+func (e event) MetadataFloat(key string) (float64, bool) {
+	return metadataFloat(e.metadata[key])
+}
+
+// MetadataString implements the MetadataString method of the Event interface.
+func (e event) MetadataString(key string) (string, bool) {
+	s, ok := e.metadata[key].(string)
+
+	return s, ok
+}
+
+// MetadataTime implements the MetadataTime method of the Event interface.
+func (e event) MetadataTime(key string) (time.Time, bool) {
+	return metadataTime(e.metadata[key])
+}
+
 // String implements the String method of the Event interface.
 func (e event) String() string {
 	str := string(e.eventType)
@@ -37,6 +37,16 @@ type EventBus interface {
 	Close() error
 }
 
+// EventHandlerRemover is an optional interface for an EventBus or Outbox that
+// supports removing added handlers, for example to attach and detach handlers
+// at runtime.
+type EventHandlerRemover interface {
+	// RemoveHandler removes the handler of the handler type and waits for it to
+	// stop handling events. Returns ErrHandlerNotFound if the handler has not
+	// been added.
+	RemoveHandler(context.Context, EventHandlerType) error
+}
+
 var (
 	// ErrMissingMatcher is returned when calling AddHandler without a matcher.
 	ErrMissingMatcher = errors.New("missing matcher")
@@ -44,6 +54,12 @@ var (
 	ErrMissingHandler = errors.New("missing handler")
 	// ErrHandlerAlreadyAdded is returned when calling AddHandler weth the same handler twice.
 	ErrHandlerAlreadyAdded = errors.New("handler already added")
+	// ErrHandlerNotFound is returned when calling RemoveHandler with a handler
+	// type that has not been added.
+	ErrHandlerNotFound = errors.New("handler not found")
+	// ErrRemoveHandlerNotSupported is returned when calling RemoveHandler on a
+	// wrapper around an event bus or outbox that does not support it.
+	ErrRemoveHandlerNotSupported = errors.New("removing handlers not supported")
 )
 
 // EventBusError is an async error containing the error returned from a handler
@@ -55,6 +71,11 @@ type EventBusError struct {
 	Ctx context.Context
 	// Event is the event handeled when the error happened.
 	Event Event
+	// HandlerType is the type of the handler that failed, if any.
+	HandlerType EventHandlerType
+	// Retryable is true if the failed operation will be retried, for example
+	// by delivering the event to the handler again.
+	Retryable bool
 }
 
 // Error implements the Error method of the error interface.
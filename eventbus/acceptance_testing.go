@@ -26,6 +26,7 @@ import (
 	"github.com/kr/pretty"
 
 	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
 	"github.com/reidlai/eventhorizon/middleware/eventhandler/observer"
 	"github.com/reidlai/eventhorizon/mocks"
 	"github.com/reidlai/eventhorizon/uuid"
@@ -54,6 +55,91 @@ func TestAddHandler(t *testing.T, bus1 eh.EventBus) {
 	}
 }
 
+// TestRemoveHandler tests removing handlers for implementations of EventBus
+// which also implements eh.EventHandlerRemover.
+func TestRemoveHandler(t *testing.T, bus eh.EventBus, timeout time.Duration) {
+	ctx := context.Background()
+
+	r, ok := bus.(eh.EventHandlerRemover)
+	if !ok {
+		t.Fatal("the bus should be an eh.EventHandlerRemover")
+	}
+
+	// Error on non-added handler.
+	if err := r.RemoveHandler(ctx, "not-added"); err != eh.ErrHandlerNotFound {
+		t.Error("the error should be correct:", err)
+	}
+
+	removedHandler := mocks.NewEventHandler("removed")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, removedHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	otherHandler := mocks.NewEventHandler("other")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, otherHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	time.Sleep(timeout) // Need to wait here for handlers to be added.
+
+	if err := r.RemoveHandler(ctx, removedHandler.HandlerType()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Error on already removed handler.
+	if err := r.RemoveHandler(ctx, removedHandler.HandlerType()); err != eh.ErrHandlerNotFound {
+		t.Error("the error should be correct:", err)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := bus.HandleEvent(ctx, event1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !otherHandler.Wait(timeout) {
+		t.Error("did not receive event in time")
+	}
+
+	if removedHandler.Wait(timeout) {
+		t.Error("the removed handler should not receive the event")
+	}
+
+	// Adding the same handler type again should be possible.
+	addedHandler := mocks.NewEventHandler("removed")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, addedHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	time.Sleep(timeout) // Need to wait here for handlers to be added.
+
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := bus.HandleEvent(ctx, event2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !addedHandler.Wait(timeout) {
+		t.Error("did not receive event in time")
+	}
+
+	addedHandler.Lock()
+
+	if !eh.CompareEventSlices(addedHandler.Events, []eh.Event{event2}) {
+		t.Error("the events were incorrect:")
+		t.Log(addedHandler.Events)
+	}
+
+	addedHandler.Unlock()
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
 // AcceptanceTest is the acceptance test that all implementations of EventBus
 // should pass. It should manually be called from a test case in each
 // implementation:
@@ -139,6 +225,18 @@ func AcceptanceTest(t *testing.T, bus1, bus2 eh.EventBus, timeout time.Duration)
 		t.Fatal("there should be no error:", err)
 	}
 
+	// Add ephemeral handlers, which should only receive events published
+	// after they were added, and all events on every bus.
+	ephemeralBus1 := mocks.NewEventHandler("ephemeral")
+	if err := bus1.AddHandler(ctx, eh.MatchEvents{mocks.EventType}, eh.UseEventHandlerMiddleware(ephemeralBus1, ephemeral.NewMiddleware())); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ephemeralBus2 := mocks.NewEventHandler("ephemeral")
+	if err := bus2.AddHandler(ctx, eh.MatchEvents{mocks.EventType}, eh.UseEventHandlerMiddleware(ephemeralBus2, ephemeral.NewMiddleware())); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
 	time.Sleep(timeout) // Need to wait here for handlers to be added.
 
 	// Event with data.
@@ -235,6 +333,82 @@ func AcceptanceTest(t *testing.T, bus1, bus2 eh.EventBus, timeout time.Duration)
 		t.Error("the context should be correct:", observerBus2.Context)
 	}
 
+	// Check the ephemeral handler, which should not receive event1 that was
+	// published before it was added.
+	if !ephemeralBus1.Wait(timeout) {
+		t.Error("did not receive event in time")
+	}
+
+	if !eh.CompareEventSlices(ephemeralBus1.Events, expectedEvents) {
+		t.Error("the events were incorrect:")
+		t.Log(ephemeralBus1.Events)
+	}
+
+	if !ephemeralBus2.Wait(timeout) {
+		t.Error("did not receive event in time")
+	}
+
+	if !eh.CompareEventSlices(ephemeralBus2.Events, expectedEvents) {
+		t.Error("the events were incorrect:")
+		t.Log(ephemeralBus2.Events)
+	}
+
+	// Nothing should be kept for a removed ephemeral handler, when added again
+	// it should not receive events published while it was removed. The
+	// ephemeral handler on the other bus should keep receiving all events.
+	if r, ok := bus1.(eh.EventHandlerRemover); ok {
+		if err := r.RemoveHandler(ctx, ephemeralBus1.HandlerType()); err != nil {
+			t.Error("there should be no error:", err)
+		}
+
+		eventWhileRemoved := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "while removed"}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+		if err := bus1.HandleEvent(ctx, eventWhileRemoved); err != nil {
+			t.Error("there should be no error:", err)
+		}
+
+		ephemeralAgain := mocks.NewEventHandler("ephemeral")
+		if err := bus1.AddHandler(ctx, eh.MatchEvents{mocks.EventType}, eh.UseEventHandlerMiddleware(ephemeralAgain, ephemeral.NewMiddleware())); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		time.Sleep(timeout) // Need to wait here for handlers to be added.
+
+		eventAfterAdded := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "after added"}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+		if err := bus1.HandleEvent(ctx, eventAfterAdded); err != nil {
+			t.Error("there should be no error:", err)
+		}
+
+		if !ephemeralAgain.Wait(timeout) {
+			t.Error("did not receive event in time")
+		}
+
+		if ephemeralAgain.Wait(timeout) {
+			t.Error("there should be no other events")
+		}
+
+		ephemeralAgain.Lock()
+		if !eh.CompareEventSlices(ephemeralAgain.Events, []eh.Event{eventAfterAdded}) {
+			t.Error("the events were incorrect:")
+			t.Log(ephemeralAgain.Events)
+		}
+		ephemeralAgain.Unlock()
+
+		for i := 0; i < 2; i++ {
+			if !ephemeralBus2.Wait(timeout) {
+				t.Error("did not receive event in time")
+			}
+		}
+
+		ephemeralBus2.Lock()
+		if !eh.CompareEventSlices(ephemeralBus2.Events, []eh.Event{event2, eventWhileRemoved, eventAfterAdded}) {
+			t.Error("the events were incorrect:")
+			t.Log(ephemeralBus2.Events)
+		}
+		ephemeralBus2.Unlock()
+	}
+
 	// Check and clear all errors before the error tests.
 	checkBusErrors(t, bus1)
 	checkBusErrors(t, bus2)
@@ -292,14 +466,71 @@ func checkBusErrors(t *testing.T, bus eh.EventBus) {
 	}
 }
 
+// TestHandleEvents tests publishing events in a batch, for implementations of
+// EventBus which also implements eh.EventBatchHandler.
+func TestHandleEvents(t *testing.T, bus eh.EventBus, timeout time.Duration) {
+	ctx := context.Background()
+
+	if _, ok := bus.(eh.EventBatchHandler); !ok {
+		t.Fatal("the bus should be an eh.EventBatchHandler")
+	}
+
+	handler := mocks.NewEventHandler("batch-handler")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	time.Sleep(timeout) // Need to wait here for handlers to be added.
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	var events []eh.Event
+
+	for i := 1; i <= 3; i++ {
+		events = append(events, eh.NewEvent(mocks.EventType,
+			&mocks.EventData{Content: fmt.Sprintf("event%d", i)}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, id, i)))
+	}
+
+	if err := eh.HandleEvents(ctx, bus, events); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	for range events {
+		if !handler.Wait(timeout) {
+			t.Fatal("did not receive event in time")
+		}
+	}
+
+	handler.Lock()
+
+	if !eh.CompareEventSlices(handler.Events, events) {
+		t.Error("the events should be received in order:")
+		t.Log(handler.Events)
+	}
+
+	handler.Unlock()
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
 // LoadTest is a load test for an event bus implementation.
 func LoadTest(t *testing.T, bus eh.EventBus) {
-	benchmark(&testAsBench{t}, bus, 20, 10, 1000)
+	benchmark(&testAsBench{t}, bus, 20, 10, 1000, 1)
 }
 
 // Benchmark is a benchmark for an event bus implementation.
 func Benchmark(b *testing.B, bus eh.EventBus) {
-	benchmark(b, bus, 20, 10, b.N)
+	benchmark(b, bus, 20, 10, b.N, 1)
+}
+
+// BenchmarkBatch is a benchmark for an event bus implementation, publishing
+// events in batches of the size with eh.HandleEvents.
+func BenchmarkBatch(b *testing.B, bus eh.EventBus, batchSize int) {
+	benchmark(b, bus, 20, 10, b.N, batchSize)
 }
 
 type bench interface {
@@ -318,7 +549,7 @@ type testAsBench struct {
 func (b *testAsBench) ResetTimer() {}
 func (b *testAsBench) StopTimer()  {}
 
-func benchmark(t bench, bus eh.EventBus, numAggregates, numHandlers, numEvents int) {
+func benchmark(t bench, bus eh.EventBus, numAggregates, numHandlers, numEvents, batchSize int) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	handlers := make([]*mocks.EventHandler, numHandlers)
@@ -378,6 +609,8 @@ func benchmark(t bench, bus eh.EventBus, numAggregates, numHandlers, numEvents i
 
 	t.ResetTimer()
 
+	batch := make([]eh.Event, 0, batchSize)
+
 	for n := 0; n < numEvents; n++ {
 		a := aggregates[rand.Intn(len(aggregates))]
 		a.version++
@@ -387,8 +620,20 @@ func benchmark(t bench, bus eh.EventBus, numAggregates, numHandlers, numEvents i
 			mocks.EventType, &mocks.EventData{Content: fmt.Sprintf("event%d", n)}, timestamp,
 			eh.ForAggregate(mocks.AggregateType, a.id, a.version))
 
-		if err := bus.HandleEvent(ctx, e); err != nil {
-			t.Error("there should be no error:", err)
+		if batchSize <= 1 {
+			if err := bus.HandleEvent(ctx, e); err != nil {
+				t.Error("there should be no error:", err)
+			}
+
+			continue
+		}
+
+		if batch = append(batch, e); len(batch) == batchSize || n == numEvents-1 {
+			if err := eh.HandleEvents(ctx, bus, batch); err != nil {
+				t.Error("there should be no error:", err)
+			}
+
+			batch = batch[:0]
 		}
 	}
 
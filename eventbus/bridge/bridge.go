@@ -0,0 +1,215 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	eh "github.com/reidlai/eventhorizon"
+)
+
+// BridgedKey is the metadata key used to mark bridged events, with the name of
+// the bridge as value.
+const BridgedKey = "eh_bridged_by"
+
+// MapFunc maps an event before it is published to the target event bus. The
+// event is skipped if the returned event is nil.
+type MapFunc func(context.Context, eh.Event) (eh.Event, error)
+
+// Bridge forwards events from a source event bus to a target event bus, for
+// example when migrating between event bus implementations.
+//
+// Bridged events are marked in their metadata, and marked events are never
+// bridged again. This prevents loops when bridging in both directions, but
+// also means that bridges can not be chained.
+type Bridge struct {
+	name         string
+	source       eh.EventBus
+	target       eh.EventHandler
+	matcher      eh.EventMatcher
+	mapFunc      MapFunc
+	errCh        chan error
+	errorHandler eh.ErrorHandler
+}
+
+// NewBridge creates a Bridge which forwards matching events from the source
+// to the target event bus. The name must be unique for the source event bus,
+// as it is used for the handler type.
+func NewBridge(ctx context.Context, name string, source eh.EventBus, target eh.EventHandler, m eh.EventMatcher, options ...Option) (*Bridge, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+
+	if source == nil {
+		return nil, errors.New("missing source event bus")
+	}
+
+	if target == nil {
+		return nil, errors.New("missing target event bus")
+	}
+
+	if m == nil {
+		return nil, eh.ErrMissingMatcher
+	}
+
+	b := &Bridge{
+		name:    name,
+		source:  source,
+		target:  target,
+		matcher: m,
+		errCh:   make(chan error, 100),
+	}
+
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+
+		if err := option(b); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	if err := source.AddHandler(ctx, eh.MatchAll{}, b); err != nil {
+		return nil, fmt.Errorf("could not add bridge to source event bus: %w", err)
+	}
+
+	return b, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Bridge) error
+
+// WithMapping maps events before publishing them to the target event bus.
+func WithMapping(f MapFunc) Option {
+	return func(b *Bridge) error {
+		if f == nil {
+			return errors.New("missing map func")
+		}
+
+		b.mapFunc = f
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all errors, in addition to
+// sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *Bridge) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (b *Bridge) HandlerType() eh.EventHandlerType {
+	return eh.EventHandlerType("bridge_" + b.name)
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler
+// interface. Errors are both returned, to let the source event bus retry the
+// event if supported, and reported on the errors channel.
+func (b *Bridge) HandleEvent(ctx context.Context, event eh.Event) error {
+	// Skip events which have already been bridged.
+	if _, ok := event.Metadata()[BridgedKey]; ok {
+		return nil
+	}
+
+	if !b.matcher.Match(event) {
+		return nil
+	}
+
+	if b.mapFunc != nil {
+		mapped, err := b.mapFunc(ctx, event)
+		if err != nil {
+			return b.error(ctx, event, fmt.Errorf("could not map event: %w", err))
+		} else if mapped == nil {
+			return nil
+		}
+
+		event = mapped
+	}
+
+	if err := b.target.HandleEvent(ctx, markBridged(event, b.name)); err != nil {
+		return b.error(ctx, event, fmt.Errorf("could not publish event to target: %w", err))
+	}
+
+	return nil
+}
+
+// Errors returns an error channel where async errors are reported.
+func (b *Bridge) Errors() <-chan error {
+	return b.errCh
+}
+
+// Close removes the bridge from the source event bus, if supported. The event
+// buses are not closed.
+func (b *Bridge) Close() error {
+	r, ok := b.source.(eh.EventHandlerRemover)
+	if !ok {
+		return nil
+	}
+
+	if err := r.RemoveHandler(context.Background(), b.HandlerType()); err != nil {
+		return fmt.Errorf("could not remove bridge from source event bus: %w", err)
+	}
+
+	return nil
+}
+
+// Sends the error to the error handler, if any, and on the errors channel.
+// The source event bus decides if the event is retried.
+func (b *Bridge) error(ctx context.Context, event eh.Event, err error) error {
+	busErr := &eh.EventBusError{Err: err, Ctx: ctx, Event: event, HandlerType: b.HandlerType()}
+
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(busErr)
+	}
+
+	select {
+	case b.errCh <- busErr:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in event bus bridge: %s", err)
+		}
+	}
+
+	return err
+}
+
+// Returns a copy of the event marked as bridged.
+func markBridged(event eh.Event, name string) eh.Event {
+	metadata := make(map[string]interface{}, len(event.Metadata())+1)
+	for k, v := range event.Metadata() {
+		metadata[k] = v
+	}
+
+	metadata[BridgedKey] = name
+
+	return eh.NewEvent(event.EventType(), event.Data(), event.Timestamp(),
+		eh.ForAggregate(event.AggregateType(), event.AggregateID(), event.Version()),
+		eh.WithMetadata(metadata),
+	)
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/eventbus/local"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+func TestBridge(t *testing.T) {
+	ctx := context.Background()
+	source := local.NewEventBus()
+	target := local.NewEventBus()
+
+	defer source.Close()
+	defer target.Close()
+
+	handler := mocks.NewEventHandler("handler")
+	if err := target.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	b, err := NewBridge(ctx, "test", source, target, eh.MatchEvents{mocks.EventType})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer b.Close()
+
+	if b.HandlerType() != "bridge_test" {
+		t.Error("the handler type should be correct:", b.HandlerType())
+	}
+
+	// Events not matching should not be bridged.
+	other := eh.NewEvent(mocks.EventOtherType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := source.HandleEvent(ctx, other); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if handler.Wait(100 * time.Millisecond) {
+		t.Error("the other event should not be bridged")
+	}
+
+	event := newTestEvent("event")
+	if err := source.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	handler.Lock()
+	defer handler.Unlock()
+
+	if len(handler.Events) != 1 {
+		t.Fatal("there should be one event:", handler.Events)
+	}
+
+	expected := eh.NewEvent(event.EventType(), event.Data(), event.Timestamp(),
+		eh.ForAggregate(event.AggregateType(), event.AggregateID(), event.Version()),
+		eh.WithMetadata(map[string]interface{}{BridgedKey: "test"}),
+	)
+	if err := eh.CompareEvents(handler.Events[0], expected); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+}
+
+func TestBridgeLoop(t *testing.T) {
+	ctx := context.Background()
+	bus1 := local.NewEventBus()
+	bus2 := local.NewEventBus()
+
+	defer bus1.Close()
+	defer bus2.Close()
+
+	handler1 := mocks.NewEventHandler("handler")
+	if err := bus1.AddHandler(ctx, eh.MatchAll{}, handler1); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	handler2 := mocks.NewEventHandler("handler")
+	if err := bus2.AddHandler(ctx, eh.MatchAll{}, handler2); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	b1, err := NewBridge(ctx, "forward", bus1, bus2, eh.MatchAll{})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer b1.Close()
+
+	b2, err := NewBridge(ctx, "backward", bus2, bus1, eh.MatchAll{})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer b2.Close()
+
+	if err := bus1.HandleEvent(ctx, newTestEvent("event")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler1.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	if !handler2.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	// The event should not be bridged back.
+	if handler1.Wait(100 * time.Millisecond) {
+		t.Error("the event should not be bridged back")
+	}
+
+	handler1.Lock()
+	defer handler1.Unlock()
+
+	if len(handler1.Events) != 1 {
+		t.Error("there should be one event:", handler1.Events)
+	}
+}
+
+func TestBridgeMapping(t *testing.T) {
+	ctx := context.Background()
+	source := &mocks.EventBus{}
+	target := &mocks.EventBus{}
+
+	b, err := NewBridge(ctx, "test", source, target, eh.MatchAll{},
+		WithMapping(func(ctx context.Context, event eh.Event) (eh.Event, error) {
+			data, _ := event.Data().(*mocks.EventData)
+			if data.Content == "drop" {
+				return nil, nil
+			}
+
+			return eh.NewEvent(mocks.EventOtherType, &mocks.EventData{Content: "mapped"}, event.Timestamp(),
+				eh.ForAggregate(event.AggregateType(), event.AggregateID(), event.Version()),
+				eh.WithMetadata(map[string]interface{}{"num": 1}),
+			), nil
+		}),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := b.HandleEvent(ctx, newTestEvent("drop")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(target.Events) != 0 {
+		t.Fatal("the dropped event should not be bridged:", target.Events)
+	}
+
+	event := newTestEvent("event")
+	if err := b.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(target.Events) != 1 {
+		t.Fatal("there should be one event:", target.Events)
+	}
+
+	expected := eh.NewEvent(mocks.EventOtherType, &mocks.EventData{Content: "mapped"}, event.Timestamp(),
+		eh.ForAggregate(event.AggregateType(), event.AggregateID(), event.Version()),
+		eh.WithMetadata(map[string]interface{}{"num": 1, BridgedKey: "test"}),
+	)
+	if err := eh.CompareEvents(target.Events[0], expected); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+
+	// Mapping errors should be both returned and reported.
+	mapErr := errors.New("map error")
+	b.mapFunc = func(ctx context.Context, event eh.Event) (eh.Event, error) {
+		return nil, mapErr
+	}
+
+	if err := b.HandleEvent(ctx, event); !errors.Is(err, mapErr) {
+		t.Error("the error should be correct:", err)
+	}
+
+	select {
+	case err := <-b.Errors():
+		if !errors.Is(err, mapErr) {
+			t.Error("the error should be correct:", err)
+		}
+	default:
+		t.Error("there should be an error")
+	}
+}
+
+func TestBridgeTargetError(t *testing.T) {
+	ctx := context.Background()
+	targetErr := errors.New("target error")
+	target := &mocks.EventBus{Err: targetErr}
+
+	b, err := NewBridge(ctx, "test", &mocks.EventBus{}, target, eh.MatchAll{})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent("event")
+	if err := b.HandleEvent(ctx, event); !errors.Is(err, targetErr) {
+		t.Error("the error should be correct:", err)
+	}
+
+	select {
+	case err := <-b.Errors():
+		var busErr *eh.EventBusError
+		if !errors.As(err, &busErr) || !errors.Is(err, targetErr) {
+			t.Error("the error should be correct:", err)
+		}
+
+		if busErr != nil && busErr.Event != event {
+			t.Error("the error should contain the event:", busErr.Event)
+		}
+	default:
+		t.Error("there should be an error")
+	}
+}
+
+func TestNewBridge(t *testing.T) {
+	ctx := context.Background()
+	bus := &mocks.EventBus{}
+
+	testCases := map[string]struct {
+		name          string
+		source        eh.EventBus
+		target        eh.EventHandler
+		matcher       eh.EventMatcher
+		option        Option
+		expectedError string
+	}{
+		"missing name": {
+			"", bus, bus, eh.MatchAll{}, nil,
+			"missing name",
+		},
+		"missing source": {
+			"test", nil, bus, eh.MatchAll{}, nil,
+			"missing source event bus",
+		},
+		"missing target": {
+			"test", bus, nil, eh.MatchAll{}, nil,
+			"missing target event bus",
+		},
+		"missing matcher": {
+			"test", bus, bus, nil, nil,
+			eh.ErrMissingMatcher.Error(),
+		},
+		"missing map func": {
+			"test", bus, bus, eh.MatchAll{}, WithMapping(nil),
+			"missing map func",
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewBridge(ctx, tc.name, tc.source, tc.target, tc.matcher, tc.option)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectedError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func newTestEvent(content string) eh.Event {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	return eh.NewEvent(mocks.EventType, &mocks.EventData{Content: content}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+// DefaultPollInterval is the default interval for polling the event store for
+// new events, when not notified about them.
+var DefaultPollInterval = time.Second
+
+// DefaultBatchSize is the default max number of events loaded at a time.
+var DefaultBatchSize = 100
+
+// DefaultRetryInterval is the default interval for retrying an event which a
+// handler failed to handle.
+var DefaultRetryInterval = time.Second
+
+// EventBus is an event bus which needs no broker. Each handler catches up on
+// the events in an event store in the order they were saved, starting from a
+// persisted checkpoint, and then tails new events. Events are delivered at
+// least once.
+//
+// The store is polled for new events, and the event bus can also be notified
+// about saved events by adding it as an event handler of the store.
+//
+// As all instances using the same checkpoints handle all events, each handler
+// type should only be added in one instance of an app. Use the observer
+// middleware to handle events in every instance, with separate checkpoints.
+type EventBus struct {
+	store          eh.GlobalEventLoader
+	repo           eh.ReadWriteRepo
+	pollInterval   time.Duration
+	retryInterval  time.Duration
+	batchSize      int
+	deleteOnRemove bool
+	registered     map[eh.EventHandlerType]*registration
+	registeredMu   sync.RWMutex
+	errCh          chan error
+	errorHandler   eh.ErrorHandler
+	cctx           context.Context
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+}
+
+// NewEventBus creates an EventBus for an event store with global positions,
+// which stores checkpoints in the repo. The entity factory of the repo should
+// be set to create a *Checkpoint, if it is needed.
+func NewEventBus(store eh.GlobalEventLoader, repo eh.ReadWriteRepo, options ...Option) (*EventBus, error) {
+	if store == nil {
+		return nil, errors.New("missing event store")
+	}
+
+	if repo == nil {
+		return nil, errors.New("missing checkpoint repo")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := &EventBus{
+		store:         store,
+		repo:          repo,
+		pollInterval:  DefaultPollInterval,
+		retryInterval: DefaultRetryInterval,
+		batchSize:     DefaultBatchSize,
+		registered:    map[eh.EventHandlerType]*registration{},
+		errCh:         make(chan error, 100),
+		cctx:          ctx,
+		cancel:        cancel,
+	}
+
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+
+		if err := option(b); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventBus) error
+
+// WithPollInterval sets the interval for polling the event store for new
+// events, when not notified about them.
+func WithPollInterval(d time.Duration) Option {
+	return func(b *EventBus) error {
+		if d <= 0 {
+			return errors.New("poll interval must be positive")
+		}
+
+		b.pollInterval = d
+
+		return nil
+	}
+}
+
+// WithRetryInterval sets the interval for retrying an event which a handler
+// failed to handle.
+func WithRetryInterval(d time.Duration) Option {
+	return func(b *EventBus) error {
+		if d <= 0 {
+			return errors.New("retry interval must be positive")
+		}
+
+		b.retryInterval = d
+
+		return nil
+	}
+}
+
+// WithBatchSize sets the max number of events loaded at a time.
+func WithBatchSize(n int) Option {
+	return func(b *EventBus) error {
+		if n <= 0 {
+			return errors.New("batch size must be positive")
+		}
+
+		b.batchSize = n
+
+		return nil
+	}
+}
+
+// WithDeleteCheckpointOnRemove deletes the checkpoint of a handler when it is
+// removed with RemoveHandler, which makes the handler catch up from the first
+// event when added again.
+func WithDeleteCheckpointOnRemove() Option {
+	return func(b *EventBus) error {
+		b.deleteOnRemove = true
+
+		return nil
+	}
+}
+
+// Checkpoint is the global position of the last event handled by a handler.
+type Checkpoint struct {
+	ID          uuid.UUID           `json:"id"           bson:"_id"`
+	HandlerType eh.EventHandlerType `json:"handler_type" bson:"handler_type"`
+	Position    int                 `json:"position"     bson:"position"`
+	UpdatedAt   time.Time           `json:"updated_at"   bson:"updated_at"`
+}
+
+// EntityID implements the EntityID method of the eventhorizon.Entity interface.
+func (c *Checkpoint) EntityID() uuid.UUID {
+	return c.ID
+}
+
+// Namespace for the checkpoint IDs, which are derived from the handler type.
+var checkpointNamespace = uuid.MustParse("7ad7e1f4-6c1f-4b0c-9a53-1f4f0d5f3c1e")
+
+// CheckpointID returns the ID of the checkpoint for a handler type.
+func CheckpointID(handlerType eh.EventHandlerType) uuid.UUID {
+	return uuid.NewSHA1(checkpointNamespace, []byte(handlerType))
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (b *EventBus) HandlerType() eh.EventHandlerType {
+	return "eventbus"
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler
+// interface. The events are already saved in the store, handling them only
+// notifies the handlers to load them without waiting for the next poll.
+func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	b.registeredMu.RLock()
+	defer b.registeredMu.RUnlock()
+
+	for _, r := range b.registered {
+		select {
+		case r.notify <- struct{}{}:
+		default:
+			// Already notified.
+		}
+	}
+
+	return nil
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus
+// interface. Handlers catch up from their checkpoint, or from the first event
+// if they have none. Ephemeral handlers start from the last event and don't
+// store any checkpoint.
+func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
+	if m == nil {
+		return eh.ErrMissingMatcher
+	}
+
+	if h == nil {
+		return eh.ErrMissingHandler
+	}
+
+	// Check handler existence.
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	if _, ok := b.registered[h.HandlerType()]; ok {
+		return eh.ErrHandlerAlreadyAdded
+	}
+
+	isEphemeral := ephemeral.IsEphemeral(h)
+
+	var (
+		position int
+		err      error
+	)
+
+	if isEphemeral {
+		if position, err = b.store.LastPosition(ctx); err != nil {
+			return fmt.Errorf("could not get last position: %w", err)
+		}
+	} else if position, err = b.loadCheckpoint(ctx, h.HandlerType()); err != nil {
+		return err
+	}
+
+	// Register handler.
+	hctx, cancel := context.WithCancel(b.cctx)
+	r := &registration{
+		ephemeral: isEphemeral,
+		notify:    make(chan struct{}, 1),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	b.registered[h.HandlerType()] = r
+
+	b.wg.Add(1)
+
+	// Handle until context is cancelled.
+	go b.handle(hctx, m, h, r, position)
+
+	return nil
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	r, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop handling and wait for any ongoing handling to finish.
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !b.deleteOnRemove || r.ephemeral {
+		return nil
+	}
+
+	if err := b.repo.Remove(ctx, CheckpointID(handlerType)); err != nil && !errors.Is(err, eh.ErrEntityNotFound) {
+		return fmt.Errorf("could not delete checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// Errors implements the Errors method of the eventhorizon.EventBus interface.
+func (b *EventBus) Errors() <-chan error {
+	return b.errCh
+}
+
+// Close implements the Close method of the eventhorizon.EventBus interface.
+// The event store and checkpoint repo are not closed.
+func (b *EventBus) Close() error {
+	b.cancel()
+	b.wg.Wait()
+
+	return nil
+}
+
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	ephemeral bool
+	notify    chan struct{}
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// Loads the checkpoint position of a handler, 0 if there is none.
+func (b *EventBus) loadCheckpoint(ctx context.Context, handlerType eh.EventHandlerType) (int, error) {
+	entity, err := b.repo.Find(ctx, CheckpointID(handlerType))
+	if errors.Is(err, eh.ErrEntityNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("could not load checkpoint: %w", err)
+	}
+
+	c, ok := entity.(*Checkpoint)
+	if !ok {
+		return 0, fmt.Errorf("checkpoint is of incorrect type %T", entity)
+	}
+
+	return c.Position, nil
+}
+
+// Saves the checkpoint position of a handler.
+func (b *EventBus) saveCheckpoint(ctx context.Context, handlerType eh.EventHandlerType, position int) error {
+	if err := b.repo.Save(ctx, &Checkpoint{
+		ID:          CheckpointID(handlerType),
+		HandlerType: handlerType,
+		Position:    position,
+		UpdatedAt:   time.Now(),
+	}); err != nil {
+		return fmt.Errorf("could not save checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// Handles all events after the position until the context is cancelled.
+func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, r *registration, position int) {
+	defer b.wg.Done()
+	defer close(r.done)
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	checkpoint := position
+
+	// Saves the checkpoint if the handler has made progress since last time.
+	save := func() {
+		if r.ephemeral || position == checkpoint {
+			return
+		}
+
+		// Use a new context to save progress also when stopping.
+		if err := b.saveCheckpoint(context.Background(), h.HandlerType(), position); err != nil {
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
+
+			return
+		}
+
+		checkpoint = position
+	}
+	defer save()
+
+	for {
+		events, err := b.store.LoadAll(ctx, position, b.batchSize)
+		if errors.Is(err, context.Canceled) {
+			return
+		} else if err != nil {
+			err = fmt.Errorf("could not load events: %w", err)
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
+		}
+
+		failed := false
+
+		for _, e := range events {
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Ignore non-matching events.
+			if m.Match(e.Event) {
+				if err := h.HandleEvent(ctx, e.Event); err != nil {
+					err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
+					b.sendError(&eh.EventBusError{
+						Err:         err,
+						Ctx:         ctx,
+						Event:       e.Event,
+						HandlerType: h.HandlerType(),
+						Retryable:   true,
+					})
+
+					// Retry from the failed event.
+					failed = true
+
+					break
+				}
+			}
+
+			position = e.Position
+		}
+
+		save()
+
+		// Load the next batch directly if there could be more events.
+		if !failed && err == nil && len(events) == b.batchSize {
+			continue
+		}
+
+		// Wait before retrying after an error.
+		if failed || err != nil {
+			if !sleep(ctx, b.retryInterval) {
+				return
+			}
+
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.notify:
+		}
+	}
+}
+
+// Waits for the duration, returns false if the context is done before that.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in event store event bus: %s", err)
+		}
+	}
+}
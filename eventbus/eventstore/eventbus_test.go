@@ -0,0 +1,380 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/eventstore/memory"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/mocks"
+	repo "github.com/reidlai/eventhorizon/repo/memory"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+func TestAddHandler(t *testing.T) {
+	bus, _, _ := newTestEventBus(t)
+
+	eventbus.TestAddHandler(t, bus)
+}
+
+func TestEventBusCatchUp(t *testing.T) {
+	bus, store, checkpoints := newTestEventBus(t)
+	ctx := context.Background()
+
+	// Events saved before the handler is added should be handled.
+	id := uuid.New()
+	saved := saveTestEvents(t, store, id, 1, 3)
+
+	handler := mocks.NewEventHandler("handler")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	waitForEvents(t, handler, len(saved))
+
+	if !eh.CompareEventSlices(handler.Events, saved) {
+		t.Error("the events should be correct:", handler.Events)
+	}
+
+	// Tail new events.
+	tailed := saveTestEvents(t, store, id, 4, 2)
+
+	waitForEvents(t, handler, len(tailed))
+
+	if !eh.CompareEventSlices(handler.Events, append(saved, tailed...)) {
+		t.Error("the events should be correct:", handler.Events)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	checkCheckpoint(t, checkpoints, handler.HandlerType(), 5)
+
+	// Continue from the checkpoint with a new bus.
+	saveTestEvents(t, store, id, 6, 1)
+
+	bus2, err := NewEventBus(store, checkpoints)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus2.Close()
+
+	handler2 := mocks.NewEventHandler("handler")
+	if err := bus2.AddHandler(ctx, eh.MatchAll{}, handler2); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	waitForEvents(t, handler2, 1)
+
+	if len(handler2.Events) != 1 || handler2.Events[0].Version() != 6 {
+		t.Error("only the new event should be handled:", handler2.Events)
+	}
+}
+
+func TestEventBusMatcher(t *testing.T) {
+	bus, store, checkpoints := newTestEventBus(t)
+	defer bus.Close()
+
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("handler")
+	if err := bus.AddHandler(ctx, eh.MatchEvents{mocks.EventOtherType}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	saveTestEvents(t, store, uuid.New(), 1, 2)
+
+	other := eh.NewEvent(mocks.EventOtherType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := store.Save(ctx, []eh.Event{other}, 0); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	waitForEvents(t, handler, 1)
+
+	if err := eh.CompareEvents(handler.Events[0], other); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Non-matching events should also advance the checkpoint.
+	checkCheckpoint(t, checkpoints, handler.HandlerType(), 3)
+}
+
+func TestEventBusRetry(t *testing.T) {
+	bus, store, checkpoints := newTestEventBus(t, WithRetryInterval(10*time.Millisecond))
+	defer bus.Close()
+
+	ctx := context.Background()
+
+	handler := &failingHandler{
+		EventHandler: mocks.NewEventHandler("handler"),
+		failures:     2,
+	}
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	saved := saveTestEvents(t, store, uuid.New(), 1, 2)
+
+	// The first event should fail twice before being handled.
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-bus.Errors():
+			if !errors.Is(err, errHandler) {
+				t.Error("the error should be correct:", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	inner := handler.EventHandler.(*mocks.EventHandler)
+	waitForEvents(t, inner, len(saved))
+
+	if !eh.CompareEventSlices(inner.Events, saved) {
+		t.Error("the events should be handled in order:", inner.Events)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	checkCheckpoint(t, checkpoints, handler.HandlerType(), 2)
+}
+
+func TestEventBusEphemeralHandler(t *testing.T) {
+	bus, store, checkpoints := newTestEventBus(t)
+	defer bus.Close()
+
+	ctx := context.Background()
+	id := uuid.New()
+
+	saveTestEvents(t, store, id, 1, 2)
+
+	handler := mocks.NewEventHandler("ephemeral")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, eh.UseEventHandlerMiddleware(handler, ephemeral.NewMiddleware())); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Only events saved after adding the handler should be handled.
+	saved := saveTestEvents(t, store, id, 3, 1)
+
+	waitForEvents(t, handler, 1)
+
+	if !eh.CompareEventSlices(handler.Events, saved) {
+		t.Error("the events should be correct:", handler.Events)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := checkpoints.Find(ctx, CheckpointID(handler.HandlerType())); !errors.Is(err, eh.ErrEntityNotFound) {
+		t.Error("there should be no checkpoint:", err)
+	}
+}
+
+func TestEventBusRemoveHandler(t *testing.T) {
+	bus, store, checkpoints := newTestEventBus(t, WithDeleteCheckpointOnRemove())
+	defer bus.Close()
+
+	ctx := context.Background()
+
+	if err := bus.RemoveHandler(ctx, "not-added"); !errors.Is(err, eh.ErrHandlerNotFound) {
+		t.Error("the error should be correct:", err)
+	}
+
+	handler := mocks.NewEventHandler("handler")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	saveTestEvents(t, store, uuid.New(), 1, 1)
+	waitForEvents(t, handler, 1)
+
+	if err := bus.RemoveHandler(ctx, handler.HandlerType()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := checkpoints.Find(ctx, CheckpointID(handler.HandlerType())); !errors.Is(err, eh.ErrEntityNotFound) {
+		t.Error("the checkpoint should be deleted:", err)
+	}
+
+	// Removed handlers should not handle new events.
+	saveTestEvents(t, store, uuid.New(), 1, 1)
+
+	if handler.Wait(50 * time.Millisecond) {
+		t.Error("the removed handler should not handle events")
+	}
+
+	// Adding the handler again should catch up from the first event.
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	waitForEvents(t, handler, 2)
+}
+
+func TestOptions(t *testing.T) {
+	store, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := NewEventBus(nil, repo.NewRepo()); err == nil || err.Error() != "missing event store" {
+		t.Error("the error should be correct:", err)
+	}
+
+	if _, err := NewEventBus(store, nil); err == nil || err.Error() != "missing checkpoint repo" {
+		t.Error("the error should be correct:", err)
+	}
+
+	testCases := map[string]struct {
+		option      Option
+		expectError string
+	}{
+		"invalid poll interval": {
+			WithPollInterval(0),
+			"poll interval must be positive",
+		},
+		"invalid retry interval": {
+			WithRetryInterval(-time.Second),
+			"retry interval must be positive",
+		},
+		"invalid batch size": {
+			WithBatchSize(0),
+			"batch size must be positive",
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus(store, repo.NewRepo(), tc.option)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+var errHandler = errors.New("handler error")
+
+// failingHandler fails a number of times before handling events.
+type failingHandler struct {
+	eh.EventHandler
+	failures int
+}
+
+func (h *failingHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	if h.failures > 0 {
+		h.failures--
+
+		return errHandler
+	}
+
+	return h.EventHandler.HandleEvent(ctx, event)
+}
+
+// Creates an event bus which is notified by a memory event store, and which
+// polls rarely to test the notifications.
+func newTestEventBus(t *testing.T, options ...Option) (*EventBus, *memory.EventStore, *repo.Repo) {
+	t.Helper()
+
+	var bus *EventBus
+
+	store, err := memory.NewEventStore(memory.WithEventHandler(
+		eh.EventHandlerFunc(func(ctx context.Context, event eh.Event) error {
+			return bus.HandleEvent(ctx, event)
+		}),
+	))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	checkpoints := repo.NewRepo()
+	checkpoints.SetEntityFactory(func() eh.Entity { return &Checkpoint{} })
+
+	options = append([]Option{WithPollInterval(time.Hour)}, options...)
+	if bus, err = NewEventBus(store, checkpoints, options...); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	return bus, store, checkpoints
+}
+
+// Saves count events for an aggregate, starting from the version.
+func saveTestEvents(t *testing.T, store eh.EventStore, id uuid.UUID, version, count int) []eh.Event {
+	t.Helper()
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	var events []eh.Event
+
+	for i := 0; i < count; i++ {
+		events = append(events, eh.NewEvent(mocks.EventType,
+			&mocks.EventData{Content: fmt.Sprintf("event%d", version+i)}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, id, version+i)))
+	}
+
+	if err := store.Save(context.Background(), events, version-1); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	return events
+}
+
+func waitForEvents(t *testing.T, h *mocks.EventHandler, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		if !h.Wait(time.Second) {
+			t.Fatalf("did not receive event %d in time", i+1)
+		}
+	}
+}
+
+func checkCheckpoint(t *testing.T, checkpoints eh.ReadRepo, handlerType eh.EventHandlerType, position int) {
+	t.Helper()
+
+	entity, err := checkpoints.Find(context.Background(), CheckpointID(handlerType))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	c, ok := entity.(*Checkpoint)
+	if !ok {
+		t.Fatalf("the checkpoint should be of correct type: %T", entity)
+	}
+
+	if c.Position != position || c.HandlerType != handlerType {
+		t.Errorf("the checkpoint should be correct: %+v", c)
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	eh "github.com/reidlai/eventhorizon"
+)
+
+// ErrBusClosed is returned when publishing on a closed event bus.
+var ErrBusClosed = errors.New("event bus closed")
+
+// ErrQueueFull is reported when publishing with PolicyPrimary and the queue of
+// a secondary event bus is full.
+var ErrQueueFull = errors.New("publish queue full")
+
+// DefaultQueueSize is the default queue size per secondary event bus when
+// publishing with PolicyPrimary.
+var DefaultQueueSize = 1000
+
+// Policy is the policy used when publishing to the event buses.
+type Policy int
+
+const (
+	// PolicyAll publishes to all event buses concurrently and returns an error
+	// if any of them fails.
+	PolicyAll Policy = iota
+	// PolicyBestEffort publishes to all event buses concurrently and only
+	// reports failures on the errors channel.
+	PolicyBestEffort
+	// PolicyPrimary publishes to the primary event bus and returns its error,
+	// while the secondary event buses are published to asynchronously with
+	// failures reported on the errors channel. Events are published in order
+	// to each secondary event bus from a queue of DefaultQueueSize, events are
+	// dropped with ErrQueueFull reported if the queue is full.
+	PolicyPrimary
+)
+
+// String returns the string representation of a policy.
+func (p Policy) String() string {
+	switch p {
+	case PolicyAll:
+		return "all"
+	case PolicyBestEffort:
+		return "best effort"
+	case PolicyPrimary:
+		return "primary"
+	default:
+		return fmt.Sprintf("unknown policy %d", int(p))
+	}
+}
+
+// EventBus is an event bus that publishes events to several event buses, for
+// example one used internally and one used for integrating with other systems.
+// Handlers are added to one of the event buses, the consuming event bus, which
+// is the primary event bus by default.
+//
+// The event buses are owned by the EventBus and are closed with it.
+type EventBus struct {
+	primary      eh.EventBus
+	secondaries  []eh.EventBus
+	consumer     eh.EventBus
+	policy       Policy
+	queues       []chan *publication
+	errCh        chan error
+	errorHandler eh.ErrorHandler
+	cctx         context.Context
+	cancel       context.CancelFunc
+	closeMu      sync.RWMutex
+	wg           sync.WaitGroup
+}
+
+// NewEventBus creates an EventBus publishing to the primary and the secondary
+// event buses.
+func NewEventBus(primary eh.EventBus, secondaries []eh.EventBus, options ...Option) (*EventBus, error) {
+	if primary == nil {
+		return nil, errors.New("missing primary event bus")
+	}
+
+	for _, bus := range secondaries {
+		if bus == nil {
+			return nil, errors.New("missing secondary event bus")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := &EventBus{
+		primary:     primary,
+		secondaries: secondaries,
+		consumer:    primary,
+		policy:      PolicyAll,
+		errCh:       make(chan error, 100),
+		cctx:        ctx,
+		cancel:      cancel,
+	}
+
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+
+		if err := option(b); err != nil {
+			cancel()
+
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	// Combine the errors of all event buses.
+	for _, bus := range b.buses() {
+		b.wg.Add(1)
+
+		go b.forwardErrors(bus.Errors())
+	}
+
+	// Publish to the secondary event buses from a queue per event bus.
+	if b.policy == PolicyPrimary {
+		b.queues = make([]chan *publication, len(b.secondaries))
+
+		for i, bus := range b.secondaries {
+			b.queues[i] = make(chan *publication, DefaultQueueSize)
+			b.wg.Add(1)
+
+			go b.publishQueued(bus, b.queues[i])
+		}
+	}
+
+	return b, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventBus) error
+
+// WithPolicy uses the policy when publishing, the default is PolicyAll.
+func WithPolicy(p Policy) Option {
+	return func(b *EventBus) error {
+		switch p {
+		case PolicyAll, PolicyBestEffort, PolicyPrimary:
+		default:
+			return fmt.Errorf("invalid policy: %s", p)
+		}
+
+		b.policy = p
+
+		return nil
+	}
+}
+
+// WithConsumer adds handlers to the event bus, which must be either the
+// primary or one of the secondary event buses.
+func WithConsumer(bus eh.EventBus) Option {
+	return func(b *EventBus) error {
+		for _, other := range b.buses() {
+			if bus == other {
+				b.consumer = bus
+
+				return nil
+			}
+		}
+
+		return errors.New("consumer must be one of the event buses")
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, including
+// those of the event buses, in addition to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (b *EventBus) HandlerType() eh.EventHandlerType {
+	return "fanout"
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
+func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	return b.publish(ctx, event, func(ctx context.Context, bus eh.EventBus) error {
+		return bus.HandleEvent(ctx, event)
+	})
+}
+
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface.
+func (b *EventBus) HandleEvents(ctx context.Context, events []eh.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	return b.publish(ctx, nil, func(ctx context.Context, bus eh.EventBus) error {
+		return eh.HandleEvents(ctx, bus, events)
+	})
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
+func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
+	return b.consumer.AddHandler(ctx, m, h)
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface, if supported by the consuming
+// event bus.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	r, ok := b.consumer.(eh.EventHandlerRemover)
+	if !ok {
+		return errors.New("consuming event bus does not support removing handlers")
+	}
+
+	return r.RemoveHandler(ctx, handlerType)
+}
+
+// Errors implements the Errors method of the eventhorizon.EventBus interface.
+func (b *EventBus) Errors() <-chan error {
+	return b.errCh
+}
+
+// Close implements the Close method of the eventhorizon.EventBus interface.
+// Ongoing and queued asynchronous publishing is waited for before closing the
+// event buses.
+func (b *EventBus) Close() error {
+	b.closeMu.Lock()
+	if b.cctx.Err() == nil {
+		b.cancel()
+
+		for _, q := range b.queues {
+			close(q)
+		}
+	}
+	b.closeMu.Unlock()
+
+	b.wg.Wait()
+
+	var errs []error
+
+	for _, bus := range b.buses() {
+		if err := bus.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *EventBus) publish(ctx context.Context, event eh.Event, f func(context.Context, eh.EventBus) error) error {
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+
+	if b.cctx.Err() != nil {
+		return ErrBusClosed
+	}
+
+	switch b.policy {
+	case PolicyBestEffort:
+		for _, err := range b.publishAll(ctx, b.buses(), f) {
+			b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, Event: event})
+		}
+
+		return nil
+	case PolicyPrimary:
+		if err := f(ctx, b.primary); err != nil {
+			return err
+		}
+
+		// Detach the context from the caller, keeping the values.
+		asyncCtx := eh.UnmarshalContext(context.Background(), eh.MarshalContext(ctx))
+		p := &publication{ctx: asyncCtx, event: event, f: f}
+
+		for i, q := range b.queues {
+			select {
+			case q <- p:
+			default:
+				err := fmt.Errorf("could not publish to event bus (%s): %w", b.secondaries[i].HandlerType(), ErrQueueFull)
+				b.sendError(&eh.EventBusError{Err: err, Ctx: asyncCtx, Event: event})
+			}
+		}
+
+		return nil
+	default:
+		errs := b.publishAll(ctx, b.buses(), f)
+		if len(errs) > 0 {
+			return fmt.Errorf("could not publish to %d of %d event buses: %w",
+				len(errs), len(b.secondaries)+1, errors.Join(errs...))
+		}
+
+		return nil
+	}
+}
+
+// Publishes to the event buses concurrently, returning all errors.
+func (b *EventBus) publishAll(ctx context.Context, buses []eh.EventBus, f func(context.Context, eh.EventBus) error) []error {
+	errs := make([]error, len(buses))
+
+	var wg sync.WaitGroup
+
+	for i, bus := range buses {
+		wg.Add(1)
+
+		go func(i int, bus eh.EventBus) {
+			defer wg.Done()
+
+			if err := f(ctx, bus); err != nil {
+				errs[i] = fmt.Errorf("could not publish to event bus (%s): %w", bus.HandlerType(), err)
+			}
+		}(i, bus)
+	}
+
+	wg.Wait()
+
+	var failed []error
+
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	return failed
+}
+
+// publication is a queued publishing to a secondary event bus.
+type publication struct {
+	ctx   context.Context
+	event eh.Event
+	f     func(context.Context, eh.EventBus) error
+}
+
+// Publishes to the event bus in order from the queue, until it is closed.
+func (b *EventBus) publishQueued(bus eh.EventBus, queue <-chan *publication) {
+	defer b.wg.Done()
+
+	for p := range queue {
+		if err := p.f(p.ctx, bus); err != nil {
+			err = fmt.Errorf("could not publish to event bus (%s): %w", bus.HandlerType(), err)
+			b.sendError(&eh.EventBusError{Err: err, Ctx: p.ctx, Event: p.event})
+		}
+	}
+}
+
+func (b *EventBus) forwardErrors(errCh <-chan error) {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case err, ok := <-errCh:
+			if !ok {
+				return
+			}
+
+			b.sendError(err)
+		case <-b.cctx.Done():
+			return
+		}
+	}
+}
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in fanout event bus: %s", err)
+		}
+	}
+}
+
+func (b *EventBus) buses() []eh.EventBus {
+	return append([]eh.EventBus{b.primary}, b.secondaries...)
+}
@@ -0,0 +1,438 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/eventbus/local"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+func TestAddHandler(t *testing.T) {
+	bus, err := NewEventBus(local.NewEventBus(), []eh.EventBus{local.NewEventBus()})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestAddHandler(t, bus)
+}
+
+func TestRemoveHandler(t *testing.T) {
+	bus, err := NewEventBus(local.NewEventBus(), []eh.EventBus{local.NewEventBus()})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestRemoveHandler(t, bus, time.Second)
+}
+
+func TestEventBus(t *testing.T) {
+	group := local.NewGroup()
+
+	bus1, err := NewEventBus(local.NewEventBus(local.WithGroup(group)), []eh.EventBus{local.NewEventBus()})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	bus2, err := NewEventBus(local.NewEventBus(local.WithGroup(group)), []eh.EventBus{local.NewEventBus()})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
+}
+
+func TestHandleEvents(t *testing.T) {
+	bus, err := NewEventBus(local.NewEventBus(), []eh.EventBus{local.NewEventBus()})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestHandleEvents(t, bus, time.Second)
+}
+
+func TestConsumer(t *testing.T) {
+	ctx := context.Background()
+	primary := local.NewEventBus()
+	secondary := local.NewEventBus()
+
+	bus, err := NewEventBus(primary, []eh.EventBus{secondary}, WithConsumer(secondary))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	handler := mocks.NewEventHandler("handler")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Events published on the primary bus only should not be handled.
+	if err := primary.HandleEvent(ctx, newTestEvent("primary")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if handler.Wait(100 * time.Millisecond) {
+		t.Error("the event should not be handled")
+	}
+
+	event := newTestEvent("event")
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	handler.Lock()
+	defer handler.Unlock()
+
+	if !eh.CompareEventSlices(handler.Events, []eh.Event{event}) {
+		t.Error("the event should be correct:", handler.Events)
+	}
+}
+
+func TestPolicyAll(t *testing.T) {
+	ctx := context.Background()
+	busErr := errors.New("bus error")
+	primary := &mocks.EventBus{}
+	secondary := &mocks.EventBus{Err: busErr}
+
+	bus, err := NewEventBus(primary, []eh.EventBus{secondary})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent("event")
+	if err := bus.HandleEvent(ctx, event); !errors.Is(err, busErr) {
+		t.Error("the error should be correct:", err)
+	}
+
+	if !eh.CompareEventSlices(primary.Events, []eh.Event{event}) {
+		t.Error("the event should be published to the primary bus:", primary.Events)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(ctx, event); !errors.Is(err, ErrBusClosed) {
+		t.Error("the error should be correct:", err)
+	}
+}
+
+func TestPolicyBestEffort(t *testing.T) {
+	ctx := context.Background()
+	busErr := errors.New("bus error")
+	primary := &mocks.EventBus{Err: busErr}
+	secondary := &mocks.EventBus{}
+
+	bus, err := NewEventBus(primary, []eh.EventBus{secondary}, WithPolicy(PolicyBestEffort))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	event := newTestEvent("event")
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !eh.CompareEventSlices(secondary.Events, []eh.Event{event}) {
+		t.Error("the event should be published to the secondary bus:", secondary.Events)
+	}
+
+	select {
+	case err := <-bus.Errors():
+		var evtErr *eh.EventBusError
+		if !errors.As(err, &evtErr) || !errors.Is(err, busErr) {
+			t.Error("the error should be correct:", err)
+		}
+
+		if evtErr != nil && evtErr.Event != event {
+			t.Error("the error should contain the event:", evtErr.Event)
+		}
+	case <-time.After(time.Second):
+		t.Error("there should be an error")
+	}
+}
+
+func TestPolicyPrimary(t *testing.T) {
+	ctx := mocks.WithContextOne(context.Background(), "testval")
+	busErr := errors.New("bus error")
+	primary := &mocks.EventBus{}
+	secondary1 := &mocks.EventBus{}
+	secondary2 := &mocks.EventBus{Err: busErr}
+
+	bus, err := NewEventBus(primary, []eh.EventBus{secondary1, secondary2}, WithPolicy(PolicyPrimary))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent("event")
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	select {
+	case err := <-bus.Errors():
+		if !errors.Is(err, busErr) {
+			t.Error("the error should be correct:", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("there should be an error")
+	}
+
+	// Wait for the asynchronous publishing.
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !eh.CompareEventSlices(secondary1.Events, []eh.Event{event}) {
+		t.Error("the event should be published to the secondary bus:", secondary1.Events)
+	}
+
+	if val, ok := mocks.ContextOne(secondary1.Context); !ok || val != "testval" {
+		t.Error("the context should be correct:", secondary1.Context)
+	}
+
+	// Errors from the primary bus should be returned.
+	primary = &mocks.EventBus{Err: busErr}
+
+	bus, err = NewEventBus(primary, []eh.EventBus{secondary1}, WithPolicy(PolicyPrimary))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	if err := bus.HandleEvent(ctx, event); !errors.Is(err, busErr) {
+		t.Error("the error should be correct:", err)
+	}
+}
+
+func TestPolicyPrimaryOrder(t *testing.T) {
+	ctx := context.Background()
+	secondary := &mocks.EventBus{}
+
+	bus, err := NewEventBus(&mocks.EventBus{}, []eh.EventBus{secondary}, WithPolicy(PolicyPrimary))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	var events []eh.Event
+
+	for i := 0; i < 100; i++ {
+		event := newTestEvent(fmt.Sprintf("event%d", i))
+		events = append(events, event)
+
+		if err := bus.HandleEvent(ctx, event); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	// Wait for the asynchronous publishing.
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !eh.CompareEventSlices(secondary.Events, events) {
+		t.Error("the events should be published in order to the secondary bus")
+	}
+}
+
+func TestPolicyPrimaryQueueFull(t *testing.T) {
+	defaultQueueSize := DefaultQueueSize
+	DefaultQueueSize = 1
+
+	defer func() {
+		DefaultQueueSize = defaultQueueSize
+	}()
+
+	ctx := context.Background()
+	secondary := &blockingEventBus{
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+
+	bus, err := NewEventBus(&mocks.EventBus{}, []eh.EventBus{secondary}, WithPolicy(PolicyPrimary))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Block the secondary bus on the first event and fill the queue.
+	if err := bus.HandleEvent(ctx, newTestEvent("event1")); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	<-secondary.started
+
+	if err := bus.HandleEvent(ctx, newTestEvent("event2")); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(ctx, newTestEvent("event3")); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	select {
+	case err := <-bus.Errors():
+		if !errors.Is(err, ErrQueueFull) {
+			t.Error("the error should be correct:", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("there should be an error")
+	}
+
+	close(secondary.unblock)
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if len(secondary.Events) != 2 {
+		t.Error("the queued events should be published:", secondary.Events)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	ctx := context.Background()
+	errs := make(chan error, 10)
+	primary := local.NewEventBus()
+
+	bus, err := NewEventBus(primary, []eh.EventBus{&mocks.EventBus{Err: errors.New("bus error")}},
+		WithPolicy(PolicyBestEffort),
+		WithErrorHandler(eh.ErrorHandlerFunc(func(err error) {
+			errs <- err
+		})),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	// Errors from publishing should be handled.
+	if err := bus.HandleEvent(ctx, newTestEvent("event")); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "bus error") {
+			t.Error("the error should be correct:", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("there should be an error")
+	}
+
+	// Errors from the event buses should be handled.
+	handler := mocks.NewEventHandler("handler")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := primary.HandleEvent(ctx, newTestEvent("event")); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "handler error") {
+			t.Error("the error should be correct:", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("there should be an error")
+	}
+}
+
+func TestNewEventBus(t *testing.T) {
+	bus := &mocks.EventBus{}
+
+	if _, err := NewEventBus(nil, nil); err == nil || err.Error() != "missing primary event bus" {
+		t.Error("the error should be correct:", err)
+	}
+
+	if _, err := NewEventBus(bus, []eh.EventBus{nil}); err == nil || err.Error() != "missing secondary event bus" {
+		t.Error("the error should be correct:", err)
+	}
+
+	testCases := map[string]struct {
+		option      Option
+		expectError string
+	}{
+		"invalid policy": {
+			WithPolicy(Policy(42)),
+			"invalid policy: unknown policy 42",
+		},
+		"unknown consumer": {
+			WithConsumer(&mocks.EventBus{}),
+			"consumer must be one of the event buses",
+		},
+		"missing error handler": {
+			WithErrorHandler(nil),
+			"missing error handler",
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus(bus, nil, tc.option)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+// blockingEventBus blocks publishing until unblocked, after the first event has
+// started publishing.
+type blockingEventBus struct {
+	mocks.EventBus
+	started chan struct{}
+	unblock chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingEventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	b.once.Do(func() { close(b.started) })
+	<-b.unblock
+
+	return b.EventBus.HandleEvent(ctx, event)
+}
+
+func newTestEvent(content string) eh.Event {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	return eh.NewEvent(mocks.EventType, &mocks.EventData{Content: content}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+}
@@ -16,6 +16,7 @@ package gcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -27,23 +28,30 @@ import (
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 // EventBus is a local event bus that delegates handling of published events
 // to all matching registered handlers, in order of registration.
 type EventBus struct {
-	appID        string
-	client       *pubsub.Client
-	clientOpts   []option.ClientOption
-	topic        *pubsub.Topic
-	topicConfig  *pubsub.TopicConfig
-	registered   map[eh.EventHandlerType]struct{}
-	registeredMu sync.RWMutex
-	errCh        chan error
-	cctx         context.Context
-	cancel       context.CancelFunc
-	wg           sync.WaitGroup
-	codec        eh.EventCodec
+	appID           string
+	client          *pubsub.Client
+	clientOpts      []option.ClientOption
+	topic           *pubsub.Topic
+	topicConfig     *pubsub.TopicConfig
+	orderingKey     OrderingKeyFunc
+	deadLetter      *DeadLetterPolicy
+	deadLetterTopic *pubsub.Topic
+	deleteOnRemove  bool
+	registered      map[eh.EventHandlerType]*registration
+	registeredMu    sync.RWMutex
+	errCh           chan error
+	errorHandler    eh.ErrorHandler
+	cctx            context.Context
+	cancel          context.CancelFunc
+	wg              sync.WaitGroup
+	codec           eh.EventCodec
 }
 
 // NewEventBus creates an EventBus, with optional GCP connection settings.
@@ -51,12 +59,13 @@ func NewEventBus(projectID, appID string, options ...Option) (*EventBus, error)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	b := &EventBus{
-		appID:      appID,
-		registered: map[eh.EventHandlerType]struct{}{},
-		errCh:      make(chan error, 100),
-		cctx:       ctx,
-		cancel:     cancel,
-		codec:      &json.EventCodec{},
+		appID:       appID,
+		registered:  map[eh.EventHandlerType]*registration{},
+		errCh:       make(chan error, 100),
+		cctx:        ctx,
+		cancel:      cancel,
+		codec:       &json.EventCodec{},
+		orderingKey: AggregateOrderingKey,
 	}
 
 	// Apply configuration options.
@@ -96,7 +105,20 @@ func NewEventBus(projectID, appID string, options ...Option) (*EventBus, error)
 		}
 	}
 
-	b.topic.EnableMessageOrdering = true
+	b.topic.EnableMessageOrdering = b.orderingKey != nil
+
+	// Get or create the dead letter topic.
+	if b.deadLetter != nil {
+		b.deadLetterTopic = b.client.Topic(b.deadLetter.Topic)
+
+		if ok, err := b.deadLetterTopic.Exists(b.cctx); err != nil {
+			return nil, err
+		} else if !ok {
+			if b.deadLetterTopic, err = b.client.CreateTopic(b.cctx, b.deadLetter.Topic); err != nil {
+				return nil, fmt.Errorf("could not create dead letter topic: %w", err)
+			}
+		}
+	}
 
 	return b, nil
 }
@@ -132,6 +154,110 @@ func WithTopicOptions(topicConfig *pubsub.TopicConfig) Option {
 	}
 }
 
+// OrderingKeyFunc returns the ordering key to publish an event with, messages
+// with the same ordering key are received in the order they were published.
+type OrderingKeyFunc func(eh.Event) string
+
+// AggregateOrderingKey orders events per aggregate, which is the default.
+func AggregateOrderingKey(event eh.Event) string {
+	return event.AggregateID().String()
+}
+
+// WithOrderingKey publishes events with the ordering key returned by the func,
+// instead of the aggregate ID.
+func WithOrderingKey(f OrderingKeyFunc) Option {
+	return func(b *EventBus) error {
+		if f == nil {
+			return errors.New("missing ordering key func")
+		}
+
+		b.orderingKey = f
+
+		return nil
+	}
+}
+
+// WithoutMessageOrdering publishes events without an ordering key and creates
+// subscriptions without message ordering, which gives a higher throughput for
+// handlers that don't depend on the order of events.
+func WithoutMessageOrdering() Option {
+	return func(b *EventBus) error {
+		b.orderingKey = nil
+
+		return nil
+	}
+}
+
+// DeadLetterPolicy is the policy for forwarding messages that a handler failed
+// to handle to a dead letter topic.
+type DeadLetterPolicy struct {
+	// Topic is the ID of the dead letter topic, which is created if it does
+	// not exist.
+	Topic string
+	// MaxDeliveryAttempts is the number of deliveries before a message is
+	// forwarded to the dead letter topic, between 5 and 100.
+	MaxDeliveryAttempts int
+}
+
+func (p DeadLetterPolicy) validate() error {
+	if p.Topic == "" {
+		return errors.New("missing dead letter topic")
+	}
+
+	if p.MaxDeliveryAttempts < 5 || p.MaxDeliveryAttempts > 100 {
+		return errors.New("max delivery attempts must be between 5 and 100")
+	}
+
+	return nil
+}
+
+// WithDeadLetterPolicy configures the subscriptions of all handlers to forward
+// messages to the dead letter topic after the max delivery attempts. The
+// Pub/Sub service account of the project must be allowed to publish to the
+// dead letter topic and to acknowledge messages on the subscriptions.
+func WithDeadLetterPolicy(p DeadLetterPolicy) Option {
+	return func(b *EventBus) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+
+		b.deadLetter = &p
+
+		return nil
+	}
+}
+
+// WithDeleteSubscriptionOnRemove deletes the subscription of a handler when it
+// is removed with RemoveHandler, which also removes it for other instances of
+// the app together with any unacknowledged messages. Subscriptions for
+// ephemeral handlers are always deleted.
+func WithDeleteSubscriptionOnRemove() Option {
+	return func(b *EventBus) error {
+		b.deleteOnRemove = true
+
+		return nil
+	}
+}
+
+// EphemeralExpiration is how long the subscription of an ephemeral handler is
+// kept without any subscribers, for example after a crash, before it is
+// deleted. The minimum allowed by Pub/Sub is one day.
+var EphemeralExpiration = 24 * time.Hour
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
 // HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandlerType() eh.EventHandlerType {
 	return "eventbus"
@@ -144,25 +270,58 @@ const (
 
 // HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
-	data, err := b.codec.MarshalEvent(ctx, event)
-	if err != nil {
-		return fmt.Errorf("could not marshal event: %w", err)
+	return b.HandleEvents(ctx, []eh.Event{event})
+}
+
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. The events are published in
+// batches by the client, and all results are awaited.
+func (b *EventBus) HandleEvents(ctx context.Context, events []eh.Event) error {
+	msgs := make([]*pubsub.Message, 0, len(events))
+
+	for _, event := range events {
+		data, err := b.codec.MarshalEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("could not marshal event: %w", err)
+		}
+
+		var orderingKey string
+		if b.orderingKey != nil {
+			orderingKey = b.orderingKey(event)
+		}
+
+		msgs = append(msgs, &pubsub.Message{
+			Data: data,
+			Attributes: map[string]string{
+				aggregateTypeAttribute:     event.AggregateType().String(),
+				event.EventType().String(): "", // The event type as a key to save space when filtering.
+			},
+			OrderingKey: orderingKey,
+		})
+	}
+
+	results := make([]*pubsub.PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = b.topic.Publish(ctx, msg)
 	}
 
-	res := b.topic.Publish(ctx, &pubsub.Message{
-		Data: data,
-		Attributes: map[string]string{
-			aggregateTypeAttribute:     event.AggregateType().String(),
-			event.EventType().String(): "", // The event type as a key to save space when filtering.
-		},
-		OrderingKey: event.AggregateID().String(),
-	})
+	var publishErr error
+
+	for i, res := range results {
+		if _, err := res.Get(ctx); err != nil {
+			// Publishing is paused for an ordering key after an error, to not
+			// publish later messages out of order. Resume it to be able to retry.
+			if msgs[i].OrderingKey != "" {
+				b.topic.ResumePublish(msgs[i].OrderingKey)
+			}
 
-	if _, err := res.Get(ctx); err != nil {
-		return fmt.Errorf("could not publish event: %w", err)
+			if publishErr == nil {
+				publishErr = fmt.Errorf("could not publish event: %w", err)
+			}
+		}
 	}
 
-	return nil
+	return publishErr
 }
 
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
@@ -189,24 +348,35 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 		return fmt.Errorf("match filter is longer than 256 chars: %d", len(filter))
 	}
 
-	// Get or create the subscription.
-	subscriptionID := b.appID + "_" + h.HandlerType().String()
+	// Get or create the subscription. Ephemeral handlers get a subscription per
+	// instance, so that every instance receives all events.
+	isEphemeral := ephemeral.IsEphemeral(h)
+
+	subscriptionID := b.subscriptionID(h.HandlerType())
+	if isEphemeral {
+		subscriptionID += "_" + uuid.New().String()
+	}
+
 	sub := b.client.Subscription(subscriptionID)
 
 	if ok, err := sub.Exists(ctx); err != nil {
 		return fmt.Errorf("could not check existing subscription: %w", err)
 	} else if !ok {
-		if sub, err = b.client.CreateSubscription(ctx, subscriptionID,
-			pubsub.SubscriptionConfig{
-				Topic:                 b.topic,
-				AckDeadline:           60 * time.Second,
-				Filter:                filter,
-				EnableMessageOrdering: true,
-				RetryPolicy: &pubsub.RetryPolicy{
-					MinimumBackoff: 3 * time.Second,
-				},
+		cfg := pubsub.SubscriptionConfig{
+			Topic:                 b.topic,
+			AckDeadline:           60 * time.Second,
+			Filter:                filter,
+			EnableMessageOrdering: b.orderingKey != nil,
+			RetryPolicy: &pubsub.RetryPolicy{
+				MinimumBackoff: 3 * time.Second,
 			},
-		); err != nil {
+			DeadLetterPolicy: b.deadLetterPolicy(),
+		}
+		if isEphemeral {
+			cfg.ExpirationPolicy = EphemeralExpiration
+		}
+
+		if sub, err = b.client.CreateSubscription(ctx, subscriptionID, cfg); err != nil {
 			return fmt.Errorf("could not create subscription: %w", err)
 		}
 	} else if ok {
@@ -217,27 +387,143 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 		if cfg.Filter != filter {
 			return fmt.Errorf("the existing filter for '%s' differs, please remove to recreate", h.HandlerType())
 		}
-		if !cfg.EnableMessageOrdering {
-			return fmt.Errorf("message ordering not enabled for subscription '%s', please remove to recreate", h.HandlerType())
+		if cfg.EnableMessageOrdering != (b.orderingKey != nil) {
+			return fmt.Errorf("message ordering differs for subscription '%s', please remove to recreate", h.HandlerType())
+		}
+
+		// Update the dead letter policy, which can be changed in place.
+		if p := b.deadLetterPolicy(); !equalDeadLetterPolicies(cfg.DeadLetterPolicy, p) {
+			if p == nil {
+				// An empty policy removes it.
+				p = &pubsub.DeadLetterPolicy{}
+			}
+
+			if _, err := sub.Update(ctx, pubsub.SubscriptionConfigToUpdate{
+				DeadLetterPolicy: p,
+			}); err != nil {
+				return fmt.Errorf("could not update dead letter policy: %w", err)
+			}
 		}
 	}
 
 	// Register handler.
-	b.registered[h.HandlerType()] = struct{}{}
+	hctx, cancel := context.WithCancel(b.cctx)
+	r := &registration{
+		sub:       sub,
+		ephemeral: isEphemeral,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	b.registered[h.HandlerType()] = r
 
 	b.wg.Add(1)
 
 	// Handle until context is cancelled.
-	go b.handle(m, h, sub)
+	go b.handle(hctx, m, h, sub, r.done)
+
+	return nil
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	r, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop handling and wait for any ongoing handling to finish.
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !b.deleteOnRemove && !r.ephemeral {
+		return nil
+	}
+
+	if err := r.sub.Delete(ctx); err != nil {
+		return fmt.Errorf("could not delete subscription: %w", err)
+	}
 
 	return nil
 }
 
+// Returns the dead letter policy for subscriptions, if any.
+func (b *EventBus) deadLetterPolicy() *pubsub.DeadLetterPolicy {
+	if b.deadLetter == nil {
+		return nil
+	}
+
+	return &pubsub.DeadLetterPolicy{
+		DeadLetterTopic:     b.deadLetterTopic.String(),
+		MaxDeliveryAttempts: b.deadLetter.MaxDeliveryAttempts,
+	}
+}
+
+func equalDeadLetterPolicies(a, b *pubsub.DeadLetterPolicy) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.DeadLetterTopic == b.DeadLetterTopic &&
+		a.MaxDeliveryAttempts == b.MaxDeliveryAttempts
+}
+
+// Returns the subscription ID for a handler type.
+func (b *EventBus) subscriptionID(handlerType eh.EventHandlerType) string {
+	return b.appID + "_" + handlerType.String()
+}
+
 // Errors implements the Errors method of the eventhorizon.EventBus interface.
 func (b *EventBus) Errors() <-chan error {
 	return b.errCh
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (b *EventBus) HealthCheckName() string {
+	return "gcp eventbus"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by checking that the topic exists, and that the consumers of all
+// added handlers are running.
+func (b *EventBus) Check(ctx context.Context) error {
+	ok, err := b.topic.Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("could not check topic: %w", err)
+	} else if !ok {
+		return fmt.Errorf("topic %s does not exist", b.topic.ID())
+	}
+
+	return b.checkConsumers()
+}
+
+// Returns an error if the consumer of an added handler has stopped.
+func (b *EventBus) checkConsumers() error {
+	b.registeredMu.RLock()
+	defer b.registeredMu.RUnlock()
+
+	for handlerType, r := range b.registered {
+		select {
+		case <-r.done:
+			return fmt.Errorf("consumer for handler %s has stopped", handlerType)
+		default:
+		}
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.EventBus interface.
 func (b *EventBus) Close() error {
 	// Stop publishing.
@@ -247,21 +533,37 @@ func (b *EventBus) Close() error {
 	b.cancel()
 	b.wg.Wait()
 
+	// Remove the subscriptions of any ephemeral handlers.
+	b.registeredMu.RLock()
+	for _, r := range b.registered {
+		if r.ephemeral {
+			if err := r.sub.Delete(context.Background()); err != nil {
+				log.Printf("eventhorizon: could not delete ephemeral subscription in GCP event bus: %s", err)
+			}
+		}
+	}
+	b.registeredMu.RUnlock()
+
 	return b.client.Close()
 }
 
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	sub       *pubsub.Subscription
+	ephemeral bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
 // Handles all events coming in on the channel.
-func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, sub *pubsub.Subscription) {
+func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, sub *pubsub.Subscription, done chan struct{}) {
 	defer b.wg.Done()
+	defer close(done)
 
 	for {
-		if err := sub.Receive(b.cctx, b.handler(m, h)); err != nil {
+		if err := sub.Receive(ctx, b.handler(m, h)); err != nil {
 			err = fmt.Errorf("could not receive: %w", err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err}:
-			default:
-				log.Printf("eventhorizon: missed error in GCP event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
 
 			// Retry the receive loop if there was an error.
 			time.Sleep(time.Second)
@@ -278,11 +580,7 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler) func(ctx contex
 		event, ctx, err := b.codec.UnmarshalEvent(ctx, msg.Data)
 		if err != nil {
 			err = fmt.Errorf("could not unmarshal event: %w", err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx}:
-			default:
-				log.Printf("eventhorizon: missed error in GCP event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, HandlerType: h.HandlerType(), Retryable: true})
 
 			msg.Nack()
 
@@ -299,11 +597,13 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler) func(ctx contex
 		// Handle the event if it did match.
 		if err := h.HandleEvent(ctx, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
-			default:
-				log.Printf("eventhorizon: missed error in GCP event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{
+				Err:         err,
+				Ctx:         ctx,
+				Event:       event,
+				HandlerType: h.HandlerType(),
+				Retryable:   true,
+			})
 
 			msg.Nack()
 
@@ -350,3 +650,18 @@ func createFilter(m eh.EventMatcher) string {
 		return ""
 	}
 }
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in GCP event bus: %s", err)
+		}
+	}
+}
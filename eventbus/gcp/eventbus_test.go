@@ -15,16 +15,21 @@
 package gcp
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 func TestAddHandlerIntegration(t *testing.T) {
@@ -40,6 +45,19 @@ func TestAddHandlerIntegration(t *testing.T) {
 	eventbus.TestAddHandler(t, bus1)
 }
 
+func TestRemoveHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestRemoveHandler(t, bus, time.Second)
+}
+
 func TestEventBusIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -80,6 +98,181 @@ func TestEventBusWithConfigIntegration(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
 }
 
+func TestMessageOrderingIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("handler")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	const numEvents = 20
+
+	for i := 1; i <= numEvents; i++ {
+		event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: fmt.Sprintf("event%d", i)}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, id, i))
+		if err := bus.HandleEvent(ctx, event); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+
+	for i := 0; i < numEvents; i++ {
+		if !handler.Wait(5 * time.Second) {
+			t.Fatal("did not receive event in time")
+		}
+	}
+
+	for i, event := range handler.Events {
+		if event.Version() != i+1 {
+			t.Errorf("event %d should be received in order, got version: %d", i, event.Version())
+		}
+	}
+}
+
+func TestDeadLetterIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Get a random app ID.
+	bts := make([]byte, 8)
+	if _, err := rand.Read(bts); err != nil {
+		t.Fatal(err)
+	}
+
+	appID := "app-" + hex.EncodeToString(bts)
+	deadLetterTopic := appID + "_dead_letters"
+
+	bus, _, err := newTestEventBus(appID, WithDeadLetterPolicy(DeadLetterPolicy{
+		Topic:               deadLetterTopic,
+		MaxDeliveryAttempts: 5,
+	}))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	gcpBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	// Subscribe to the dead letter topic before any messages are forwarded.
+	deadLetterSub, err := gcpBus.client.CreateSubscription(ctx, deadLetterTopic, pubsub.SubscriptionConfig{
+		Topic: gcpBus.client.Topic(deadLetterTopic),
+	})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	handler := mocks.NewEventHandler("failing")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	cfg, err := gcpBus.client.Subscription(gcpBus.subscriptionID(handler.HandlerType())).Config(ctx)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if cfg.DeadLetterPolicy == nil ||
+		cfg.DeadLetterPolicy.DeadLetterTopic != gcpBus.deadLetterTopic.String() ||
+		cfg.DeadLetterPolicy.MaxDeliveryAttempts != 5 {
+		t.Error("the dead letter policy should be correct:", cfg.DeadLetterPolicy)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Wait for the retries, with backoff, and the forwarding.
+	rctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	var msg *pubsub.Message
+
+	if err := deadLetterSub.Receive(rctx, func(ctx context.Context, m *pubsub.Message) {
+		m.Ack()
+
+		msg = m
+
+		cancel()
+	}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if msg == nil {
+		t.Fatal("there should be a dead letter")
+	}
+
+	if msg.Attributes[aggregateTypeAttribute] != mocks.AggregateType.String() {
+		t.Error("the attributes should be kept:", msg.Attributes)
+	}
+
+	deadLetterEvent, _, err := gcpBus.codec.UnmarshalEvent(ctx, msg.Data)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := eh.CompareEvents(deadLetterEvent, event); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	testCases := map[string]struct {
+		option      Option
+		expectError string
+	}{
+		"missing ordering key func": {
+			WithOrderingKey(nil),
+			"missing ordering key func",
+		},
+		"missing dead letter topic": {
+			WithDeadLetterPolicy(DeadLetterPolicy{MaxDeliveryAttempts: 5}),
+			"missing dead letter topic",
+		},
+		"too few delivery attempts": {
+			WithDeadLetterPolicy(DeadLetterPolicy{Topic: "dead_letters", MaxDeliveryAttempts: 4}),
+			"max delivery attempts must be between 5 and 100",
+		},
+		"too many delivery attempts": {
+			WithDeadLetterPolicy(DeadLetterPolicy{Topic: "dead_letters", MaxDeliveryAttempts: 101}),
+			"max delivery attempts must be between 5 and 100",
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus("project_id", "app", tc.option)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -95,6 +288,19 @@ func TestEventBusLoadtest(t *testing.T) {
 	eventbus.LoadTest(t, bus)
 }
 
+func TestHandleEventsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestHandleEvents(t, bus, time.Second)
+}
+
 func BenchmarkEventBus(b *testing.B) {
 	bus, appID, err := newTestEventBus("")
 	if err != nil {
@@ -106,7 +312,18 @@ func BenchmarkEventBus(b *testing.B) {
 	eventbus.Benchmark(b, bus)
 }
 
-func newTestEventBus(appID string) (eh.EventBus, string, error) {
+func BenchmarkEventBusBatch(b *testing.B) {
+	bus, appID, err := newTestEventBus("")
+	if err != nil {
+		b.Fatal("there should be no error:", err)
+	}
+
+	b.Logf("using topic: %s_events", appID)
+
+	eventbus.BenchmarkBatch(b, bus, 100)
+}
+
+func newTestEventBus(appID string, options ...Option) (eh.EventBus, string, error) {
 	// Connect to localhost if not running inside docker
 	if os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
 		os.Setenv("PUBSUB_EMULATOR_HOST", "localhost:8793")
@@ -122,7 +339,7 @@ func newTestEventBus(appID string) (eh.EventBus, string, error) {
 		appID = "app-" + hex.EncodeToString(bts)
 	}
 
-	bus, err := NewEventBus("project_id", appID)
+	bus, err := NewEventBus("project_id", appID, options...)
 	if err != nil {
 		return nil, "", fmt.Errorf("could not create event bus: %w", err)
 	}
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,27 +28,40 @@ import (
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
+// DefaultPublishBatchSize is the max number of events written in one request
+// per partition when publishing events in a batch.
+var DefaultPublishBatchSize = 100
+
 // EventBus is a local event bus that delegates handling of published events
 // to all matching registered handlers, in order of registration.
 type EventBus struct {
 	// TODO: Support multiple brokers.
-	addresses       []string
-	appID           string
-	autoCreateTopic bool
-	topic           string
-	topicPartitions int
-	startOffset     int64
-	client          *kafka.Client
-	writer          *kafka.Writer
-	registered      map[eh.EventHandlerType]struct{}
-	registeredMu    sync.RWMutex
-	errCh           chan error
-	cctx            context.Context
-	cancel          context.CancelFunc
-	wg              sync.WaitGroup
-	codec           eh.EventCodec
+	addresses        []string
+	appID            string
+	autoCreateTopic  bool
+	topic            string
+	topicPartitions  int
+	startOffset      int64
+	deleteOnRemove   bool
+	retryPolicy      RetryPolicy
+	deadLetterTopic  string
+	concurrency      int
+	client           *kafka.Client
+	writer           *kafka.Writer
+	batchWriter      *kafka.Writer
+	deadLetterWriter *kafka.Writer
+	registered       map[eh.EventHandlerType]*registration
+	registeredMu     sync.RWMutex
+	errCh            chan error
+	errorHandler     eh.ErrorHandler
+	cctx             context.Context
+	cancel           context.CancelFunc
+	wg               sync.WaitGroup
+	codec            eh.EventCodec
 }
 
 // NewEventBus creates an EventBus, with optional settings.
@@ -63,7 +77,9 @@ func NewEventBus(addressList, appID string, options ...Option) (*EventBus, error
 		topic:           appID + "_events",
 		topicPartitions: 5,
 		startOffset:     kafka.LastOffset, // Default: Don't read old messages.
-		registered:      map[eh.EventHandlerType]struct{}{},
+		retryPolicy:     DefaultRetryPolicy,
+		concurrency:     1,
+		registered:      map[eh.EventHandlerType]*registration{},
 		errCh:           make(chan error, 100),
 		cctx:            ctx,
 		cancel:          cancel,
@@ -87,13 +103,32 @@ func NewEventBus(addressList, appID string, options ...Option) (*EventBus, error
 
 	// Get or create the topic.
 	if b.autoCreateTopic {
-		if err := b.createTopic(); err != nil {
+		if err := b.createTopic(b.topic); err != nil {
 			return nil, fmt.Errorf("error creating topic: %w", err)
 		}
-	} else if err := b.verifyTopic(); err != nil {
+	} else if err := b.verifyTopic(b.topic); err != nil {
 		return nil, fmt.Errorf("error verifying topic: %w", err)
 	}
 
+	// Get or create the dead letter topic.
+	if b.deadLetterTopic != "" {
+		if b.autoCreateTopic {
+			if err := b.createTopic(b.deadLetterTopic); err != nil {
+				return nil, fmt.Errorf("error creating dead letter topic: %w", err)
+			}
+		} else if err := b.verifyTopic(b.deadLetterTopic); err != nil {
+			return nil, fmt.Errorf("error verifying dead letter topic: %w", err)
+		}
+
+		b.deadLetterWriter = &kafka.Writer{
+			Addr:         kafka.TCP(addrSplit...),
+			Topic:        b.deadLetterTopic,
+			BatchSize:    1,
+			RequiredAcks: kafka.RequireOne,
+			Balancer:     &kafka.Hash{},
+		}
+	}
+
 	b.writer = &kafka.Writer{
 		Addr:         kafka.TCP(addrSplit...),
 		Topic:        b.topic,
@@ -102,18 +137,29 @@ func NewEventBus(addressList, appID string, options ...Option) (*EventBus, error
 		Balancer:     &kafka.Hash{},    // Hash by aggregate ID.
 	}
 
+	// Batches are written with one request per partition, the short timeout
+	// only delays batches smaller than the batch size.
+	b.batchWriter = &kafka.Writer{
+		Addr:         kafka.TCP(addrSplit...),
+		Topic:        b.topic,
+		BatchSize:    DefaultPublishBatchSize,
+		BatchTimeout: time.Millisecond,
+		RequiredAcks: kafka.RequireOne,
+		Balancer:     &kafka.Hash{},
+	}
+
 	return b, nil
 }
 
 // Creates the Kafka topic, with retries.
-func (b *EventBus) createTopic() error {
+func (b *EventBus) createTopic(topic string) error {
 	var resp *kafka.CreateTopicsResponse
 	var err error
 
 	for i := 0; i < 10; i++ {
 		resp, err = b.client.CreateTopics(context.Background(), &kafka.CreateTopicsRequest{
 			Topics: []kafka.TopicConfig{{
-				Topic:             b.topic,
+				Topic:             topic,
 				NumPartitions:     b.topicPartitions,
 				ReplicationFactor: 1,
 			}},
@@ -133,7 +179,7 @@ func (b *EventBus) createTopic() error {
 		return fmt.Errorf("could not create Kafka topic in time: %w", err)
 	}
 
-	if topicErr, ok := resp.Errors[b.topic]; ok && topicErr != nil {
+	if topicErr, ok := resp.Errors[topic]; ok && topicErr != nil {
 		if !errors.Is(topicErr, kafka.TopicAlreadyExists) {
 			return fmt.Errorf("invalid Kafka topic: %w", topicErr)
 		}
@@ -143,13 +189,13 @@ func (b *EventBus) createTopic() error {
 }
 
 // Verifies that the Kafka topic exists, with retries.
-func (b *EventBus) verifyTopic() error {
+func (b *EventBus) verifyTopic(topic string) error {
 	var resp *kafka.MetadataResponse
 	var err error
 
 	for i := 0; i < 10; i++ {
 		resp, err = b.client.Metadata(context.Background(), &kafka.MetadataRequest{
-			Topics: []string{b.topic},
+			Topics: []string{topic},
 		})
 
 		if errors.Is(err, kafka.BrokerNotAvailable) {
@@ -238,6 +284,128 @@ func WithTopicPartitions(numPartitions int) Option {
 	}
 }
 
+// WithDeleteGroupOnRemove deletes the consumer group of a handler when it is
+// removed with RemoveHandler. The group can only be deleted if there are no
+// other members in it, for example from other instances of the app. Groups for
+// ephemeral handlers are always deleted.
+func WithDeleteGroupOnRemove() Option {
+	return func(b *EventBus) error {
+		b.deleteOnRemove = true
+
+		return nil
+	}
+}
+
+// RetryPolicy is the policy for retrying handling of an event that failed.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts to handle an event, after which
+	// it is published to the dead letter topic, if set, and skipped. Zero means
+	// retrying forever.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, which is doubled for
+	// each failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the max delay between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the default retry policy, which retries forever each
+// second.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Second,
+}
+
+// Returns the delay before retrying after the failed attempt, starting at 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return d
+}
+
+// WithRetryPolicy sets the policy used to retry handling of failed events.
+//
+// Defaults to: DefaultRetryPolicy
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(b *EventBus) error {
+		if p.MaxAttempts < 0 {
+			return errors.New("max attempts must not be negative")
+		}
+
+		if p.InitialBackoff <= 0 {
+			return errors.New("initial backoff must be greater than 0")
+		}
+
+		if p.MaxBackoff < p.InitialBackoff {
+			return errors.New("max backoff must not be less than initial backoff")
+		}
+
+		b.retryPolicy = p
+
+		return nil
+	}
+}
+
+// WithDeadLetterTopic publishes events that could not be handled within the
+// max attempts of the retry policy to the dead letter topic, before skipping
+// them. The messages keep the original key, value and headers, with additional
+// headers for the error, number of attempts, handler type and original
+// position. Use RepublishDeadLetters to handle them again.
+//
+// The topic is created in the same way as the event topic.
+func WithDeadLetterTopic(topic string) Option {
+	return func(b *EventBus) error {
+		if topic == "" {
+			return errors.New("missing dead letter topic")
+		}
+
+		b.deadLetterTopic = topic
+
+		return nil
+	}
+}
+
+// WithConcurrency handles up to n messages concurrently for each handler, from
+// different partitions or with different keys within a partition. Messages
+// with the same key, which is the aggregate ID, are always handled in order.
+// Offsets are only committed up to the last message in a partition for which
+// all earlier messages has been handled.
+//
+// Defaults to: 1
+func WithConcurrency(n int) Option {
+	return func(b *EventBus) error {
+		if n < 1 {
+			return errors.New("concurrency must be greater than 0")
+		}
+
+		b.concurrency = n
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
 // HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandlerType() eh.EventHandlerType {
 	return "eventbus"
@@ -246,29 +414,59 @@ func (b *EventBus) HandlerType() eh.EventHandlerType {
 const (
 	aggregateTypeHeader = "aggregate_type"
 	eventTypeHeader     = "event_type"
+
+	// Set on republished dead letters to only be handled by the handler type
+	// that failed to handle it.
+	targetHandlerTypeHeader = "target_handler_type"
+
+	deadLetterHeaderPrefix      = "dead_letter_"
+	deadLetterErrorHeader       = deadLetterHeaderPrefix + "error"
+	deadLetterAttemptsHeader    = deadLetterHeaderPrefix + "attempts"
+	deadLetterHandlerTypeHeader = deadLetterHeaderPrefix + "handler_type"
+	deadLetterTopicHeader       = deadLetterHeaderPrefix + "topic"
+	deadLetterPartitionHeader   = deadLetterHeaderPrefix + "partition"
+	deadLetterOffsetHeader      = deadLetterHeaderPrefix + "offset"
 )
 
 // HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
-	data, err := b.codec.MarshalEvent(ctx, event)
-	if err != nil {
-		return fmt.Errorf("could not marshal event: %w", err)
-	}
+	return b.HandleEvents(ctx, []eh.Event{event})
+}
 
-	if err := b.writer.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(event.AggregateID().String()),
-		Value: data,
-		Headers: []kafka.Header{
-			{
-				Key:   aggregateTypeHeader,
-				Value: []byte(event.AggregateType().String()),
-			},
-			{
-				Key:   eventTypeHeader,
-				Value: []byte(event.EventType().String()),
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. The events are written in batches
+// of up to DefaultPublishBatchSize per partition.
+func (b *EventBus) HandleEvents(ctx context.Context, events []eh.Event) error {
+	msgs := make([]kafka.Message, 0, len(events))
+
+	for _, event := range events {
+		data, err := b.codec.MarshalEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("could not marshal event: %w", err)
+		}
+
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(event.AggregateID().String()),
+			Value: data,
+			Headers: []kafka.Header{
+				{
+					Key:   aggregateTypeHeader,
+					Value: []byte(event.AggregateType().String()),
+				},
+				{
+					Key:   eventTypeHeader,
+					Value: []byte(event.EventType().String()),
+				},
 			},
-		},
-	}); err != nil {
+		})
+	}
+
+	w := b.writer
+	if len(msgs) > 1 {
+		w = b.batchWriter
+	}
+
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
 		return fmt.Errorf("could not publish event: %w", err)
 	}
 
@@ -293,8 +491,33 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 		return eh.ErrHandlerAlreadyAdded
 	}
 
-	// Get or create the subscription.
-	groupID := b.appID + "_" + h.HandlerType().String()
+	// Ephemeral handlers get a group per instance, so that every instance
+	// receives all events.
+	groupID := b.groupID(h.HandlerType())
+	if ephemeral.IsEphemeral(h) {
+		groupID += "_" + uuid.New().String()
+	}
+
+	reg, err := b.startHandler(ctx, m, h, groupID)
+	if err != nil {
+		return err
+	}
+
+	b.registered[h.HandlerType()] = reg
+
+	return nil
+}
+
+// Starts handling events for a handler, by joining its consumer group.
+func (b *EventBus) startHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, groupID string) (*registration, error) {
+	// Ephemeral handlers only read new messages, and never commit offsets to
+	// not keep the group after the last member has left.
+	isEphemeral := ephemeral.IsEphemeral(h)
+
+	startOffset := b.startOffset
+	if isEphemeral {
+		startOffset = kafka.LastOffset
+	}
 
 	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:               b.addresses,
@@ -302,7 +525,7 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 		GroupID:               groupID,     // Send messages to only one subscriber per group.
 		MaxWait:               time.Second, // Allow to exit readloop in max 1s.
 		WatchPartitionChanges: true,
-		StartOffset:           b.startOffset,
+		StartOffset:           startOffset,
 	})
 
 	req := &kafka.ListGroupsRequest{
@@ -314,7 +537,9 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 	for i := 0; i < 20; i++ {
 		resp, err := b.client.ListGroups(ctx, req)
 		if err != nil || resp.Error != nil {
-			return fmt.Errorf("could not list Kafka groups: %w", err)
+			r.Close()
+
+			return nil, fmt.Errorf("could not list Kafka groups: %w", err)
 		}
 
 		for _, grp := range resp.Groups {
@@ -333,36 +558,422 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 	}
 
 	if !exist {
-		return fmt.Errorf("did not join group in time")
+		r.Close()
+
+		return nil, fmt.Errorf("did not join group in time")
 	}
 
 	// Register handler.
-	b.registered[h.HandlerType()] = struct{}{}
+	hctx, cancel := context.WithCancel(b.cctx)
+	reg := &registration{
+		matcher:   m,
+		handler:   h,
+		groupID:   groupID,
+		ephemeral: isEphemeral,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
 
 	b.wg.Add(1)
 
 	// Handle until context is cancelled.
-	go b.handle(m, h, r)
+	go b.handle(hctx, m, h, r, isEphemeral, reg.done)
+
+	return reg, nil
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	reg, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop handling and wait for the reader to leave the group.
+	reg.cancel()
+
+	select {
+	case <-reg.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !b.deleteOnRemove && !reg.ephemeral {
+		return nil
+	}
+
+	return b.deleteGroup(ctx, reg.groupID, reg.ephemeral)
+}
+
+// Deletes a consumer group. The groups of ephemeral handlers could already be
+// removed when the last member left, or still have a member that is leaving,
+// which is not an error.
+func (b *EventBus) deleteGroup(ctx context.Context, groupID string, ephemeral bool) error {
+	resp, err := b.client.DeleteGroups(ctx, &kafka.DeleteGroupsRequest{
+		Addr:     b.client.Addr,
+		GroupIDs: []string{groupID},
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete Kafka group: %w", err)
+	}
+
+	if err := resp.Errors[groupID]; err != nil {
+		if ephemeral && (errors.Is(err, kafka.GroupIdNotFound) || errors.Is(err, kafka.NonEmptyGroup)) {
+			return nil
+		}
+
+		return fmt.Errorf("could not delete Kafka group: %w", err)
+	}
+
+	return nil
+}
+
+// ErrConsumerGroupActive is returned when resetting a handler which is still
+// running in other instances using the same consumer group.
+var ErrConsumerGroupActive = errors.New("consumer group has active members")
+
+// ResetHandlerToTime resets the consumer group of a handler to the first
+// messages at or after the time in each partition, to replay events from that
+// time. Partitions without messages after the time are reset to their end.
+// See ResetHandlerToOffsets for how the handler is stopped and restarted.
+func (b *EventBus) ResetHandlerToTime(ctx context.Context, handlerType eh.EventHandlerType, t time.Time) error {
+	return b.resetHandler(ctx, handlerType, func(ctx context.Context, partitions []int) (map[int]int64, error) {
+		// The time and last offsets are listed in separate requests, as Kafka
+		// does not support multiple requests for the same partition.
+		timeOffsets, err := b.listOffsets(ctx, partitions, func(p int) kafka.OffsetRequest {
+			return kafka.TimeOffsetOf(p, t)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		lastOffsets, err := b.listOffsets(ctx, partitions, kafka.LastOffsetOf)
+		if err != nil {
+			return nil, err
+		}
+
+		offsets := make(map[int]int64, len(partitions))
+
+		for _, p := range partitions {
+			// An offset of -1 is returned if there are no messages at or after
+			// the time, reset to the end of the partition instead.
+			offset := int64(-1)
+
+			for o := range timeOffsets[p].Offsets {
+				if o >= 0 {
+					offset = o
+				}
+			}
+
+			if offset < 0 {
+				offset = lastOffsets[p].LastOffset
+			}
+
+			if offset < 0 {
+				return nil, fmt.Errorf("could not get Kafka offset for partition %d", p)
+			}
+
+			offsets[p] = offset
+		}
+
+		return offsets, nil
+	})
+}
+
+// Lists the offsets of the partitions, using one offset request per partition.
+func (b *EventBus) listOffsets(ctx context.Context, partitions []int, req func(partition int) kafka.OffsetRequest) (map[int]kafka.PartitionOffsets, error) {
+	reqs := make([]kafka.OffsetRequest, 0, len(partitions))
+	for _, p := range partitions {
+		reqs = append(reqs, req(p))
+	}
+
+	resp, err := b.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Addr:   b.client.Addr,
+		Topics: map[string][]kafka.OffsetRequest{b.topic: reqs},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list Kafka offsets: %w", err)
+	}
+
+	offsets := make(map[int]kafka.PartitionOffsets, len(partitions))
+
+	for _, p := range resp.Topics[b.topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("could not list Kafka offsets for partition %d: %w", p.Partition, p.Error)
+		}
+
+		offsets[p.Partition] = p
+	}
+
+	return offsets, nil
+}
+
+// ResetHandlerToOffsets resets the consumer group of a handler to the offsets
+// per partition, where the offset is the next message to handle. Partitions not
+// in the offsets keep their committed offsets.
+//
+// The handler is stopped in this instance during the reset, and restarted
+// afterwards, also if the reset failed. The handler must be stopped in all
+// other instances using the same consumer group, otherwise
+// ErrConsumerGroupActive is returned. If the context is done before the
+// handler has stopped, or the handler could not be restarted, it is kept as
+// stopped, which is reported by Check, and must be removed and added again.
+func (b *EventBus) ResetHandlerToOffsets(ctx context.Context, handlerType eh.EventHandlerType, offsets map[int]int64) error {
+	return b.resetHandler(ctx, handlerType, func(ctx context.Context, partitions []int) (map[int]int64, error) {
+		for p, offset := range offsets {
+			found := false
+
+			for _, id := range partitions {
+				if id == p {
+					found = true
+
+					break
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf("invalid partition: %d", p)
+			}
+
+			if offset < 0 {
+				return nil, fmt.Errorf("invalid offset for partition %d: %d", p, offset)
+			}
+		}
+
+		return offsets, nil
+	})
+}
+
+// Stops the handler, commits the offsets for its consumer group and restarts
+// it. The offsets are computed for the partitions of the topic.
+func (b *EventBus) resetHandler(
+	ctx context.Context,
+	handlerType eh.EventHandlerType,
+	computeOffsets func(ctx context.Context, partitions []int) (map[int]int64, error),
+) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	reg, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	// Stop handling and wait for the reader to leave the group. The handler is
+	// kept registered until restarted, to be reported by Check if stopped.
+	reg.cancel()
+
+	select {
+	case <-reg.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	resetErr := b.resetGroup(ctx, reg.groupID, computeOffsets)
+
+	// Restart the handler from the committed offsets, using the context of the
+	// bus to restart also when the context is done.
+	newReg, err := b.startHandler(b.cctx, reg.matcher, reg.handler, reg.groupID)
+	if err != nil {
+		return fmt.Errorf("could not restart handler: %w", err)
+	}
+
+	b.registered[handlerType] = newReg
+
+	if resetErr != nil {
+		return fmt.Errorf("could not reset handler: %w", resetErr)
+	}
 
 	return nil
 }
 
+// Commits the offsets for a consumer group without active members.
+func (b *EventBus) resetGroup(
+	ctx context.Context,
+	groupID string,
+	computeOffsets func(ctx context.Context, partitions []int) (map[int]int64, error),
+) error {
+	// Wait for the group to be empty, other members may take some time to leave.
+	for i := 0; ; i++ {
+		resp, err := b.client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{
+			Addr:     b.client.Addr,
+			GroupIDs: []string{groupID},
+		})
+		if err != nil {
+			return fmt.Errorf("could not describe Kafka group: %w", err)
+		}
+
+		active := false
+
+		for _, grp := range resp.Groups {
+			if grp.Error != nil {
+				return fmt.Errorf("could not describe Kafka group: %w", grp.Error)
+			}
+
+			if len(grp.Members) > 0 {
+				active = true
+			}
+		}
+
+		if !active {
+			break
+		} else if i == 10 {
+			return ErrConsumerGroupActive
+		}
+
+		if !sleep(ctx, 500*time.Millisecond) {
+			return ctx.Err()
+		}
+	}
+
+	resp, err := b.client.Metadata(ctx, &kafka.MetadataRequest{
+		Addr:   b.client.Addr,
+		Topics: []string{b.topic},
+	})
+	if err != nil {
+		return fmt.Errorf("could not get Kafka topic: %w", err)
+	}
+
+	if len(resp.Topics) != 1 || resp.Topics[0].Error != nil {
+		return fmt.Errorf("could not get Kafka topic: %s", b.topic)
+	}
+
+	partitions := make([]int, 0, len(resp.Topics[0].Partitions))
+	for _, p := range resp.Topics[0].Partitions {
+		partitions = append(partitions, p.ID)
+	}
+
+	offsets, err := computeOffsets(ctx, partitions)
+	if err != nil {
+		return err
+	}
+
+	commits := make([]kafka.OffsetCommit, 0, len(offsets))
+	for p, offset := range offsets {
+		commits = append(commits, kafka.OffsetCommit{Partition: p, Offset: offset})
+	}
+
+	// Commit as an admin client outside of any group generation.
+	commitResp, err := b.client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		Addr:         b.client.Addr,
+		GroupID:      groupID,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{b.topic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("could not commit Kafka offsets: %w", err)
+	}
+
+	for _, p := range commitResp.Topics[b.topic] {
+		if p.Error != nil {
+			return fmt.Errorf("could not commit Kafka offset for partition %d: %w", p.Partition, p.Error)
+		}
+	}
+
+	return nil
+}
+
+// Returns the consumer group ID for a handler type.
+func (b *EventBus) groupID(handlerType eh.EventHandlerType) string {
+	return b.appID + "_" + handlerType.String()
+}
+
 // Errors implements the Errors method of the eventhorizon.EventBus interface.
 func (b *EventBus) Errors() <-chan error {
 	return b.errCh
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (b *EventBus) HealthCheckName() string {
+	return "kafka eventbus"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by fetching the topic metadata from the brokers, and checking that
+// the consumers of all added handlers are running.
+func (b *EventBus) Check(ctx context.Context) error {
+	resp, err := b.client.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: []string{b.topic},
+	})
+	if err != nil {
+		return fmt.Errorf("could not get Kafka topic metadata: %w", err)
+	}
+
+	if len(resp.Topics) != 1 {
+		return fmt.Errorf("could not find Kafka topic %s", b.topic)
+	} else if err := resp.Topics[0].Error; err != nil {
+		return fmt.Errorf("Kafka topic error: %w", err)
+	}
+
+	return b.checkConsumers()
+}
+
+// Returns an error if the consumer of an added handler has stopped.
+func (b *EventBus) checkConsumers() error {
+	b.registeredMu.RLock()
+	defer b.registeredMu.RUnlock()
+
+	for handlerType, r := range b.registered {
+		select {
+		case <-r.done:
+			return fmt.Errorf("consumer for handler %s has stopped", handlerType)
+		default:
+		}
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.EventBus interface.
 func (b *EventBus) Close() error {
 	b.cancel()
 	b.wg.Wait()
 
+	// Remove the groups of any ephemeral handlers.
+	b.registeredMu.RLock()
+	for _, r := range b.registered {
+		if r.ephemeral {
+			if err := b.deleteGroup(context.Background(), r.groupID, true); err != nil {
+				log.Printf("eventhorizon: could not delete ephemeral group in Kafka event bus: %s", err)
+			}
+		}
+	}
+	b.registeredMu.RUnlock()
+
+	if b.deadLetterWriter != nil {
+		if err := b.deadLetterWriter.Close(); err != nil {
+			return fmt.Errorf("could not close dead letter writer: %w", err)
+		}
+	}
+
+	if err := b.batchWriter.Close(); err != nil {
+		return fmt.Errorf("could not close batch writer: %w", err)
+	}
+
 	return b.writer.Close()
 }
 
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	matcher   eh.EventMatcher
+	handler   eh.EventHandler
+	groupID   string
+	ephemeral bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
 // Handles all events coming in on the channel.
-func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, r *kafka.Reader) {
+func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, r *kafka.Reader, ephemeral bool, done chan struct{}) {
 	defer b.wg.Done()
+	defer close(done)
 	defer func() {
 		if err := r.Close(); err != nil {
 			log.Printf("eventhorizon: failed to close Kafka reader: %s", err)
@@ -371,23 +982,51 @@ func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, r *kafka.Reader)
 
 	handler := b.handler(m, h, r)
 
+	commit := func(msg kafka.Message) {
+		b.commitMessage(r, msg)
+	}
+	if ephemeral {
+		commit = func(kafka.Message) {}
+	}
+
+	// Handle and commit each message in order by default.
+	process := func(msg kafka.Message) bool {
+		if !b.handleMessage(ctx, h, handler, msg) {
+			return false
+		}
+
+		commit(msg)
+
+		return true
+	}
+
+	// Handle messages concurrently by key, and commit them in order. Wait for
+	// all ongoing handling before closing the reader.
+	if b.concurrency > 1 {
+		p := newProcessor(ctx, b.concurrency,
+			func(msg kafka.Message) bool {
+				return b.handleMessage(ctx, h, handler, msg)
+			},
+			commit,
+		)
+		defer p.wait()
+
+		process = p.process
+	}
+
 	for {
 		select {
-		case <-b.cctx.Done():
+		case <-ctx.Done():
 			return
 		default:
 		}
 
-		msg, err := r.FetchMessage(b.cctx)
+		msg, err := r.FetchMessage(ctx)
 		if errors.Is(err, context.Canceled) {
 			break
 		} else if err != nil {
 			err = fmt.Errorf("could not fetch message: %w", err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err}:
-			default:
-				log.Printf("eventhorizon: missed error in Kafka event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
 
 			// Retry the receive loop if there was an error.
 			time.Sleep(time.Second)
@@ -395,41 +1034,195 @@ func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, r *kafka.Reader)
 			continue
 		}
 
-		for {
-			select {
-			case <-b.cctx.Done():
-				return
-			default:
+		if !process(msg) {
+			return
+		}
+	}
+}
+
+// Handles a message with retries according to the retry policy. Returns true
+// when the message is done and can be committed, or false if the context was
+// cancelled before that.
+func (b *EventBus) handleMessage(ctx context.Context, h eh.EventHandler, handler func(context.Context, kafka.Message) *eh.EventBusError, msg kafka.Message) bool {
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+
+		err := handler(ctx, msg)
+		if err == nil {
+			return true
+		}
+
+		maxAttempts := b.retryPolicy.MaxAttempts
+		retry := maxAttempts == 0 || attempt < maxAttempts
+
+		err.HandlerType = h.HandlerType()
+		err.Retryable = retry
+		b.sendError(err)
+
+		if retry {
+			if !sleep(ctx, b.retryPolicy.backoff(attempt)) {
+				return false
 			}
 
-			if err := handler(b.cctx, msg); err != nil {
-				select {
-				case b.errCh <- err:
-				default:
-					log.Printf("eventhorizon: missed error in Kafka event bus: %s", err)
-				}
+			continue
+		}
 
-				time.Sleep(time.Second)
-			} else {
-				// Use a new context to always finish the commit.
-				if err := r.CommitMessages(context.Background(), msg); err != nil {
-					err = fmt.Errorf("could not commit message: %w", err)
-					select {
-					case b.errCh <- &eh.EventBusError{Err: err}:
-					default:
-						log.Printf("eventhorizon: missed error in Kafka event bus: %s", err)
-					}
-				}
+		// Skip the event after the last attempt, but only if it could be
+		// dead lettered, otherwise retry handling it again.
+		if err := b.deadLetter(ctx, h.HandlerType(), msg, err, attempt); err != nil {
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
 
-				break
+			if !sleep(ctx, b.retryPolicy.backoff(attempt)) {
+				return false
 			}
+
+			continue
 		}
+
+		return true
+	}
+}
+
+// Commits the message, which commits all earlier messages in its partition.
+func (b *EventBus) commitMessage(r *kafka.Reader, msg kafka.Message) {
+	// Use a new context to always finish the commit.
+	if err := r.CommitMessages(context.Background(), msg); err != nil {
+		err = fmt.Errorf("could not commit message: %w", err)
+		b.sendError(&eh.EventBusError{Err: err})
 	}
+}
 
+// Waits for the duration, returns false if the context is done before that.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Publishes a message that could not be handled to the dead letter topic, if
+// it is set.
+func (b *EventBus) deadLetter(ctx context.Context, handlerType eh.EventHandlerType, msg kafka.Message, handlerErr error, attempts int) error {
+	if b.deadLetterWriter == nil {
+		return nil
+	}
+
+	headers := make([]kafka.Header, 0, len(msg.Headers)+6)
+	for _, h := range msg.Headers {
+		// Remove headers from an earlier dead lettering.
+		if strings.HasPrefix(h.Key, deadLetterHeaderPrefix) || h.Key == targetHandlerTypeHeader {
+			continue
+		}
+
+		headers = append(headers, h)
+	}
+
+	headers = append(headers,
+		kafka.Header{Key: deadLetterErrorHeader, Value: []byte(handlerErr.Error())},
+		kafka.Header{Key: deadLetterAttemptsHeader, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: deadLetterHandlerTypeHeader, Value: []byte(handlerType.String())},
+		kafka.Header{Key: deadLetterTopicHeader, Value: []byte(msg.Topic)},
+		kafka.Header{Key: deadLetterPartitionHeader, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: deadLetterOffsetHeader, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+
+	if err := b.deadLetterWriter.WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}); err != nil {
+		return fmt.Errorf("could not publish dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// DeadLetterIdleTimeout is the time to wait for more dead letters before
+// RepublishDeadLetters returns.
+var DeadLetterIdleTimeout = 5 * time.Second
+
+// RepublishDeadLetters publishes all messages in the dead letter topic back to
+// the event topic, to be handled again by only the handler type that failed to
+// handle it. It returns when no more dead letters are received within the
+// DeadLetterIdleTimeout, with the number of republished messages. The position
+// in the dead letter topic is kept by a consumer group, so that messages are
+// only republished once.
+func (b *EventBus) RepublishDeadLetters(ctx context.Context) (int, error) {
+	if b.deadLetterTopic == "" {
+		return 0, errors.New("missing dead letter topic")
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     b.addresses,
+		Topic:       b.deadLetterTopic,
+		GroupID:     b.appID + "_" + b.deadLetterTopic,
+		MaxWait:     time.Second,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer r.Close()
+
+	n := 0
+
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, DeadLetterIdleTimeout)
+		msg, err := r.FetchMessage(fetchCtx)
+
+		cancel()
+
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("could not fetch dead letter: %w", err)
+		}
+
+		headers := make([]kafka.Header, 0, len(msg.Headers))
+
+		for _, h := range msg.Headers {
+			if strings.HasPrefix(h.Key, deadLetterHeaderPrefix) {
+				if h.Key == deadLetterHandlerTypeHeader {
+					headers = append(headers, kafka.Header{Key: targetHandlerTypeHeader, Value: h.Value})
+				}
+
+				continue
+			}
+
+			headers = append(headers, h)
+		}
+
+		if err := b.writer.WriteMessages(ctx, kafka.Message{
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: headers,
+		}); err != nil {
+			return n, fmt.Errorf("could not republish dead letter: %w", err)
+		}
+
+		if err := r.CommitMessages(context.Background(), msg); err != nil {
+			return n, fmt.Errorf("could not commit dead letter: %w", err)
+		}
+
+		n++
+	}
 }
 
 func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, r *kafka.Reader) func(ctx context.Context, msg kafka.Message) *eh.EventBusError {
 	return func(ctx context.Context, msg kafka.Message) *eh.EventBusError {
+		// Ignore republished dead letters for other handlers.
+		for _, header := range msg.Headers {
+			if header.Key == targetHandlerTypeHeader && string(header.Value) != h.HandlerType().String() {
+				return nil
+			}
+		}
+
 		event, ctx, err := b.codec.UnmarshalEvent(ctx, msg.Value)
 		if err != nil {
 			return &eh.EventBusError{
@@ -455,3 +1248,18 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, r *kafka.Reader
 		return nil
 	}
 }
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in Kafka event bus: %s", err)
+		}
+	}
+}
@@ -15,16 +15,20 @@
 package kafka
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -56,6 +60,19 @@ func TestAddHandlerIntegration(t *testing.T) {
 	}
 }
 
+func TestRemoveHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("", WithDeleteGroupOnRemove())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestRemoveHandler(t, bus, 3*time.Second)
+}
+
 func TestEventBusIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -76,6 +93,260 @@ func TestEventBusIntegration(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, 3*time.Second)
 }
 
+func TestEventBusWithConcurrencyIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus1, appID, err := newTestEventBus("", WithConcurrency(4))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	bus2, _, err := newTestEventBus(appID, WithConcurrency(4))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Logf("using topic: %s_events", appID)
+
+	eventbus.AcceptanceTest(t, bus1, bus2, 3*time.Second)
+}
+
+func TestDeadLetterIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Get a random app ID.
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	appID := "app-" + hex.EncodeToString(b)
+
+	bus, _, err := newTestEventBus(appID,
+		WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     100 * time.Millisecond,
+		}),
+		WithDeadLetterTopic(appID+"_dead_letters"),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx := context.Background()
+
+	failingHandler := mocks.NewEventHandler("failing")
+	failingHandler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, failingHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	otherHandler := mocks.NewEventHandler("other")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, otherHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	time.Sleep(3 * time.Second) // Need to wait here for handlers to be added.
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !otherHandler.Wait(3 * time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	// All attempts should fail before dead lettering.
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-bus.Errors():
+			if !errors.Is(err, failingHandler.Err) {
+				t.Error("the error should be correct:", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	failingHandler.Lock()
+	failingHandler.Err = nil
+	failingHandler.Unlock()
+
+	n, err := bus.(*EventBus).RepublishDeadLetters(ctx)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if n != 1 {
+		t.Error("there should be one republished dead letter:", n)
+	}
+
+	if !failingHandler.Wait(3 * time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	failingHandler.Lock()
+	if !eh.CompareEventSlices(failingHandler.Events, []eh.Event{event}) {
+		t.Error("the events were incorrect:")
+		t.Log(failingHandler.Events)
+	}
+	failingHandler.Unlock()
+
+	// The republished event should only be handled by the failing handler.
+	if otherHandler.Wait(time.Second) {
+		t.Error("the other handler should not receive the event again")
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestResetHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("", WithTopicPartitions(1))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	kafkaBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	if err := kafkaBus.ResetHandlerToTime(ctx, "not-added", time.Now()); err != eh.ErrHandlerNotFound {
+		t.Error("the error should be correct:", err)
+	}
+
+	handler := mocks.NewEventHandler("handler")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	time.Sleep(3 * time.Second) // Need to wait here for handlers to be added.
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	var (
+		events    []eh.Event
+		resetTime time.Time
+	)
+
+	for i := 0; i < 3; i++ {
+		if i == 1 {
+			time.Sleep(100 * time.Millisecond)
+
+			resetTime = time.Now()
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: fmt.Sprintf("event%d", i)}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+		if err := bus.HandleEvent(ctx, event); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		if !handler.Wait(3 * time.Second) {
+			t.Fatal("did not receive event in time")
+		}
+
+		events = append(events, event)
+	}
+
+	handler.Reset()
+
+	// Replay the last two events.
+	if err := kafkaBus.ResetHandlerToTime(ctx, handler.HandlerType(), resetTime); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if !handler.Wait(5 * time.Second) {
+			t.Fatal("did not receive event in time")
+		}
+	}
+
+	handler.Lock()
+	if !eh.CompareEventSlices(handler.Events, events[1:]) {
+		t.Error("the events were incorrect:")
+		t.Log(handler.Events)
+	}
+	handler.Unlock()
+
+	handler.Reset()
+
+	// Replay all events.
+	if err := kafkaBus.ResetHandlerToOffsets(ctx, handler.HandlerType(), map[int]int64{0: 0}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !handler.Wait(5 * time.Second) {
+			t.Fatal("did not receive event in time")
+		}
+	}
+
+	handler.Lock()
+	if !eh.CompareEventSlices(handler.Events, events) {
+		t.Error("the events were incorrect:")
+		t.Log(handler.Events)
+	}
+	handler.Unlock()
+
+	handler.Reset()
+
+	// Reset to after the newest event, which should only handle new events.
+	if err := kafkaBus.ResetHandlerToTime(ctx, handler.HandlerType(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if handler.Wait(time.Second) {
+		t.Error("there should be no replayed events:", handler.Events)
+	}
+
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event3"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler.Wait(5 * time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	handler.Lock()
+	if !eh.CompareEventSlices(handler.Events, []eh.Event{event}) {
+		t.Error("the events were incorrect:")
+		t.Log(handler.Events)
+	}
+	handler.Unlock()
+
+	// Invalid partitions should fail, but the handler should be restarted.
+	if err := kafkaBus.ResetHandlerToOffsets(ctx, handler.HandlerType(), map[int]int64{1: 0}); err == nil {
+		t.Error("there should be an error")
+	}
+
+	if err := kafkaBus.RemoveHandler(ctx, handler.HandlerType()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -91,6 +362,19 @@ func TestEventBusLoadtest(t *testing.T) {
 	eventbus.LoadTest(t, bus)
 }
 
+func TestHandleEventsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestHandleEvents(t, bus, 3*time.Second)
+}
+
 func BenchmarkEventBus(b *testing.B) {
 	bus, appID, err := newTestEventBus("")
 	if err != nil {
@@ -102,6 +386,17 @@ func BenchmarkEventBus(b *testing.B) {
 	eventbus.Benchmark(b, bus)
 }
 
+func BenchmarkEventBusBatch(b *testing.B) {
+	bus, appID, err := newTestEventBus("")
+	if err != nil {
+		b.Fatal("there should be no error:", err)
+	}
+
+	b.Logf("using topic: %s_events", appID)
+
+	eventbus.BenchmarkBatch(b, bus, 100)
+}
+
 func newTestEventBus(appID string, options ...Option) (eh.EventBus, string, error) {
 	// Connect to localhost if not running inside docker
 	addr := os.Getenv("KAFKA_ADDR")
@@ -240,3 +535,63 @@ func TestWithTopicPatitions(t *testing.T) {
 		})
 	}
 }
+
+func TestWithRetryPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy      RetryPolicy
+		expectError string
+	}{
+		"negative max attempts": {
+			RetryPolicy{MaxAttempts: -1, InitialBackoff: time.Second, MaxBackoff: time.Second},
+			"max attempts must not be negative",
+		},
+		"zero initial backoff": {
+			RetryPolicy{MaxAttempts: 1, MaxBackoff: time.Second},
+			"initial backoff must be greater than 0",
+		},
+		"max backoff less than initial backoff": {
+			RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Second, MaxBackoff: time.Millisecond},
+			"max backoff must not be less than initial backoff",
+		},
+	}
+
+	for desc, tc := range testCases {
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus("localhost:9093", "app", WithRetryPolicy(tc.policy))
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for i, want := range expected {
+		if got := p.backoff(i + 1); got != want {
+			t.Errorf("expected backoff for attempt %d to be %s, got: %s", i+1, want, got)
+		}
+	}
+}
+
+func TestWithConcurrency(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		_, err := NewEventBus("localhost:9093", "app", WithConcurrency(n))
+		if err == nil || !strings.HasSuffix(err.Error(), "concurrency must be greater than 0") {
+			t.Fatalf("expected error for concurrency %d, got: %v", n, err)
+		}
+	}
+}
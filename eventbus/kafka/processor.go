@@ -0,0 +1,158 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// processor handles messages concurrently in a number of workers. Messages
+// with the same key are always handled by the same worker, to keep their
+// order. Messages are committed in order per partition, up to the last message
+// for which all earlier messages are done.
+type processor struct {
+	ctx     context.Context
+	workers []chan kafka.Message
+	wg      sync.WaitGroup
+	handle  func(kafka.Message) bool
+	offsets *offsetTracker
+}
+
+func newProcessor(ctx context.Context, n int, handle func(kafka.Message) bool, commit func(kafka.Message)) *processor {
+	p := &processor{
+		ctx:     ctx,
+		workers: make([]chan kafka.Message, n),
+		handle:  handle,
+		offsets: newOffsetTracker(commit),
+	}
+
+	for i := range p.workers {
+		p.workers[i] = make(chan kafka.Message, 1)
+
+		p.wg.Add(1)
+
+		go p.work(p.workers[i])
+	}
+
+	return p
+}
+
+// Dispatches the message to the worker for its key. Blocks while the worker is
+// busy, returns false if the context is done before that.
+func (p *processor) process(msg kafka.Message) bool {
+	p.offsets.add(msg)
+
+	h := fnv.New32a()
+	_, _ = h.Write(msg.Key)
+	worker := p.workers[h.Sum32()%uint32(len(p.workers))]
+
+	select {
+	case worker <- msg:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+func (p *processor) work(ch <-chan kafka.Message) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case msg := <-ch:
+			if !p.handle(msg) {
+				return
+			}
+
+			p.offsets.done(msg)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Waits for all workers to stop, which they do when the context is done.
+func (p *processor) wait() {
+	p.wg.Wait()
+}
+
+// offsetTracker keeps track of the handled messages per partition, and commits
+// the last message for which all earlier messages are done.
+type offsetTracker struct {
+	partitions map[int]*partitionOffsets
+	commit     func(kafka.Message)
+	mu         sync.Mutex
+}
+
+type partitionOffsets struct {
+	pending []kafka.Message
+	done    map[int64]bool
+}
+
+func newOffsetTracker(commit func(kafka.Message)) *offsetTracker {
+	return &offsetTracker{
+		partitions: map[int]*partitionOffsets{},
+		commit:     commit,
+	}
+}
+
+// Adds a message in the order it was fetched.
+func (t *offsetTracker) add(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		p = &partitionOffsets{done: map[int64]bool{}}
+		t.partitions[msg.Partition] = p
+	}
+
+	p.pending = append(p.pending, msg)
+}
+
+// Marks a message as done, and commits the last contiguous done message.
+func (t *offsetTracker) done(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		return
+	}
+
+	p.done[msg.Offset] = true
+
+	var (
+		last      kafka.Message
+		completed bool
+	)
+
+	for len(p.pending) > 0 && p.done[p.pending[0].Offset] {
+		last = p.pending[0]
+		completed = true
+
+		delete(p.done, last.Offset)
+		p.pending = p.pending[1:]
+	}
+
+	// Commit while holding the lock to never commit offsets out of order.
+	if completed {
+		t.commit(last)
+	}
+}
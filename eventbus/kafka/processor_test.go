@@ -0,0 +1,161 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	var committed []kafka.Message
+
+	tracker := newOffsetTracker(func(msg kafka.Message) {
+		committed = append(committed, msg)
+	})
+
+	msgs := []kafka.Message{
+		{Partition: 0, Offset: 10},
+		{Partition: 0, Offset: 11},
+		{Partition: 0, Offset: 12},
+		{Partition: 1, Offset: 5},
+	}
+	for _, msg := range msgs {
+		tracker.add(msg)
+	}
+
+	// Not contiguous from the first pending message.
+	tracker.done(msgs[1])
+
+	if len(committed) != 0 {
+		t.Fatal("there should be no commits:", committed)
+	}
+
+	// Commits up to the last contiguous done message.
+	tracker.done(msgs[0])
+
+	if len(committed) != 1 || committed[0].Offset != 11 {
+		t.Fatal("the commits should be correct:", committed)
+	}
+
+	// Partitions are tracked separately.
+	tracker.done(msgs[3])
+
+	if len(committed) != 2 || committed[1].Partition != 1 || committed[1].Offset != 5 {
+		t.Fatal("the commits should be correct:", committed)
+	}
+
+	tracker.done(msgs[2])
+
+	if len(committed) != 3 || committed[2].Offset != 12 {
+		t.Fatal("the commits should be correct:", committed)
+	}
+}
+
+func TestProcessor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		numKeys    = 4
+		numPerKey  = 20
+		numEvents  = numKeys * numPerKey
+		lastOffset = numEvents - 1
+	)
+
+	var (
+		handled    = map[string][]int64{}
+		handledMu  sync.Mutex
+		lastCommit int64 = -1
+		commitMu   sync.Mutex
+	)
+
+	p := newProcessor(ctx, 3,
+		func(msg kafka.Message) bool {
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+
+			handledMu.Lock()
+			handled[string(msg.Key)] = append(handled[string(msg.Key)], msg.Offset)
+			handledMu.Unlock()
+
+			return true
+		},
+		func(msg kafka.Message) {
+			commitMu.Lock()
+			defer commitMu.Unlock()
+
+			if msg.Offset <= lastCommit {
+				t.Error("the commits should be in order:", msg.Offset, lastCommit)
+			}
+
+			lastCommit = msg.Offset
+		},
+	)
+
+	for i := 0; i < numEvents; i++ {
+		msg := kafka.Message{
+			Key:    []byte(fmt.Sprintf("key-%d", i%numKeys)),
+			Offset: int64(i),
+		}
+		if !p.process(msg) {
+			t.Fatal("the message should be processed")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		commitMu.Lock()
+		done := lastCommit == lastOffset
+		commitMu.Unlock()
+
+		if done {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("all messages should be committed in time")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	p.wait()
+
+	// Messages with the same key should be handled in order.
+	handledMu.Lock()
+	defer handledMu.Unlock()
+
+	for key, offsets := range handled {
+		if len(offsets) != numPerKey {
+			t.Error("all messages should be handled for key:", key, len(offsets))
+		}
+
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] < offsets[i-1] {
+				t.Error("the messages should be handled in order for key:", key, offsets)
+
+				break
+			}
+		}
+	}
+}
@@ -16,6 +16,7 @@ package local
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -23,22 +24,63 @@ import (
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 // DefaultQueueSize is the default queue size per handler for publishing events.
 var DefaultQueueSize = 1000
 
+// DefaultDelay is the default artificial delay before handling each event, to
+// simulate a network. Use WithDelay(0) to disable it.
+var DefaultDelay = time.Millisecond
+
+// ErrQueueFull is returned when publishing with OverflowError and the queue of
+// a handler is full.
+var ErrQueueFull = errors.New("publish queue full")
+
+// ErrBusClosed is returned when publishing on a closed event bus.
+var ErrBusClosed = errors.New("event bus closed")
+
+// OverflowPolicy is the policy used when publishing to a handler with a full queue.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the event for the handler with the full queue, which
+	// is reported to the dropped event hook. This is the default.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock blocks publishing until there is room in the queue or the
+	// context of the publisher is done, in which case an error is returned.
+	// Publishing should use a context with a deadline, as a handler that is
+	// stuck will otherwise block publishing indefinitely.
+	OverflowBlock
+	// OverflowError drops the event for the handler with the full queue, and
+	// returns ErrQueueFull after publishing to all other handlers.
+	OverflowError
+)
+
+// DroppedEventHook is called for each handler that did not get an event
+// because its queue was full.
+type DroppedEventHook func(ctx context.Context, event eh.Event, handlerType eh.EventHandlerType)
+
 // EventBus is a local event bus that delegates handling of published events
 // to all matching registered handlers, in order of registration.
+//
+// Multiple event buses can share a Group to simulate multiple nodes using a
+// remote event bus, see Group for the delivery semantics.
 type EventBus struct {
 	group        *Group
-	registered   map[eh.EventHandlerType]struct{}
+	registered   map[eh.EventHandlerType]*registration
 	registeredMu sync.RWMutex
 	errCh        chan error
+	errorHandler eh.ErrorHandler
 	cctx         context.Context
 	cancel       context.CancelFunc
 	wg           sync.WaitGroup
 	codec        eh.EventCodec
+	delay        time.Duration
+	overflow     OverflowPolicy
+	droppedHook  DroppedEventHook
 }
 
 // NewEventBus creates a EventBus.
@@ -47,11 +89,12 @@ func NewEventBus(options ...Option) *EventBus {
 
 	b := &EventBus{
 		group:      NewGroup(),
-		registered: map[eh.EventHandlerType]struct{}{},
+		registered: map[eh.EventHandlerType]*registration{},
 		errCh:      make(chan error, 100),
 		cctx:       ctx,
 		cancel:     cancel,
 		codec:      &json.EventCodec{},
+		delay:      DefaultDelay,
 	}
 
 	// Apply configuration options.
@@ -83,6 +126,45 @@ func WithGroup(g *Group) Option {
 	}
 }
 
+// WithDelay sets the artificial delay before handling each event, used to
+// simulate a network. A zero delay disables it.
+func WithDelay(d time.Duration) Option {
+	return func(b *EventBus) {
+		b.delay = d
+	}
+}
+
+// WithOverflowPolicy sets the policy used when publishing to a handler with a
+// full queue, the default is OverflowDrop.
+func WithOverflowPolicy(p OverflowPolicy) Option {
+	return func(b *EventBus) {
+		b.overflow = p
+	}
+}
+
+// WithBlockingPublish blocks publishing when a handler queue is full, until
+// there is room or the context of the publisher is done. It is the same as
+// WithOverflowPolicy(OverflowBlock).
+func WithBlockingPublish() Option {
+	return WithOverflowPolicy(OverflowBlock)
+}
+
+// WithDroppedEventHook sets a hook that is called for each handler that did
+// not get an event because its queue was full.
+func WithDroppedEventHook(hook DroppedEventHook) Option {
+	return func(b *EventBus) {
+		b.droppedHook = hook
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) {
+		b.errorHandler = h
+	}
+}
+
 // HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandlerType() eh.EventHandlerType {
 	return "eventbus"
@@ -90,12 +172,29 @@ func (b *EventBus) HandlerType() eh.EventHandlerType {
 
 // HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	if b.cctx.Err() != nil {
+		return ErrBusClosed
+	}
+
+	// Marshal and unmarshal the context to both simulate only sending data
+	// that would be sent over a network bus and also break any relationship
+	// with the old context.
 	data, err := b.codec.MarshalEvent(ctx, event)
 	if err != nil {
 		return fmt.Errorf("could not marshal event: %w", err)
 	}
 
-	return b.group.publish(ctx, data)
+	dropped, err := b.group.publish(ctx, b.cctx.Done(), data, b.overflow)
+
+	for _, id := range dropped {
+		if b.droppedHook != nil {
+			b.droppedHook(ctx, event, eh.EventHandlerType(id))
+		} else {
+			log.Printf("eventhorizon: publish queue full in local event bus for handler: %s", id)
+		}
+	}
+
+	return err
 }
 
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
@@ -116,17 +215,63 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 		return eh.ErrHandlerAlreadyAdded
 	}
 
-	// Get or create the channel.
-	id := h.HandlerType().String()
-	ch := b.group.channel(id)
+	// Join the queue group of the handler type. Ephemeral handlers get a queue
+	// group per bus, so that every bus receives all events.
+	queueID := h.HandlerType().String()
+	if ephemeral.IsEphemeral(h) {
+		queueID += "_" + uuid.New().String()
+	}
+
+	ch := b.group.join(queueID)
 
 	// Register handler.
-	b.registered[h.HandlerType()] = struct{}{}
+	ctx, cancel := context.WithCancel(b.cctx)
+	r := &registration{
+		queueID: queueID,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	b.registered[h.HandlerType()] = r
 
 	b.wg.Add(1)
 
 	// Handle until context is cancelled.
-	go b.handle(m, h, ch)
+	go b.handle(ctx, m, h, ch, r.done)
+
+	return nil
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface. The queue group for the handler
+// type is removed from the group when no other bus in the group handles it.
+// If the context is done before the handler has stopped, the queue group is
+// left when it stops.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	r, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop handling and wait for any ongoing handling to finish.
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		go func() {
+			<-r.done
+			b.group.leave(r.queueID)
+		}()
+
+		return ctx.Err()
+	}
+
+	b.group.leave(r.queueID)
 
 	return nil
 }
@@ -140,7 +285,16 @@ func (b *EventBus) Errors() <-chan error {
 func (b *EventBus) Close() error {
 	b.cancel()
 	b.wg.Wait()
-	b.group.close()
+
+	// Leave all queue groups, other buses in the group keeps handling events.
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	for _, r := range b.registered {
+		b.group.leave(r.queueID)
+	}
+
+	b.registered = map[eh.EventHandlerType]*registration{}
 
 	return nil
 }
@@ -150,24 +304,30 @@ type evt struct {
 	event   eh.Event
 }
 
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	queueID string
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
 // Handles all events coming in on the channel.
-func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, ch <-chan []byte) {
+func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, ch <-chan []byte, done chan struct{}) {
 	defer b.wg.Done()
+	defer close(done)
 
 	for {
 		select {
 		case data := <-ch:
 			// Artificial delay to simulate network.
-			time.Sleep(time.Millisecond)
+			if b.delay > 0 {
+				time.Sleep(b.delay)
+			}
 
 			event, ctx, err := b.codec.UnmarshalEvent(b.cctx, data)
 			if err != nil {
 				err = fmt.Errorf("could not unmarshal event: %w", err)
-				select {
-				case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx}:
-				default:
-					log.Printf("eventhorizon: missed error in local event bus: %s", err)
-				}
+				b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, HandlerType: h.HandlerType()})
 
 				return
 			}
@@ -180,68 +340,150 @@ func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, ch <-chan []byte
 			// Handle the event if it did match.
 			if err := h.HandleEvent(ctx, event); err != nil {
 				err = fmt.Errorf("could not handle event (%s): %s", h.HandlerType(), err.Error())
-				select {
-				case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
-				default:
-					log.Printf("eventhorizon: missed error in local event bus: %s", err)
-				}
+				b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, Event: event, HandlerType: h.HandlerType()})
 			}
-		case <-b.cctx.Done():
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-// Group is a publishing group shared by multiple event busses locally, if needed.
+// Group is a publishing group shared by multiple event busses locally, if
+// needed. Each bus in a group acts as a separate node using the same remote
+// event bus, and the delivery semantics are the same as for the remote buses,
+// which use the handler type as the consumer group:
+//
+// Handlers with the same handler type form a queue group. Each published event
+// is delivered to one of the handlers in the queue group, which compete for the
+// events. This distributes the work between nodes running the same handler.
+//
+// Handlers wrapped with the observer middleware get a handler type per observer
+// group, which is a separate queue group that receives all events. Observers
+// using observer.RandomGroup (or observer.Middleware) thus receive all events on
+// every bus, while observers sharing a named group compete for the events.
+//
+// Handlers wrapped with the ephemeral middleware get a queue group per bus, and
+// thus receive all events on every bus.
 type Group struct {
-	bus   map[string]chan []byte
-	busMu sync.RWMutex
+	queues   map[string]*queue
+	queuesMu sync.RWMutex
+}
+
+// queue is a queue group, where all members compete for events on the channel.
+// The done channel is closed when the queue group is removed.
+type queue struct {
+	id      string
+	ch      chan []byte
+	done    chan struct{}
+	members int
 }
 
 // NewGroup creates a Group.
 func NewGroup() *Group {
 	return &Group{
-		bus: map[string]chan []byte{},
+		queues: map[string]*queue{},
+	}
+}
+
+// Joins the queue group with the ID, creating it if needed. Returns the
+// channel shared by all members of the queue group.
+func (g *Group) join(id string) <-chan []byte {
+	g.queuesMu.Lock()
+	defer g.queuesMu.Unlock()
+
+	q, ok := g.queues[id]
+	if !ok {
+		q = &queue{
+			id:   id,
+			ch:   make(chan []byte, DefaultQueueSize),
+			done: make(chan struct{}),
+		}
+		g.queues[id] = q
 	}
+
+	q.members++
+
+	return q.ch
 }
 
-func (g *Group) channel(id string) <-chan []byte {
-	g.busMu.Lock()
-	defer g.busMu.Unlock()
+// Leaves the queue group with the ID, and removes it when there are no more
+// members. Any events left in a removed queue group are discarded.
+func (g *Group) leave(id string) {
+	g.queuesMu.Lock()
+	defer g.queuesMu.Unlock()
 
-	if ch, ok := g.bus[id]; ok {
-		return ch
+	q, ok := g.queues[id]
+	if !ok {
+		return
 	}
 
-	ch := make(chan []byte, DefaultQueueSize)
-	g.bus[id] = ch
+	q.members--
+
+	if q.members > 0 {
+		return
+	}
 
-	return ch
+	delete(g.queues, id)
+	close(q.done)
 }
 
-func (g *Group) publish(ctx context.Context, b []byte) error {
-	g.busMu.RLock()
-	defer g.busMu.RUnlock()
+// Publishes the data once to all queue groups, using the overflow policy for
+// full queues. Blocking publishing is aborted when either the context or the
+// done channel is done, and skips queue groups that are removed while waiting.
+// Returns the IDs of the queue groups where the data was dropped.
+func (g *Group) publish(ctx context.Context, done <-chan struct{}, b []byte, policy OverflowPolicy) ([]string, error) {
+	// Copy the queue groups to not hold the lock while blocking, which would
+	// block handlers from joining and leaving.
+	g.queuesMu.RLock()
+	queues := make([]*queue, 0, len(g.queues))
+	for _, q := range g.queues {
+		queues = append(queues, q)
+	}
+	g.queuesMu.RUnlock()
+
+	var dropped []string
 
-	for _, ch := range g.bus {
-		// Marshal and unmarshal the context to both simulate only sending data
-		// that would be sent over a network bus and also break any relationship
-		// with the old context.
+	for _, q := range queues {
 		select {
-		case ch <- b:
+		case q.ch <- b:
+			continue
 		default:
-			log.Printf("eventhorizon: publish queue full in local event bus")
 		}
+
+		if policy == OverflowBlock {
+			select {
+			case q.ch <- b:
+				continue
+			case <-q.done:
+				continue
+			case <-ctx.Done():
+				return append(dropped, q.id), fmt.Errorf("could not publish event to handler %s: %w", q.id, ctx.Err())
+			case <-done:
+				return append(dropped, q.id), fmt.Errorf("could not publish event to handler %s: %w", q.id, ErrBusClosed)
+			}
+		}
+
+		dropped = append(dropped, q.id)
 	}
 
-	return nil
+	if policy == OverflowError && len(dropped) > 0 {
+		return dropped, fmt.Errorf("could not publish event to handlers %v: %w", dropped, ErrQueueFull)
+	}
+
+	return dropped, nil
 }
 
-// Closes all the open channels after handling is done.
-func (g *Group) close() {
-	for _, ch := range g.bus {
-		close(ch)
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
 	}
 
-	g.bus = nil
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in local event bus: %s", err)
+		}
+	}
 }
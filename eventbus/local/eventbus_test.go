@@ -15,10 +15,17 @@
 package local
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
+	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/observer"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 // NOTE: Not named "Integration" to enable running with the unit tests.
@@ -35,6 +42,16 @@ func TestAddHandler(t *testing.T) {
 	eventbus.TestAddHandler(t, bus)
 }
 
+// NOTE: Not named "Integration" to enable running with the unit tests.
+func TestRemoveHandler(t *testing.T) {
+	bus := NewEventBus()
+	if bus == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	eventbus.TestRemoveHandler(t, bus, 100*time.Millisecond)
+}
+
 // NOTE: Not named "Integration" to enable running with the unit tests.
 func TestEventBus(t *testing.T) {
 	group := NewGroup()
@@ -55,6 +72,349 @@ func TestEventBus(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
 }
 
+func TestEventBusWithoutDelay(t *testing.T) {
+	group := NewGroup()
+	if group == nil {
+		t.Fatal("there should be a group")
+	}
+
+	bus1 := NewEventBus(WithGroup(group), WithDelay(0), WithBlockingPublish())
+	if bus1 == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	bus2 := NewEventBus(WithGroup(group), WithDelay(0), WithBlockingPublish())
+	if bus2 == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
+}
+
+func TestEventBusOverflowPolicy(t *testing.T) {
+	defaultQueueSize := DefaultQueueSize
+	DefaultQueueSize = 1
+
+	defer func() {
+		DefaultQueueSize = defaultQueueSize
+	}()
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	newEvent := func() eh.Event {
+		return eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	}
+
+	// Fills the queue of a handler which is blocked on the first event.
+	fillQueue := func(t *testing.T, bus *EventBus) *blockingHandler {
+		h := newBlockingHandler("handler")
+		if err := bus.AddHandler(context.Background(), eh.MatchAll{}, h); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		if err := bus.HandleEvent(context.Background(), newEvent()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		<-h.started
+
+		if err := bus.HandleEvent(context.Background(), newEvent()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		return h
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		var (
+			dropped   []eh.EventHandlerType
+			droppedMu sync.Mutex
+		)
+
+		bus := NewEventBus(WithDelay(0), WithDroppedEventHook(
+			func(ctx context.Context, event eh.Event, handlerType eh.EventHandlerType) {
+				droppedMu.Lock()
+				defer droppedMu.Unlock()
+
+				dropped = append(dropped, handlerType)
+			},
+		))
+
+		h := fillQueue(t, bus)
+
+		if err := bus.HandleEvent(context.Background(), newEvent()); err != nil {
+			t.Error("there should be no error:", err)
+		}
+
+		droppedMu.Lock()
+		if len(dropped) != 1 || dropped[0] != h.HandlerType() {
+			t.Error("the dropped handlers should be correct:", dropped)
+		}
+		droppedMu.Unlock()
+
+		close(h.unblock)
+
+		if err := bus.Close(); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		bus := NewEventBus(WithDelay(0), WithOverflowPolicy(OverflowError))
+
+		h := fillQueue(t, bus)
+
+		if err := bus.HandleEvent(context.Background(), newEvent()); !errors.Is(err, ErrQueueFull) {
+			t.Error("the error should be correct:", err)
+		}
+
+		close(h.unblock)
+
+		if err := bus.Close(); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		bus := NewEventBus(WithDelay(0), WithBlockingPublish())
+
+		h := fillQueue(t, bus)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		if err := bus.HandleEvent(ctx, newEvent()); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("the error should be correct:", err)
+		}
+
+		errCh := make(chan error, 1)
+
+		go func() {
+			errCh <- bus.HandleEvent(context.Background(), newEvent())
+		}()
+
+		select {
+		case err := <-errCh:
+			t.Error("publishing should block:", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(h.unblock)
+
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Error("there should be no error:", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("publishing should not block")
+		}
+
+		if err := bus.Close(); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	})
+
+	t.Run("remove handler", func(t *testing.T) {
+		bus := NewEventBus(WithDelay(0), WithBlockingPublish())
+
+		h := fillQueue(t, bus)
+
+		errCh := make(chan error, 1)
+
+		go func() {
+			errCh <- bus.HandleEvent(context.Background(), newEvent())
+		}()
+
+		select {
+		case err := <-errCh:
+			t.Error("publishing should block:", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		removeErrCh := make(chan error, 1)
+
+		go func() {
+			removeErrCh <- bus.RemoveHandler(context.Background(), h.HandlerType())
+		}()
+
+		select {
+		case err := <-removeErrCh:
+			t.Error("removing should wait for the handler:", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(h.unblock)
+
+		select {
+		case err := <-removeErrCh:
+			if err != nil {
+				t.Error("there should be no error:", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("removing the handler should not block")
+		}
+
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Error("there should be no error:", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("publishing should not block")
+		}
+
+		if err := bus.Close(); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	})
+
+	t.Run("remove handler timeout", func(t *testing.T) {
+		bus := NewEventBus(WithDelay(0), WithBlockingPublish())
+
+		h := fillQueue(t, bus)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		if err := bus.RemoveHandler(ctx, h.HandlerType()); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("the error should be correct:", err)
+		}
+
+		close(h.unblock)
+
+		// The queue group should be left when the handler stops.
+		left := false
+
+		for i := 0; i < 100 && !left; i++ {
+			bus.group.queuesMu.RLock()
+			left = len(bus.group.queues) == 0
+			bus.group.queuesMu.RUnlock()
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if !left {
+			t.Error("the queue group should be removed")
+		}
+
+		if err := bus.Close(); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		bus := NewEventBus(WithDelay(0))
+
+		if err := bus.Close(); err != nil {
+			t.Error("there should be no error:", err)
+		}
+
+		if err := bus.HandleEvent(context.Background(), newEvent()); !errors.Is(err, ErrBusClosed) {
+			t.Error("the error should be correct:", err)
+		}
+	})
+}
+
+func TestGroupDelivery(t *testing.T) {
+	ctx := context.Background()
+
+	group := NewGroup()
+	if group == nil {
+		t.Fatal("there should be a group")
+	}
+
+	bus1 := NewEventBus(WithGroup(group), WithDelay(0))
+	if bus1 == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	bus2 := NewEventBus(WithGroup(group), WithDelay(0))
+	if bus2 == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	type handlers struct {
+		worker, observer, namedObserver *mocks.EventHandler
+	}
+
+	addHandlers := func(bus *EventBus) handlers {
+		h := handlers{
+			worker:        mocks.NewEventHandler("worker"),
+			observer:      mocks.NewEventHandler("observer"),
+			namedObserver: mocks.NewEventHandler("observer"),
+		}
+
+		if err := bus.AddHandler(ctx, eh.MatchAll{}, h.worker); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		if err := bus.AddHandler(ctx, eh.MatchAll{},
+			eh.UseEventHandlerMiddleware(h.observer, observer.Middleware)); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		if err := bus.AddHandler(ctx, eh.MatchAll{},
+			eh.UseEventHandlerMiddleware(h.namedObserver, observer.NewMiddleware(observer.NamedGroup("shared")))); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		return h
+	}
+
+	handlers1 := addHandlers(bus1)
+	handlers2 := addHandlers(bus2)
+
+	const numEvents = 10
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < numEvents; i++ {
+		event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+		if err := bus1.HandleEvent(ctx, event); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	// Handlers with the same type compete for the events.
+	if n := receivedEvents(handlers1.worker) + receivedEvents(handlers2.worker); n != numEvents {
+		t.Error("the workers should share the events:", n)
+	}
+
+	// Observers with random groups receive all events.
+	if n := receivedEvents(handlers1.observer); n != numEvents {
+		t.Error("the observer should receive all events:", n)
+	}
+
+	if n := receivedEvents(handlers2.observer); n != numEvents {
+		t.Error("the observer should receive all events:", n)
+	}
+
+	// Observers with the same named group compete for the events.
+	if n := receivedEvents(handlers1.namedObserver) + receivedEvents(handlers2.namedObserver); n != numEvents {
+		t.Error("the named observers should share the events:", n)
+	}
+
+	// Closing one bus should not affect the other buses in the group.
+	if err := bus1.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := bus2.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if n := receivedEvents(handlers2.worker); n != 1 {
+		t.Error("the remaining worker should receive the event:", n)
+	}
+
+	if err := bus2.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	bus := NewEventBus()
 	if bus == nil {
@@ -72,3 +432,95 @@ func BenchmarkEventBus(b *testing.B) {
 
 	eventbus.Benchmark(b, bus)
 }
+
+// Returns the number of events received by the handler, until no more events
+// are received.
+func receivedEvents(h *mocks.EventHandler) int {
+	n := 0
+	for h.Wait(100 * time.Millisecond) {
+		n++
+	}
+
+	return n
+}
+
+// blockingHandler is a handler that blocks when handling the first event,
+// until unblocked.
+type blockingHandler struct {
+	handlerType eh.EventHandlerType
+	started     chan struct{}
+	unblock     chan struct{}
+	once        sync.Once
+}
+
+func newBlockingHandler(handlerType eh.EventHandlerType) *blockingHandler {
+	return &blockingHandler{
+		handlerType: handlerType,
+		started:     make(chan struct{}),
+		unblock:     make(chan struct{}),
+	}
+}
+
+func (h *blockingHandler) HandlerType() eh.EventHandlerType {
+	return h.handlerType
+}
+
+func (h *blockingHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	h.once.Do(func() {
+		close(h.started)
+		<-h.unblock
+	})
+
+	return nil
+}
+
+func TestEventBusErrorHandler(t *testing.T) {
+	errs := make(chan error, 1)
+	bus := NewEventBus(WithErrorHandler(eh.ErrorHandlerFunc(func(err error) {
+		errs <- err
+	})))
+
+	defer bus.Close()
+
+	handler := mocks.NewEventHandler("handler")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(context.Background(), eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := bus.HandleEvent(context.Background(), event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	select {
+	case err := <-errs:
+		var busErr *eh.EventBusError
+		if !errors.As(err, &busErr) {
+			t.Fatal("the error should be an event bus error:", err)
+		}
+
+		if busErr.HandlerType != handler.HandlerType() {
+			t.Error("the handler type should be correct:", busErr.HandlerType)
+		}
+
+		if busErr.Event == nil || busErr.Event.AggregateID() != event.AggregateID() {
+			t.Error("the event should be correct:", busErr.Event)
+		}
+
+		if eh.IsRetryable(err) {
+			t.Error("the error should not be retryable")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("there should be an error")
+	}
+
+	// The error should also be sent on the errors channel.
+	select {
+	case <-bus.Errors():
+	case <-time.After(time.Second):
+		t.Error("there should be an error on the errors channel")
+	}
+}
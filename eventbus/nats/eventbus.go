@@ -16,8 +16,11 @@ package nats
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,26 +29,31 @@ import (
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/codec/json"
 	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 // EventBus is a NATS Jetstream event bus that delegates handling of published
 // events to all matching registered handlers.
 type EventBus struct {
-	appID        string
-	streamName   string
-	conn         *nats.Conn
-	js           nats.JetStreamContext
-	stream       *nats.StreamInfo
-	connOpts     []nats.Option
-	streamConfig *nats.StreamConfig
-	registered   map[eh.EventHandlerType]struct{}
-	registeredMu sync.RWMutex
-	errCh        chan error
-	cctx         context.Context
-	cancel       context.CancelFunc
-	wg           sync.WaitGroup
-	codec        eh.EventCodec
-	unsubscribe  []func()
+	appID          string
+	streamName     string
+	conn           *nats.Conn
+	js             nats.JetStreamContext
+	stream         *nats.StreamInfo
+	connOpts       []nats.Option
+	streamConfig   *nats.StreamConfig
+	deleteOnRemove bool
+	deliveryPolicy DeliveryPolicy
+	handlerPolicy  map[eh.EventHandlerType]DeliveryPolicy
+	deadLetterName string
+	registered     map[eh.EventHandlerType]*registration
+	registeredMu   sync.RWMutex
+	errCh          chan error
+	errorHandler   eh.ErrorHandler
+	cctx           context.Context
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+	codec          eh.EventCodec
 }
 
 // NewEventBus creates an EventBus, with optional settings.
@@ -53,13 +61,15 @@ func NewEventBus(url, appID string, options ...Option) (*EventBus, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	b := &EventBus{
-		appID:      appID,
-		streamName: appID + "_events",
-		registered: map[eh.EventHandlerType]struct{}{},
-		errCh:      make(chan error, 100),
-		cctx:       ctx,
-		cancel:     cancel,
-		codec:      &json.EventCodec{},
+		appID:          appID,
+		streamName:     appID + "_events",
+		deliveryPolicy: DefaultDeliveryPolicy,
+		handlerPolicy:  map[eh.EventHandlerType]DeliveryPolicy{},
+		registered:     map[eh.EventHandlerType]*registration{},
+		errCh:          make(chan error, 100),
+		cctx:           ctx,
+		cancel:         cancel,
+		codec:          &json.EventCodec{},
 	}
 
 	// Apply configuration options.
@@ -84,6 +94,21 @@ func NewEventBus(url, appID string, options ...Option) (*EventBus, error) {
 		return nil, fmt.Errorf("could not create Jetstream context: %w", err)
 	}
 
+	// Create the dead letter stream if used.
+	if b.deadLetterName != "" {
+		if _, err := b.js.StreamInfo(b.deadLetterName); errors.Is(err, nats.ErrStreamNotFound) {
+			if _, err := b.js.AddStream(&nats.StreamConfig{
+				Name:     b.deadLetterName,
+				Subjects: []string{b.deadLetterName + ".>"},
+				Storage:  nats.FileStorage,
+			}); err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+				return nil, fmt.Errorf("could not create NATS dead letter stream: %w", err)
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("could not get NATS dead letter stream: %w", err)
+		}
+	}
+
 	if b.stream, err = b.js.StreamInfo(b.streamName); err == nil {
 		return b, nil
 	}
@@ -138,6 +163,142 @@ func WithStreamConfig(opts *nats.StreamConfig) Option {
 	}
 }
 
+// WithDeleteConsumerOnRemove deletes the JetStream consumer of a handler when
+// it is removed with RemoveHandler, which also removes it for other instances
+// of the app. Consumers for ephemeral handlers are always deleted.
+func WithDeleteConsumerOnRemove() Option {
+	return func(b *EventBus) error {
+		b.deleteOnRemove = true
+
+		return nil
+	}
+}
+
+// DeliveryPolicy is the policy for redelivering events that a handler failed
+// to handle.
+type DeliveryPolicy struct {
+	// MaxDeliver is the max number of deliveries of an event, after which it is
+	// copied to the dead letter stream, if set, and terminated. Zero means no
+	// limit.
+	MaxDeliver int
+	// BackOff is the delays before each redelivery, where the last delay is used
+	// for all remaining redeliveries. No backoff redelivers immediately. The
+	// delays are also used as the ack wait of the consumer.
+	BackOff []time.Duration
+}
+
+// DefaultDeliveryPolicy is the default delivery policy, which redelivers events
+// immediately, up to 10 times.
+var DefaultDeliveryPolicy = DeliveryPolicy{
+	MaxDeliver: 10,
+}
+
+// Returns the delay before redelivering after the number of deliveries.
+func (p DeliveryPolicy) backOff(numDelivered uint64) time.Duration {
+	if len(p.BackOff) == 0 {
+		return 0
+	}
+
+	if i := int(numDelivered) - 1; i < len(p.BackOff) {
+		return p.BackOff[i]
+	}
+
+	return p.BackOff[len(p.BackOff)-1]
+}
+
+func (p DeliveryPolicy) validate() error {
+	if p.MaxDeliver < 0 {
+		return errors.New("max deliver must not be negative")
+	}
+
+	// Required by JetStream, when used.
+	if p.MaxDeliver > 0 && len(p.BackOff) > 0 && p.MaxDeliver <= len(p.BackOff) {
+		return errors.New("max deliver must be greater than the number of backoff delays")
+	}
+
+	for _, d := range p.BackOff {
+		if d <= 0 {
+			return errors.New("backoff delays must be greater than 0")
+		}
+	}
+
+	return nil
+}
+
+// Returns the max deliver for the consumer config, where -1 means no limit.
+// There is no limit when dead lettering, the max deliver is instead enforced
+// by the handler so that a failed dead letter publish can be redelivered.
+func (p DeliveryPolicy) consumerMaxDeliver(deadLetter bool) int {
+	if p.MaxDeliver == 0 || deadLetter {
+		return -1
+	}
+
+	return p.MaxDeliver
+}
+
+// WithDeliveryPolicy sets the default delivery policy for all handlers.
+//
+// Defaults to: DefaultDeliveryPolicy
+func WithDeliveryPolicy(p DeliveryPolicy) Option {
+	return func(b *EventBus) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+
+		b.deliveryPolicy = p
+
+		return nil
+	}
+}
+
+// WithHandlerDeliveryPolicy sets the delivery policy for a handler type,
+// overriding the default delivery policy.
+func WithHandlerDeliveryPolicy(handlerType eh.EventHandlerType, p DeliveryPolicy) Option {
+	return func(b *EventBus) error {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("invalid delivery policy for %s: %w", handlerType, err)
+		}
+
+		b.handlerPolicy[handlerType] = p
+
+		return nil
+	}
+}
+
+// WithDeadLetterStream copies events that could not be handled within the max
+// deliveries of the delivery policy to the dead letter stream, before
+// terminating them. The stream is created if it does not exist. Messages are
+// published on the subject "<stream>.<handler type>.<aggregate type>.<event type>",
+// with the original headers and additional headers for the error, number of
+// deliveries, handler type and original position. Events which can not be
+// published to the dead letter stream are redelivered with the last backoff
+// delay until they can be.
+func WithDeadLetterStream(name string) Option {
+	return func(b *EventBus) error {
+		if name == "" {
+			return errors.New("missing dead letter stream name")
+		}
+
+		b.deadLetterName = name
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
 // HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandlerType() eh.EventHandlerType {
 	return "eventbus"
@@ -150,15 +311,61 @@ func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
 		return fmt.Errorf("could not marshal event: %w", err)
 	}
 
-	subject := fmt.Sprintf("%s.%s.%s", b.streamName, event.AggregateType(), event.EventType())
-	if _, err := b.js.Publish(subject, data); err != nil {
+	if _, err := b.js.Publish(b.subject(event), data); err != nil {
 		return fmt.Errorf("could not publish event: %w", err)
 	}
 
 	return nil
 }
 
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. The events are published without
+// waiting for each ack, and the acks are awaited at the end.
+func (b *EventBus) HandleEvents(ctx context.Context, events []eh.Event) error {
+	futures := make([]nats.PubAckFuture, 0, len(events))
+
+	for _, event := range events {
+		data, err := b.codec.MarshalEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("could not marshal event: %w", err)
+		}
+
+		f, err := b.js.PublishAsync(b.subject(event), data)
+		if err != nil {
+			return fmt.Errorf("could not publish event: %w", err)
+		}
+
+		futures = append(futures, f)
+	}
+
+	for _, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			return fmt.Errorf("could not publish event: %w", err)
+		case <-ctx.Done():
+			return fmt.Errorf("could not publish event: %w", ctx.Err())
+		}
+	}
+
+	return nil
+}
+
+// Returns the subject to publish an event on.
+func (b *EventBus) subject(event eh.Event) string {
+	return fmt.Sprintf("%s.%s.%s", b.streamName, event.AggregateType(), event.EventType())
+}
+
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
+//
+// The consumer of the handler only receives the subjects of the aggregate and
+// event types of eh.MatchEvents, eh.MatchAggregates and any eh.MatchAny or
+// eh.MatchAll of them. Duplicate filters, and filters covered by others, are
+// removed. JetStream does not allow overlapping filter subjects, so if any of
+// the remaining filters still overlap, as for
+// eh.MatchAny{eh.MatchAggregates{a}, eh.MatchEvents{e}}, the consumer receives
+// all subjects and the matcher filters the events when handling. The same is
+// done for all other matchers.
 func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
 	if m == nil {
 		return eh.ErrMissingMatcher
@@ -176,55 +383,256 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 		return eh.ErrHandlerAlreadyAdded
 	}
 
-	// Create a consumer.
-	subject := createConsumerSubject(b.streamName, m)
-	consumerName := fmt.Sprintf("%s_%s", b.appID, h.HandlerType())
+	// Create the consumer before subscribing, to be able to use multiple filter
+	// subjects, and to not delete durable consumers when unsubscribing from the
+	// instance that created them. Ephemeral handlers get a consumer per
+	// instance, so that every instance receives all events.
+	subjects := consumerSubjects(b.streamName, m)
+	consumerName := b.consumerName(h.HandlerType())
+	ephemeral := ephemeral.IsEphemeral(h)
+	policy := b.policy(h.HandlerType())
+
+	if ephemeral {
+		consumerName += "_" + uuid.New().String()
+	}
 
-	sub, err := b.js.QueueSubscribe(subject, consumerName, b.handler(b.cctx, m, h),
-		nats.DeliverNew(),
+	if err := b.createConsumer(subjects, consumerName, policy, ephemeral); err != nil {
+		return err
+	}
+
+	// The subject must match the filter subject if there is only one.
+	var subject string
+	if len(subjects) == 1 {
+		subject = subjects[0]
+	}
+
+	hctx, cancel := context.WithCancel(b.cctx)
+
+	// The consumer config is already set when creating the consumer.
+	sub, err := b.js.QueueSubscribe(subject, consumerName, b.handler(hctx, m, h, policy),
+		nats.Bind(b.stream.Config.Name, consumerName),
 		nats.ManualAck(),
-		nats.AckExplicit(),
-		nats.AckWait(60*time.Second),
-		nats.MaxDeliver(10),
 	)
 	if err != nil {
-		return fmt.Errorf("could not subscribe to queue: %w", err)
-	}
+		cancel()
 
-	// capture the subscription of ephemeral consumers so we can unsubscribe when we exit.
-	if b.handlerIsEphemeral(h) {
-		b.unsubscribe = append(b.unsubscribe, func() { sub.Unsubscribe() })
+		return fmt.Errorf("could not subscribe to queue: %w", err)
 	}
 
 	// Register handler.
-	b.registered[h.HandlerType()] = struct{}{}
+	r := &registration{
+		sub:          sub,
+		consumerName: consumerName,
+		ephemeral:    ephemeral,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	b.registered[h.HandlerType()] = r
 
 	b.wg.Add(1)
 
 	// Handle until context is cancelled.
-	go b.handle(sub)
+	go b.handle(hctx, sub, r.done)
+
+	return nil
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	r, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop receiving, the consumer is not deleted by the unsubscribe as it was
+	// not created by the subscription.
+	if err := r.sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+		return fmt.Errorf("could not unsubscribe: %w", err)
+	}
+
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !b.deleteOnRemove && !r.ephemeral {
+		return nil
+	}
+
+	if err := b.js.DeleteConsumer(b.stream.Config.Name, r.consumerName,
+		nats.Context(ctx),
+	); err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+		return fmt.Errorf("could not delete consumer: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the delivery policy for a handler type.
+func (b *EventBus) policy(handlerType eh.EventHandlerType) DeliveryPolicy {
+	if p, ok := b.handlerPolicy[handlerType]; ok {
+		return p
+	}
+
+	return b.deliveryPolicy
+}
+
+// Returns the consumer name for a handler type.
+func (b *EventBus) consumerName(handlerType eh.EventHandlerType) string {
+	return fmt.Sprintf("%s_%s", b.appID, handlerType)
+}
+
+// EphemeralInactiveThreshold is how long the consumer of an ephemeral handler
+// is kept without any subscribers, for example after a crash, before it is
+// deleted by the server.
+var EphemeralInactiveThreshold = time.Minute
+
+// Creates a durable consumer for a queue subscription if it does not exist,
+// using the same config as a consumer created by subscribing. The filter
+// subjects and delivery policy of an existing consumer are updated if they
+// have changed. Consumers for ephemeral handlers are deleted by the server
+// when inactive.
+func (b *EventBus) createConsumer(subjects []string, consumerName string, policy DeliveryPolicy, ephemeral bool) error {
+	var filterSubject string
+
+	var filterSubjects []string
+
+	if len(subjects) == 1 {
+		filterSubject = subjects[0]
+	} else {
+		filterSubjects = subjects
+	}
+
+	info, err := b.js.ConsumerInfo(b.stream.Config.Name, consumerName)
+	if err == nil {
+		if info.Config.FilterSubject == filterSubject &&
+			equalSubjects(info.Config.FilterSubjects, filterSubjects) &&
+			info.Config.MaxDeliver == policy.consumerMaxDeliver(b.deadLetterName != "") &&
+			equalDurations(info.Config.BackOff, policy.BackOff) {
+			return nil
+		}
+
+		cfg := info.Config
+		cfg.FilterSubject = filterSubject
+		cfg.FilterSubjects = filterSubjects
+		cfg.MaxDeliver = policy.consumerMaxDeliver(b.deadLetterName != "")
+		cfg.BackOff = policy.BackOff
+
+		if _, err := b.js.UpdateConsumer(b.stream.Config.Name, &cfg); err != nil {
+			return fmt.Errorf("could not update consumer: %w", err)
+		}
+
+		return nil
+	} else if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return fmt.Errorf("could not get consumer: %w", err)
+	}
+
+	cfg := &nats.ConsumerConfig{
+		Durable:        consumerName,
+		DeliverSubject: b.conn.NewInbox(),
+		DeliverGroup:   consumerName,
+		DeliverPolicy:  nats.DeliverNewPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        60 * time.Second,
+		MaxDeliver:     policy.consumerMaxDeliver(b.deadLetterName != ""),
+		BackOff:        policy.BackOff,
+		FilterSubject:  filterSubject,
+		FilterSubjects: filterSubjects,
+		ReplayPolicy:   nats.ReplayInstantPolicy,
+	}
+	if ephemeral {
+		cfg.InactiveThreshold = EphemeralInactiveThreshold
+	}
+
+	if _, err := b.js.AddConsumer(b.stream.Config.Name, cfg); err != nil && !errors.Is(err, nats.ErrConsumerNameAlreadyInUse) {
+		// Another instance could have created it at the same time.
+		return fmt.Errorf("could not create consumer: %w", err)
+	}
 
 	return nil
 }
 
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalSubjects(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Errors implements the Errors method of the eventhorizon.EventBus interface.
 func (b *EventBus) Errors() <-chan error {
 	return b.errCh
 }
 
-// handlerIsEphemeral traverses the middleware chain and checks for the
-// ephemeral middleware and quires it's status.
-func (b *EventBus) handlerIsEphemeral(h eh.EventHandler) bool {
-	for {
-		if obs, ok := h.(ephemeral.EphemeralHandler); ok {
-			return obs.IsEphemeralHandler()
-		} else if c, ok := h.(eh.EventHandlerChain); ok {
-			if h = c.InnerHandler(); h != nil {
-				continue
-			}
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (b *EventBus) HealthCheckName() string {
+	return "nats eventbus"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by checking the connection and the stream on the server, and that
+// the consumers of all added handlers are running.
+func (b *EventBus) Check(ctx context.Context) error {
+	if !b.conn.IsConnected() {
+		return fmt.Errorf("not connected to NATS: %s", b.conn.Status())
+	}
+
+	if _, err := b.js.StreamInfo(b.streamName, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("could not get NATS stream info: %w", err)
+	}
+
+	return b.checkConsumers()
+}
+
+// Returns an error if the consumer of an added handler has stopped.
+func (b *EventBus) checkConsumers() error {
+	b.registeredMu.RLock()
+	defer b.registeredMu.RUnlock()
+
+	for handlerType, r := range b.registered {
+		select {
+		case <-r.done:
+			return fmt.Errorf("consumer for handler %s has stopped", handlerType)
+		default:
+		}
+
+		if !r.sub.IsValid() {
+			return fmt.Errorf("subscription for handler %s is not valid", handlerType)
 		}
-		return false
 	}
+
+	return nil
 }
 
 // Close implements the Close method of the eventhorizon.EventBus interface.
@@ -232,25 +640,44 @@ func (b *EventBus) Close() error {
 	b.cancel()
 	b.wg.Wait()
 
-	// unsubscribe any ephemeral subscribers we created.
-	for _, unSub := range b.unsubscribe {
-		unSub()
+	// Remove the consumers of any ephemeral handlers.
+	b.registeredMu.RLock()
+	for _, r := range b.registered {
+		if r.ephemeral {
+			r.sub.Unsubscribe()
+
+			if err := b.js.DeleteConsumer(b.stream.Config.Name, r.consumerName); err != nil &&
+				!errors.Is(err, nats.ErrConsumerNotFound) {
+				log.Printf("eventhorizon: could not delete ephemeral consumer in NATS event bus: %s", err)
+			}
+		}
 	}
+	b.registeredMu.RUnlock()
 
 	b.conn.Close()
 
 	return nil
 }
 
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	sub          *nats.Subscription
+	consumerName string
+	ephemeral    bool
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
 // Handles all events coming in on the channel.
-func (b *EventBus) handle(sub *nats.Subscription) {
+func (b *EventBus) handle(ctx context.Context, sub *nats.Subscription, done chan struct{}) {
 	defer b.wg.Done()
+	defer close(done)
 
 	for {
 		select {
-		case <-b.cctx.Done():
-			if b.cctx.Err() != context.Canceled {
-				log.Printf("eventhorizon: context error in NATS event bus: %s", b.cctx.Err())
+		case <-ctx.Done():
+			if ctx.Err() != context.Canceled {
+				log.Printf("eventhorizon: context error in NATS event bus: %s", ctx.Err())
 			}
 
 			return
@@ -258,17 +685,13 @@ func (b *EventBus) handle(sub *nats.Subscription) {
 	}
 }
 
-func (b *EventBus) handler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) func(msg *nats.Msg) {
+func (b *EventBus) handler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, policy DeliveryPolicy) func(msg *nats.Msg) {
 	return func(msg *nats.Msg) {
 		event, ctx, err := b.codec.UnmarshalEvent(ctx, msg.Data)
 		if err != nil {
 			err = fmt.Errorf("could not unmarshal event: %w", err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx}:
-			default:
-				log.Printf("eventhorizon: missed error in NATS event bus: %s", err)
-			}
-			msg.Nak()
+			retryable := b.redeliver(msg, h.HandlerType(), policy, err)
+			b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, HandlerType: h.HandlerType(), Retryable: retryable})
 
 			return
 		}
@@ -283,13 +706,14 @@ func (b *EventBus) handler(ctx context.Context, m eh.EventMatcher, h eh.EventHan
 		// Handle the event if it did match.
 		if err := h.HandleEvent(ctx, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
-			default:
-				log.Printf("eventhorizon: missed error in NATS event bus: %s", err)
-			}
-
-			msg.Nak()
+			retryable := b.redeliver(msg, h.HandlerType(), policy, err)
+			b.sendError(&eh.EventBusError{
+				Err:         err,
+				Ctx:         ctx,
+				Event:       event,
+				HandlerType: h.HandlerType(),
+				Retryable:   retryable,
+			})
 
 			return
 		}
@@ -298,24 +722,267 @@ func (b *EventBus) handler(ctx context.Context, m eh.EventMatcher, h eh.EventHan
 	}
 }
 
-func createConsumerSubject(streamName string, m eh.EventMatcher) string {
-	aggregateMatch := "*"
-	eventMatch := "*"
+const (
+	deadLetterErrorHeader        = "Eh-Dead-Letter-Error"
+	deadLetterNumDeliveredHeader = "Eh-Dead-Letter-Num-Delivered"
+	deadLetterHandlerTypeHeader  = "Eh-Dead-Letter-Handler-Type"
+	deadLetterStreamHeader       = "Eh-Dead-Letter-Stream"
+	deadLetterSequenceHeader     = "Eh-Dead-Letter-Sequence"
+	deadLetterSubjectHeader      = "Eh-Dead-Letter-Subject"
+)
+
+// Requests redelivery of a failed message according to the delivery policy,
+// or dead letters and terminates it after the last delivery. Returns true if
+// the message will be redelivered.
+func (b *EventBus) redeliver(msg *nats.Msg, handlerType eh.EventHandlerType, policy DeliveryPolicy, handlerErr error) bool {
+	meta, err := msg.Metadata()
+	if err != nil {
+		msg.Nak()
+
+		return true
+	}
+
+	if policy.MaxDeliver == 0 || meta.NumDelivered < uint64(policy.MaxDeliver) {
+		nak(msg, policy.backOff(meta.NumDelivered))
+
+		return true
+	}
+
+	if b.deadLetterName != "" {
+		if err := b.deadLetter(msg, meta, handlerType, handlerErr); err != nil {
+			// Redeliver to retry dead lettering, the consumer has no max deliver
+			// when dead lettering.
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: handlerType, Retryable: true})
+			nak(msg, policy.backOff(meta.NumDelivered))
 
+			return true
+		}
+	}
+
+	msg.Term()
+
+	return false
+}
+
+// Requests redelivery of a message after the delay, or immediately if zero.
+func nak(msg *nats.Msg, delay time.Duration) {
+	if delay > 0 {
+		msg.NakWithDelay(delay)
+	} else {
+		msg.Nak()
+	}
+}
+
+// Copies a message to the dead letter stream.
+func (b *EventBus) deadLetter(msg *nats.Msg, meta *nats.MsgMetadata, handlerType eh.EventHandlerType, handlerErr error) error {
+	// Use the aggregate and event type part of the original subject.
+	subject := fmt.Sprintf("%s.%s%s", b.deadLetterName, handlerType,
+		strings.TrimPrefix(msg.Subject, b.streamName))
+
+	dl := nats.NewMsg(subject)
+	dl.Data = msg.Data
+
+	for k, v := range msg.Header {
+		dl.Header[k] = v
+	}
+
+	dl.Header.Set(deadLetterErrorHeader, handlerErr.Error())
+	dl.Header.Set(deadLetterNumDeliveredHeader, strconv.FormatUint(meta.NumDelivered, 10))
+	dl.Header.Set(deadLetterHandlerTypeHeader, handlerType.String())
+	dl.Header.Set(deadLetterStreamHeader, meta.Stream)
+	dl.Header.Set(deadLetterSequenceHeader, strconv.FormatUint(meta.Sequence.Stream, 10))
+	dl.Header.Set(deadLetterSubjectHeader, msg.Subject)
+
+	if _, err := b.js.PublishMsg(dl); err != nil {
+		return fmt.Errorf("could not publish dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// subjectFilter is a filter for the aggregate and event type tokens of an event
+// subject, where "*" matches any type.
+type subjectFilter struct {
+	aggregateType string
+	eventType     string
+}
+
+var matchAllFilter = subjectFilter{"*", "*"}
+
+// Returns if the filter matches all subjects matched by the other filter.
+func (f subjectFilter) covers(o subjectFilter) bool {
+	return (f.aggregateType == "*" || f.aggregateType == o.aggregateType) &&
+		(f.eventType == "*" || f.eventType == o.eventType)
+}
+
+// Returns the filter matching subjects matched by both filters, if any.
+func (f subjectFilter) intersect(o subjectFilter) (subjectFilter, bool) {
+	intersectToken := func(a, b string) (string, bool) {
+		if a == "*" {
+			return b, true
+		} else if b == "*" || a == b {
+			return a, true
+		}
+
+		return "", false
+	}
+
+	aggregateType, ok := intersectToken(f.aggregateType, o.aggregateType)
+	if !ok {
+		return subjectFilter{}, false
+	}
+
+	eventType, ok := intersectToken(f.eventType, o.eventType)
+	if !ok {
+		return subjectFilter{}, false
+	}
+
+	return subjectFilter{aggregateType, eventType}, true
+}
+
+// Returns the subject filters for a matcher, or nil if the matcher can not be
+// expressed as subject filters and needs to match all subjects.
+func matcherFilters(m eh.EventMatcher) []subjectFilter {
 	switch m := m.(type) {
 	case eh.MatchEvents:
-		// Supports only matching one event, otherwise its wildcard.
-		if len(m) == 1 {
-			eventMatch = m[0].String()
+		if len(m) == 0 {
+			return nil
 		}
+
+		filters := make([]subjectFilter, 0, len(m))
+		for _, t := range m {
+			filters = append(filters, subjectFilter{"*", t.String()})
+		}
+
+		return filters
 	case eh.MatchAggregates:
-		// Supports only matching one aggregate, otherwise its wildcard.
-		if len(m) == 1 {
-			aggregateMatch = m[0].String()
+		if len(m) == 0 {
+			return nil
+		}
+
+		filters := make([]subjectFilter, 0, len(m))
+		for _, t := range m {
+			filters = append(filters, subjectFilter{t.String(), "*"})
+		}
+
+		return filters
+	case eh.MatchAny:
+		if len(m) == 0 {
+			return nil
+		}
+
+		// The union of all filters.
+		var filters []subjectFilter
+
+		for _, sm := range m {
+			f := matcherFilters(sm)
+			if f == nil {
+				return nil
+			}
+
+			filters = append(filters, f...)
+		}
+
+		return filters
+	case eh.MatchAll:
+		// The intersection of all filters, matchers that can not be
+		// expressed as filters matches all subjects.
+		filters := []subjectFilter{matchAllFilter}
+
+		for _, sm := range m {
+			f := matcherFilters(sm)
+			if f == nil {
+				continue
+			}
+
+			var intersection []subjectFilter
+
+			for _, a := range filters {
+				for _, b := range f {
+					if i, ok := a.intersect(b); ok {
+						intersection = append(intersection, i)
+					}
+				}
+			}
+
+			filters = intersection
+		}
+
+		// Nothing can match, let the matcher handle it.
+		if len(filters) == 0 {
+			return nil
+		}
+
+		return filters
+	}
+
+	return nil
+}
+
+// Returns the filter subjects for the consumer of a matcher. Filters that are
+// covered by other filters are removed, as JetStream does not allow overlapping
+// filter subjects. If the filters still overlap all subjects are matched, and
+// the matcher is used to filter events when handling. Overlapping filters can
+// not be merged into fewer subjects, as the smallest subject covering filters
+// with wildcards in different tokens is always the full wildcard.
+func consumerSubjects(streamName string, m eh.EventMatcher) []string {
+	filters := matcherFilters(m)
+	if filters == nil {
+		filters = []subjectFilter{matchAllFilter}
+	}
+
+	var reduced []subjectFilter
+
+	for i, f := range filters {
+		covered := false
+
+		for j, o := range filters {
+			// Keep the first of equal filters.
+			if i != j && o.covers(f) && (o != f || j < i) {
+				covered = true
+
+				break
+			}
+		}
+
+		if !covered {
+			reduced = append(reduced, f)
 		}
 	}
 
-	// TODO: Support eh.MatchAll in one level with aggregate and event.
+	overlapping := false
+
+	for i, f := range reduced {
+		for _, o := range reduced[i+1:] {
+			if _, ok := f.intersect(o); ok {
+				overlapping = true
+			}
+		}
+	}
+
+	if overlapping {
+		reduced = []subjectFilter{matchAllFilter}
+	}
 
-	return fmt.Sprintf("%s.%s.%s", streamName, aggregateMatch, eventMatch)
+	subjects := make([]string, 0, len(reduced))
+	for _, f := range reduced {
+		subjects = append(subjects, fmt.Sprintf("%s.%s.%s", streamName, f.aggregateType, f.eventType))
+	}
+
+	return subjects
+}
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in NATS event bus: %s", err)
+		}
+	}
 }
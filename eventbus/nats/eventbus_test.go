@@ -15,15 +15,23 @@
 package nats
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/nats-io/nats.go"
+
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 func TestAddHandlerIntegration(t *testing.T) {
@@ -39,6 +47,19 @@ func TestAddHandlerIntegration(t *testing.T) {
 	eventbus.TestAddHandler(t, bus1)
 }
 
+func TestRemoveHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestRemoveHandler(t, bus, time.Second)
+}
+
 func TestEventBusIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -59,6 +80,527 @@ func TestEventBusIntegration(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
 }
 
+func TestConsumerFilterIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	natsBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("handler")
+	m := eh.MatchAll{
+		eh.MatchAggregates{mocks.AggregateType},
+		eh.MatchEvents{mocks.EventType, mocks.EventOtherType},
+	}
+
+	if err := bus.AddHandler(ctx, m, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	info, err := natsBus.js.ConsumerInfo(natsBus.stream.Config.Name, natsBus.consumerName(handler.HandlerType()))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	expectedSubjects := consumerSubjects(natsBus.streamName, m)
+	if !equalSubjects(info.Config.FilterSubjects, expectedSubjects) {
+		t.Error("the filter subjects should be correct:", info.Config.FilterSubjects)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	otherAggregateEvent := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate("OtherAggregate", uuid.New(), 1))
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	for _, e := range []eh.Event{otherAggregateEvent, event} {
+		if err := bus.HandleEvent(ctx, e); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	handler.Lock()
+	if !eh.CompareEventSlices(handler.Events, []eh.Event{event}) {
+		t.Error("the events were incorrect:")
+		t.Log(handler.Events)
+	}
+	handler.Unlock()
+
+	// The non-matching event should not be delivered at all.
+	info, err = natsBus.js.ConsumerInfo(natsBus.stream.Config.Name, natsBus.consumerName(handler.HandlerType()))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if info.Delivered.Consumer != 1 {
+		t.Error("only the matching event should be delivered:", info.Delivered.Consumer)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestDeadLetterIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Get a random app ID.
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	appID := "app-" + hex.EncodeToString(b)
+	deadLetterStream := appID + "_dead_letters"
+
+	bus, _, err := newTestEventBus(appID,
+		WithHandlerDeliveryPolicy("failing", DeliveryPolicy{
+			MaxDeliver: 3,
+			BackOff:    []time.Duration{50 * time.Millisecond, 100 * time.Millisecond},
+		}),
+		WithDeadLetterStream(deadLetterStream),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	natsBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("failing")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	info, err := natsBus.js.ConsumerInfo(natsBus.stream.Config.Name, natsBus.consumerName(handler.HandlerType()))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The max deliver is enforced by the handler when dead lettering.
+	if info.Config.MaxDeliver != -1 || len(info.Config.BackOff) != 2 {
+		t.Error("the consumer config should be correct:", info.Config)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// All deliveries should fail before dead lettering.
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-bus.Errors():
+			if !errors.Is(err, handler.Err) {
+				t.Error("the error should be correct:", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	subject := fmt.Sprintf("%s.%s.%s.%s", deadLetterStream, handler.HandlerType(), mocks.AggregateType, mocks.EventType)
+
+	var msg *nats.RawStreamMsg
+
+	for i := 0; i < 10; i++ {
+		if msg, err = natsBus.js.GetLastMsg(deadLetterStream, subject); err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatal("there should be a dead letter:", err)
+	}
+
+	if msg.Header.Get(deadLetterNumDeliveredHeader) != "3" {
+		t.Error("the number of deliveries should be correct:", msg.Header)
+	}
+
+	if msg.Header.Get(deadLetterHandlerTypeHeader) != handler.HandlerType().String() {
+		t.Error("the handler type should be correct:", msg.Header)
+	}
+
+	if !strings.Contains(msg.Header.Get(deadLetterErrorHeader), handler.Err.Error()) {
+		t.Error("the error should be correct:", msg.Header)
+	}
+
+	dlEvent, _, err := natsBus.codec.UnmarshalEvent(ctx, msg.Data)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := eh.CompareEvents(dlEvent, event); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+
+	// The message should be terminated.
+	select {
+	case err := <-bus.Errors():
+		t.Error("there should be no more deliveries:", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestDeadLetterMissingStreamIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Get a random app ID.
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	appID := "app-" + hex.EncodeToString(b)
+	deadLetterStream := appID + "_dead_letters"
+
+	bus, _, err := newTestEventBus(appID,
+		WithHandlerDeliveryPolicy("failing", DeliveryPolicy{
+			MaxDeliver: 2,
+			BackOff:    []time.Duration{50 * time.Millisecond},
+		}),
+		WithDeadLetterStream(deadLetterStream),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	natsBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	// Remove the dead letter stream to make dead lettering fail.
+	if err := natsBus.js.DeleteStream(deadLetterStream); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	handler := mocks.NewEventHandler("failing")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The event should be redelivered after the max deliveries, as the dead
+	// letter could not be published.
+	var deadLetterErrs, handlerErrs int
+
+	for handlerErrs < 4 {
+		select {
+		case err := <-bus.Errors():
+			var busErr *eh.EventBusError
+			if !errors.As(err, &busErr) || !busErr.Retryable {
+				t.Error("the error should be retryable:", err)
+			}
+
+			if errors.Is(err, handler.Err) {
+				handlerErrs++
+			} else {
+				deadLetterErrs++
+			}
+		case <-time.After(time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	if deadLetterErrs == 0 {
+		t.Error("there should be dead letter errors")
+	}
+
+	// Recreate the dead letter stream, which should receive the event.
+	if _, err := natsBus.js.AddStream(&nats.StreamConfig{
+		Name:     deadLetterStream,
+		Subjects: []string{deadLetterStream + ".>"},
+	}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	subject := fmt.Sprintf("%s.%s.%s.%s", deadLetterStream, handler.HandlerType(), mocks.AggregateType, mocks.EventType)
+
+	var msg *nats.RawStreamMsg
+
+	for i := 0; i < 10; i++ {
+		if msg, err = natsBus.js.GetLastMsg(deadLetterStream, subject); err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatal("there should be a dead letter:", err)
+	}
+
+	dlEvent, _, err := natsBus.codec.UnmarshalEvent(ctx, msg.Data)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := eh.CompareEvents(dlEvent, event); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestEphemeralHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	natsBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("ephemeral")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, eh.UseEventHandlerMiddleware(handler, ephemeral.NewMiddleware())); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	natsBus.registeredMu.RLock()
+	consumerName := natsBus.registered[handler.HandlerType()].consumerName
+	natsBus.registeredMu.RUnlock()
+
+	info, err := natsBus.js.ConsumerInfo(natsBus.stream.Config.Name, consumerName)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if info.Config.InactiveThreshold != EphemeralInactiveThreshold {
+		t.Error("the inactive threshold should be set:", info.Config.InactiveThreshold)
+	}
+
+	if err := natsBus.RemoveHandler(ctx, handler.HandlerType()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := natsBus.js.ConsumerInfo(natsBus.stream.Config.Name, consumerName); !errors.Is(err, nats.ErrConsumerNotFound) {
+		t.Error("the consumer should be deleted:", err)
+	}
+}
+
+func TestDeliveryPolicy(t *testing.T) {
+	p := DeliveryPolicy{
+		MaxDeliver: 5,
+		BackOff:    []time.Duration{time.Second, 2 * time.Second},
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 2 * time.Second}
+	for i, want := range expected {
+		if got := p.backOff(uint64(i + 1)); got != want {
+			t.Errorf("expected backoff after %d deliveries to be %s, got: %s", i+1, want, got)
+		}
+	}
+
+	if got := (DeliveryPolicy{}).backOff(1); got != 0 {
+		t.Error("there should be no backoff:", got)
+	}
+
+	testCases := map[string]struct {
+		policy      DeliveryPolicy
+		expectError string
+	}{
+		"negative max deliver": {
+			DeliveryPolicy{MaxDeliver: -1},
+			"max deliver must not be negative",
+		},
+		"too many backoff delays": {
+			DeliveryPolicy{MaxDeliver: 2, BackOff: []time.Duration{time.Second, time.Second}},
+			"max deliver must be greater than the number of backoff delays",
+		},
+		"invalid backoff delay": {
+			DeliveryPolicy{MaxDeliver: 2, BackOff: []time.Duration{0}},
+			"backoff delays must be greater than 0",
+		},
+	}
+
+	for desc, tc := range testCases {
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus("nats://localhost:4222", "app", WithDeliveryPolicy(tc.policy))
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+
+			_, err = NewEventBus("nats://localhost:4222", "app", WithHandlerDeliveryPolicy("handler", tc.policy))
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestConsumerSubjects(t *testing.T) {
+	const (
+		a1 = eh.AggregateType("a1")
+		a2 = eh.AggregateType("a2")
+		e1 = eh.EventType("e1")
+		e2 = eh.EventType("e2")
+	)
+
+	testCases := map[string]struct {
+		matcher  eh.EventMatcher
+		subjects []string
+	}{
+		"unknown matcher": {
+			matcherFunc(func(eh.Event) bool { return true }),
+			[]string{"s.*.*"},
+		},
+		"events": {
+			eh.MatchEvents{e1},
+			[]string{"s.*.e1"},
+		},
+		"multiple events": {
+			eh.MatchEvents{e1, e2},
+			[]string{"s.*.e1", "s.*.e2"},
+		},
+		"no events": {
+			eh.MatchEvents{},
+			[]string{"s.*.*"},
+		},
+		"aggregates": {
+			eh.MatchAggregates{a1},
+			[]string{"s.a1.*"},
+		},
+		"multiple aggregates": {
+			eh.MatchAggregates{a1, a2},
+			[]string{"s.a1.*", "s.a2.*"},
+		},
+		"duplicate aggregates": {
+			eh.MatchAggregates{a1, a1},
+			[]string{"s.a1.*"},
+		},
+		"all of aggregates and events": {
+			eh.MatchAll{eh.MatchAggregates{a1}, eh.MatchEvents{e1}},
+			[]string{"s.a1.e1"},
+		},
+		"all of multiple aggregates and events": {
+			eh.MatchAll{eh.MatchAggregates{a1, a2}, eh.MatchEvents{e1, e2}},
+			[]string{"s.a1.e1", "s.a1.e2", "s.a2.e1", "s.a2.e2"},
+		},
+		"all of events": {
+			eh.MatchAll{eh.MatchEvents{e1, e2}, eh.MatchEvents{e2}},
+			[]string{"s.*.e2"},
+		},
+		"all with no possible match": {
+			eh.MatchAll{eh.MatchEvents{e1}, eh.MatchEvents{e2}},
+			[]string{"s.*.*"},
+		},
+		"all with unknown matcher": {
+			eh.MatchAll{eh.MatchEvents{e1}, matcherFunc(func(eh.Event) bool { return true })},
+			[]string{"s.*.e1"},
+		},
+		"empty all": {
+			eh.MatchAll{},
+			[]string{"s.*.*"},
+		},
+		"any of events": {
+			eh.MatchAny{eh.MatchEvents{e1}, eh.MatchEvents{e2}},
+			[]string{"s.*.e1", "s.*.e2"},
+		},
+		"any of covered filters": {
+			eh.MatchAny{eh.MatchAggregates{a1}, eh.MatchAll{eh.MatchAggregates{a1}, eh.MatchEvents{e1}}},
+			[]string{"s.a1.*"},
+		},
+		"any of overlapping filters": {
+			eh.MatchAny{eh.MatchAggregates{a1}, eh.MatchEvents{e1}},
+			[]string{"s.*.*"},
+		},
+		"any of overlapping and other filters": {
+			eh.MatchAny{eh.MatchAggregates{a1, a2}, eh.MatchEvents{e1}},
+			[]string{"s.*.*"},
+		},
+		"any of duplicate filters": {
+			eh.MatchAny{eh.MatchEvents{e1}, eh.MatchEvents{e1, e2}},
+			[]string{"s.*.e1", "s.*.e2"},
+		},
+		"any of overlapping all": {
+			eh.MatchAny{
+				eh.MatchAll{eh.MatchAggregates{a1}, eh.MatchEvents{e1}},
+				eh.MatchEvents{e1},
+			},
+			[]string{"s.*.e1"},
+		},
+		"any with unknown matcher": {
+			eh.MatchAny{eh.MatchEvents{e1}, matcherFunc(func(eh.Event) bool { return true })},
+			[]string{"s.*.*"},
+		},
+		"empty any": {
+			eh.MatchAny{},
+			[]string{"s.*.*"},
+		},
+		"any of all": {
+			eh.MatchAny{
+				eh.MatchAll{eh.MatchAggregates{a1}, eh.MatchEvents{e1}},
+				eh.MatchAll{eh.MatchAggregates{a2}, eh.MatchEvents{e2}},
+			},
+			[]string{"s.a1.e1", "s.a2.e2"},
+		},
+		"all of any": {
+			eh.MatchAll{
+				eh.MatchAny{eh.MatchAggregates{a1}, eh.MatchAggregates{a2}},
+				eh.MatchEvents{e1},
+			},
+			[]string{"s.a1.e1", "s.a2.e1"},
+		},
+	}
+
+	for desc, tc := range testCases {
+		t.Run(desc, func(t *testing.T) {
+			subjects := consumerSubjects("s", tc.matcher)
+			if !equalSubjects(subjects, tc.subjects) {
+				t.Errorf("expected subjects %v, got: %v", tc.subjects, subjects)
+			}
+		})
+	}
+}
+
+// matcherFunc is a matcher which can not be expressed as subjects.
+type matcherFunc func(eh.Event) bool
+
+func (f matcherFunc) Match(e eh.Event) bool {
+	return f(e)
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -74,6 +616,19 @@ func TestEventBusLoadtest(t *testing.T) {
 	eventbus.LoadTest(t, bus)
 }
 
+func TestHandleEventsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestHandleEvents(t, bus, time.Second)
+}
+
 func BenchmarkEventBus(b *testing.B) {
 	bus, appID, err := newTestEventBus("")
 	if err != nil {
@@ -85,7 +640,18 @@ func BenchmarkEventBus(b *testing.B) {
 	eventbus.Benchmark(b, bus)
 }
 
-func newTestEventBus(appID string) (eh.EventBus, string, error) {
+func BenchmarkEventBusBatch(b *testing.B) {
+	bus, appID, err := newTestEventBus("")
+	if err != nil {
+		b.Fatal("there should be no error:", err)
+	}
+
+	b.Logf("using stream: %s_events", appID)
+
+	eventbus.BenchmarkBatch(b, bus, 100)
+}
+
+func newTestEventBus(appID string, options ...Option) (eh.EventBus, string, error) {
 	// Enable testing with Docker, default to local testing.
 	addr := os.Getenv("NATS_ADDR")
 	if addr == "" {
@@ -104,7 +670,7 @@ func newTestEventBus(appID string) (eh.EventBus, string, error) {
 		appID = "app-" + hex.EncodeToString(b)
 	}
 
-	bus, err := NewEventBus(url, appID)
+	bus, err := NewEventBus(url, appID, options...)
 	if err != nil {
 		return nil, "", fmt.Errorf("could not create event bus: %w", err)
 	}
@@ -0,0 +1,41 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+type contextKey int
+
+const (
+	txKey contextKey = iota
+)
+
+// TxFromContext returns the transaction from the context.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey).(*sql.Tx)
+
+	return tx, ok
+}
+
+// NewContextWithTx returns the context with a transaction set. Events published
+// with the context are written in the transaction, which is committed or rolled
+// back by the caller, for example an event store saving events in the same
+// database. Subscribers are notified when the transaction is committed.
+func NewContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey, tx)
+}
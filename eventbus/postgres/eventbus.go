@@ -0,0 +1,785 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+)
+
+// Defaults for the event bus, which can be changed with options.
+var (
+	// DefaultPollInterval is how often handlers check for new events when
+	// not notified, for example if a notification was missed.
+	DefaultPollInterval = 5 * time.Second
+	// DefaultBatchSize is the max number of events handled in one transaction.
+	DefaultBatchSize = 100
+)
+
+// EventBus is an event bus using PostgreSQL. Events are written to a table and
+// subscribers are woken up with NOTIFY when they are committed.
+//
+// Each handler type has a cursor in a table with the position of the last
+// handled event. The cursor is locked while handling, which makes handlers of
+// the same type in different instances compete for events. The cursor is only
+// moved when events have been handled, which makes the delivery at-least-once.
+// Ephemeral handlers keep their cursor in memory and start at the last event.
+// Events that fail are retried according to the retry policy, before skipping
+// them.
+//
+// Publishing is serialized with an advisory lock held until the transaction is
+// committed, to never let a handler move past an event which is not committed
+// yet. Publishing in a long running transaction will block other publishers.
+type EventBus struct {
+	db             *sql.DB
+	ownDB          bool
+	eventsTable    string
+	cursorsTable   string
+	channel        string
+	lockKey        int64
+	listener       *pq.Listener
+	pollInterval   time.Duration
+	batchSize      int
+	retryPolicy    RetryPolicy
+	deleteOnRemove bool
+	registered     map[eh.EventHandlerType]*registration
+	registeredMu   sync.RWMutex
+	errCh          chan error
+	errorHandler   eh.ErrorHandler
+	cctx           context.Context
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+	codec          eh.EventCodec
+}
+
+// NewEventBus creates an EventBus, with optional settings. The tables are
+// created in the database of the DSN if they don't exist.
+func NewEventBus(dsn, appID string, options ...Option) (*EventBus, error) {
+	if appID == "" {
+		return nil, errors.New("missing app ID")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := &EventBus{
+		eventsTable:  pq.QuoteIdentifier(appID + "_events"),
+		cursorsTable: pq.QuoteIdentifier(appID + "_cursors"),
+		channel:      appID + "_events",
+		pollInterval: DefaultPollInterval,
+		batchSize:    DefaultBatchSize,
+		retryPolicy:  DefaultRetryPolicy,
+		registered:   map[eh.EventHandlerType]*registration{},
+		errCh:        make(chan error, 100),
+		cctx:         ctx,
+		cancel:       cancel,
+		codec:        &json.EventCodec{},
+	}
+
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+
+		if err := option(b); err != nil {
+			cancel()
+
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	// Use the same lock for all publishers of the app.
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(b.channel))
+	b.lockKey = int64(hash.Sum64())
+
+	if b.db == nil {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			cancel()
+
+			return nil, fmt.Errorf("could not open database: %w", err)
+		}
+
+		b.db = db
+		b.ownDB = true
+	}
+
+	if err := b.db.PingContext(ctx); err != nil {
+		b.closeOnError()
+
+		return nil, fmt.Errorf("could not check PostgreSQL server: %w", err)
+	}
+
+	if err := b.createTables(ctx); err != nil {
+		b.closeOnError()
+
+		return nil, err
+	}
+
+	// Listen for notifications of new events.
+	b.listener = pq.NewListener(dsn, 100*time.Millisecond, time.Minute, b.listenerEvent)
+	if err := b.listener.Listen(b.channel); err != nil {
+		b.listener.Close()
+		b.closeOnError()
+
+		return nil, fmt.Errorf("could not listen for notifications: %w", err)
+	}
+
+	b.wg.Add(1)
+
+	go b.notify()
+
+	return b, nil
+}
+
+// Releases the context and the database, if opened by the event bus, when the
+// event bus could not be created.
+func (b *EventBus) closeOnError() {
+	b.cancel()
+
+	if b.ownDB {
+		b.db.Close()
+	}
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventBus) error
+
+// WithCodec uses the specified codec for encoding events.
+func WithCodec(codec eh.EventCodec) Option {
+	return func(b *EventBus) error {
+		b.codec = codec
+
+		return nil
+	}
+}
+
+// WithDB uses the database for the tables, instead of opening one with the
+// DSN. The database is not closed when the event bus is closed. The DSN is
+// still used to listen for notifications.
+func WithDB(db *sql.DB) Option {
+	return func(b *EventBus) error {
+		if db == nil {
+			return errors.New("missing database")
+		}
+
+		b.db = db
+
+		return nil
+	}
+}
+
+// WithPollInterval sets how often handlers check for new events when not
+// notified, instead of the DefaultPollInterval.
+func WithPollInterval(interval time.Duration) Option {
+	return func(b *EventBus) error {
+		if interval <= 0 {
+			return errors.New("poll interval must be positive")
+		}
+
+		b.pollInterval = interval
+
+		return nil
+	}
+}
+
+// WithBatchSize sets the max number of events handled in one transaction,
+// instead of the DefaultBatchSize.
+func WithBatchSize(size int) Option {
+	return func(b *EventBus) error {
+		if size <= 0 {
+			return errors.New("batch size must be positive")
+		}
+
+		b.batchSize = size
+
+		return nil
+	}
+}
+
+// RetryPolicy is the policy for retrying handling of an event that failed.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts to handle an event, after which
+	// it is skipped. Zero means retrying forever.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, which is doubled for
+	// each failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the max delay between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the default retry policy, which retries an event 10
+// times, with a backoff from one second up to one minute.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+// Returns the delay before retrying after the failed attempt, starting at 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return d
+}
+
+// WithRetryPolicy sets the policy used to retry handling of failed events.
+// Handlers are not woken up by notifications while waiting to retry. The
+// attempts are counted per instance, other instances with the same handler
+// type count their own attempts.
+//
+// Defaults to: DefaultRetryPolicy
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(b *EventBus) error {
+		if p.MaxAttempts < 0 {
+			return errors.New("max attempts must not be negative")
+		}
+
+		if p.InitialBackoff <= 0 {
+			return errors.New("initial backoff must be greater than 0")
+		}
+
+		if p.MaxBackoff < p.InitialBackoff {
+			return errors.New("max backoff must not be less than initial backoff")
+		}
+
+		b.retryPolicy = p
+
+		return nil
+	}
+}
+
+// WithDeleteCursorOnRemove deletes the cursor of a handler when it is removed
+// from the event bus. By default the cursor is kept so that the handler can
+// continue where it left off when added again. Cursors of ephemeral handlers
+// are never stored.
+func WithDeleteCursorOnRemove() Option {
+	return func(b *EventBus) error {
+		b.deleteOnRemove = true
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
+// Creates the tables for events and cursors.
+func (b *EventBus) createTables(ctx context.Context) error {
+	if _, err := b.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+b.eventsTable+` (
+		position BIGSERIAL PRIMARY KEY,
+		aggregate_type TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		data BYTEA NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("could not create events table: %w", err)
+	}
+
+	if _, err := b.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+b.cursorsTable+` (
+		handler_type TEXT PRIMARY KEY,
+		position BIGINT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("could not create cursors table: %w", err)
+	}
+
+	return nil
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (b *EventBus) HandlerType() eh.EventHandlerType {
+	return "eventbus"
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
+// The event is written in the transaction of the context if set with
+// NewContextWithTx, otherwise in its own transaction.
+func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	return b.HandleEvents(ctx, []eh.Event{event})
+}
+
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. The events are written in one
+// transaction, the same way as in HandleEvent.
+func (b *EventBus) HandleEvents(ctx context.Context, events []eh.Event) error {
+	if tx, ok := TxFromContext(ctx); ok {
+		return b.publish(ctx, tx, events)
+	}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	defer tx.Rollback() // No-op after commit.
+
+	if err := b.publish(ctx, tx, events); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit events: %w", err)
+	}
+
+	return nil
+}
+
+func (b *EventBus) publish(ctx context.Context, tx *sql.Tx, events []eh.Event) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, b.lockKey); err != nil {
+		return fmt.Errorf("could not lock events table: %w", err)
+	}
+
+	for _, event := range events {
+		data, err := b.codec.MarshalEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("could not marshal event: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO `+b.eventsTable+` (aggregate_type, event_type, data) VALUES ($1, $2, $3)`,
+			event.AggregateType().String(), event.EventType().String(), data,
+		); err != nil {
+			return fmt.Errorf("could not publish event: %w", err)
+		}
+	}
+
+	// The notification is sent when the transaction is committed.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, '')`, b.channel); err != nil {
+		return fmt.Errorf("could not notify subscribers: %w", err)
+	}
+
+	return nil
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
+func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
+	if m == nil {
+		return eh.ErrMissingMatcher
+	}
+
+	if h == nil {
+		return eh.ErrMissingHandler
+	}
+
+	// Check handler existence.
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	if _, ok := b.registered[h.HandlerType()]; ok {
+		return eh.ErrHandlerAlreadyAdded
+	}
+
+	// Ephemeral handlers keep their cursor in memory, starting at the last
+	// event, other handlers get a cursor at the last event if new.
+	isEphemeral := ephemeral.IsEphemeral(h)
+
+	var position int64
+
+	if isEphemeral {
+		if err := b.db.QueryRowContext(ctx,
+			`SELECT COALESCE(MAX(position), 0) FROM `+b.eventsTable,
+		).Scan(&position); err != nil {
+			return fmt.Errorf("could not get last position: %w", err)
+		}
+	} else if _, err := b.db.ExecContext(ctx,
+		`INSERT INTO `+b.cursorsTable+` (handler_type, position)
+		SELECT $1, COALESCE(MAX(position), 0) FROM `+b.eventsTable+`
+		ON CONFLICT (handler_type) DO NOTHING`,
+		h.HandlerType().String(),
+	); err != nil {
+		return fmt.Errorf("could not create cursor: %w", err)
+	}
+
+	// Register handler.
+	hctx, cancel := context.WithCancel(b.cctx)
+	r := &registration{
+		ephemeral: isEphemeral,
+		position:  position,
+		wake:      make(chan struct{}, 1),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	b.registered[h.HandlerType()] = r
+
+	b.wg.Add(1)
+
+	// Handle until context is cancelled.
+	go b.handle(hctx, m, h, r)
+
+	return nil
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	r, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop handling and wait for any ongoing handling to finish.
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !b.deleteOnRemove || r.ephemeral {
+		return nil
+	}
+
+	if _, err := b.db.ExecContext(ctx,
+		`DELETE FROM `+b.cursorsTable+` WHERE handler_type = $1`, handlerType.String(),
+	); err != nil {
+		return fmt.Errorf("could not delete cursor: %w", err)
+	}
+
+	return nil
+}
+
+// Errors implements the Errors method of the eventhorizon.EventBus interface.
+func (b *EventBus) Errors() <-chan error {
+	return b.errCh
+}
+
+// Close implements the Close method of the eventhorizon.EventBus interface.
+func (b *EventBus) Close() error {
+	b.cancel()
+	b.wg.Wait()
+
+	if err := b.listener.Close(); err != nil {
+		return fmt.Errorf("could not close listener: %w", err)
+	}
+
+	if b.ownDB {
+		return b.db.Close()
+	}
+
+	return nil
+}
+
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	ephemeral bool
+	position  int64 // Only used for ephemeral handlers.
+	wake      chan struct{}
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	// The failed event being retried, only used by the handling goroutine.
+	failedPosition int64
+	attempts       int
+	retryAt        time.Time
+}
+
+// Wakes up all handlers when notified of new events.
+func (b *EventBus) notify() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-b.cctx.Done():
+			return
+		case <-b.listener.Notify:
+			// A nil notification is sent after reconnecting, when notifications
+			// could have been missed, which also wakes up all handlers.
+		}
+
+		b.registeredMu.RLock()
+		for _, r := range b.registered {
+			select {
+			case r.wake <- struct{}{}:
+			default:
+			}
+		}
+		b.registeredMu.RUnlock()
+	}
+}
+
+func (b *EventBus) listenerEvent(event pq.ListenerEventType, err error) {
+	if err != nil {
+		b.sendError(&eh.EventBusError{Err: fmt.Errorf("listener error: %w", err), Retryable: true})
+	}
+}
+
+// Handles new events until the context is cancelled.
+func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, r *registration) {
+	defer b.wg.Done()
+	defer close(r.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+			// Wait for the backoff before retrying a failed event.
+			if time.Now().Before(r.retryAt) {
+				continue
+			}
+		case <-timer.C:
+		}
+
+		// Handle batches until there are no more events, or a failed event
+		// should be retried later.
+		for {
+			more, err := b.handleBatch(ctx, m, h, r)
+			if ctx.Err() != nil {
+				return
+			} else if err != nil {
+				b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
+			}
+
+			if !more {
+				break
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		if d := time.Until(r.retryAt); d > 0 {
+			timer.Reset(d)
+		} else {
+			timer.Reset(b.pollInterval)
+		}
+	}
+}
+
+// Handles a batch of events after the cursor, and moves the cursor. Returns
+// true if there could be more events to handle.
+func (b *EventBus) handleBatch(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, r *registration) (bool, error) {
+	if r.ephemeral {
+		events, err := b.loadEvents(ctx, b.db, r.position)
+		if err != nil {
+			return false, err
+		}
+
+		position, ok := b.handleEvents(ctx, m, h, r, r.position, events)
+		r.position = position
+
+		return ok && len(events) == b.batchSize, nil
+	}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	defer tx.Rollback() // No-op after commit.
+
+	// Lock the cursor, skip if it is locked by another instance.
+	var cursor int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT position FROM `+b.cursorsTable+` WHERE handler_type = $1 FOR UPDATE SKIP LOCKED`,
+		h.HandlerType().String(),
+	).Scan(&cursor); errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not lock cursor: %w", err)
+	}
+
+	events, err := b.loadEvents(ctx, tx, cursor)
+	if err != nil {
+		return false, err
+	}
+
+	position, ok := b.handleEvents(ctx, m, h, r, cursor, events)
+	if position > cursor {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE `+b.cursorsTable+` SET position = $1, updated_at = now() WHERE handler_type = $2`,
+			position, h.HandlerType().String(),
+		); err != nil {
+			return false, fmt.Errorf("could not update cursor: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("could not commit cursor: %w", err)
+	}
+
+	return ok && len(events) == b.batchSize, nil
+}
+
+// storedEvent is an encoded event at a position.
+type storedEvent struct {
+	position int64
+	data     []byte
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Loads a batch of events after the position.
+func (b *EventBus) loadEvents(ctx context.Context, q querier, position int64) ([]storedEvent, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT position, data FROM `+b.eventsTable+` WHERE position > $1 ORDER BY position LIMIT $2`,
+		position, b.batchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not load events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []storedEvent
+
+	for rows.Next() {
+		var e storedEvent
+		if err := rows.Scan(&e.position, &e.data); err != nil {
+			return nil, fmt.Errorf("could not scan event: %w", err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not load events: %w", err)
+	}
+
+	return events, nil
+}
+
+// Handles the matching events in order, and returns the position of the last
+// handled event, or the start position if none. Stops at the first event that
+// could not be handled, which is retried after the backoff of the retry policy,
+// and returns false. Events are skipped after the max attempts.
+func (b *EventBus) handleEvents(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, r *registration, position int64, events []storedEvent) (int64, bool) {
+	for _, e := range events {
+		event, ectx, err := b.codec.UnmarshalEvent(ctx, e.data)
+		if err != nil {
+			// The event can never be handled, skip it.
+			b.sendError(&eh.EventBusError{
+				Err:         fmt.Errorf("could not unmarshal event: %w", err),
+				Ctx:         ctx,
+				HandlerType: h.HandlerType(),
+			})
+
+			position = e.position
+
+			continue
+		}
+
+		// Handle the event if it did match.
+		if m.Match(event) {
+			if err := h.HandleEvent(ectx, event); err != nil {
+				if r.failedPosition != e.position {
+					r.failedPosition = e.position
+					r.attempts = 0
+				}
+
+				r.attempts++
+
+				retry := b.retryPolicy.MaxAttempts == 0 || r.attempts < b.retryPolicy.MaxAttempts
+
+				b.sendError(&eh.EventBusError{
+					Err: fmt.Errorf("could not handle event (%s), attempt %d: %w",
+						h.HandlerType(), r.attempts, err),
+					Ctx:         ectx,
+					Event:       event,
+					HandlerType: h.HandlerType(),
+					Retryable:   retry,
+				})
+
+				if retry {
+					r.retryAt = time.Now().Add(b.retryPolicy.backoff(r.attempts))
+
+					return position, false
+				}
+
+				b.sendError(&eh.EventBusError{
+					Err: fmt.Errorf("skipping event (%s) after %d attempts",
+						h.HandlerType(), r.attempts),
+					Ctx:         ectx,
+					Event:       event,
+					HandlerType: h.HandlerType(),
+				})
+			}
+		}
+
+		// Clear any retry state of the handled or skipped event.
+		if r.failedPosition == e.position {
+			r.failedPosition = 0
+			r.attempts = 0
+			r.retryAt = time.Time{}
+		}
+
+		position = e.position
+	}
+
+	return position, true
+}
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in PostgreSQL event bus: %s", err)
+		}
+	}
+}
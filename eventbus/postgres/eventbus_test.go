@@ -0,0 +1,402 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+func TestAddHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestAddHandler(t, bus)
+}
+
+func TestRemoveHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestRemoveHandler(t, bus, time.Second)
+}
+
+func TestEventBusIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus1, appID, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	bus2, _, err := newTestEventBus(appID)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Logf("using tables: %s_events, %s_cursors", appID, appID)
+
+	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
+}
+
+func TestSharedTransactionIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("handler")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Events in a rolled back transaction should not be handled.
+	tx, err := bus.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(NewContextWithTx(ctx, tx), newTestEvent("rolled back")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Events in a committed transaction should be handled after the commit.
+	tx, err = bus.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent("committed")
+	if err := bus.HandleEvent(NewContextWithTx(ctx, tx), event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if handler.Wait(100 * time.Millisecond) {
+		t.Error("the event should not be handled before the commit")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	if !eh.CompareEventSlices(handler.Events, []eh.Event{event}) {
+		t.Error("only the committed event should be handled:", handler.Events)
+	}
+}
+
+func TestRetryIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, appID, err := newTestEventBus("", WithPollInterval(100*time.Millisecond))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("handler")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent("event")
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	select {
+	case err := <-bus.Errors():
+		if !errors.Is(err, handler.Err) {
+			t.Error("the error should be correct:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("there should be an error")
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// The failed event should be handled again by another instance.
+	bus2, _, err := newTestEventBus(appID, WithPollInterval(100*time.Millisecond))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus2.Close()
+
+	handler2 := mocks.NewEventHandler("handler")
+	if err := bus2.AddHandler(ctx, eh.MatchAll{}, handler2); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler2.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	if !eh.CompareEventSlices(handler2.Events, []eh.Event{event}) {
+		t.Error("the event should be handled again:", handler2.Events)
+	}
+}
+
+func TestRetryPolicyIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("", WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     200 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	ctx := context.Background()
+
+	// Fail only the poison event.
+	handlerErr := errors.New("handler error")
+	handler := mocks.NewEventHandler("handler")
+	poisonHandler := eh.EventHandlerFunc(func(ctx context.Context, event eh.Event) error {
+		if data, ok := event.Data().(*mocks.EventData); ok && data.Content == "poison" {
+			return handlerErr
+		}
+
+		return handler.HandleEvent(ctx, event)
+	})
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, poisonHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(ctx, newTestEvent("poison")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Notifications during the backoff should not retry the event.
+	if err := bus.HandleEvent(ctx, newTestEvent("other")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	var last time.Time
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case err := <-bus.Errors():
+			var busErr *eh.EventBusError
+			if !errors.As(err, &busErr) || !errors.Is(err, handlerErr) {
+				t.Fatal("the error should be correct:", err)
+			}
+
+			if busErr.Retryable != (i < 3) {
+				t.Error("the error should be retryable before the last attempt:", err)
+			}
+
+			if i > 1 && time.Since(last) < 150*time.Millisecond {
+				t.Error("the event should be retried after the backoff")
+			}
+
+			last = time.Now()
+		case <-time.After(time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	// The event should be skipped after the last attempt.
+	select {
+	case err := <-bus.Errors():
+		var busErr *eh.EventBusError
+		if !errors.As(err, &busErr) || busErr.Retryable {
+			t.Error("the error should not be retryable:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("there should be an error")
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	handler.Lock()
+	if len(handler.Events) != 1 || handler.Events[0].Data().(*mocks.EventData).Content != "other" {
+		t.Error("only the other event should be handled:", handler.Events)
+	}
+	handler.Unlock()
+}
+
+func TestOptions(t *testing.T) {
+	if _, err := NewEventBus("", ""); err == nil || err.Error() != "missing app ID" {
+		t.Error("the error should be correct:", err)
+	}
+
+	testCases := map[string]struct {
+		option      Option
+		expectError string
+	}{
+		"missing database": {
+			WithDB(nil),
+			"missing database",
+		},
+		"invalid poll interval": {
+			WithPollInterval(0),
+			"poll interval must be positive",
+		},
+		"invalid batch size": {
+			WithBatchSize(-1),
+			"batch size must be positive",
+		},
+		"invalid max attempts": {
+			WithRetryPolicy(RetryPolicy{MaxAttempts: -1, InitialBackoff: time.Second, MaxBackoff: time.Second}),
+			"max attempts must not be negative",
+		},
+		"invalid initial backoff": {
+			WithRetryPolicy(RetryPolicy{MaxBackoff: time.Second}),
+			"initial backoff must be greater than 0",
+		},
+		"invalid max backoff": {
+			WithRetryPolicy(RetryPolicy{InitialBackoff: time.Second}),
+			"max backoff must not be less than initial backoff",
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus("", "app", tc.option)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestHandleEventsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestHandleEvents(t, bus, time.Second)
+}
+
+func BenchmarkEventBus(b *testing.B) {
+	bus, appID, err := newTestEventBus("")
+	if err != nil {
+		b.Fatal("there should be no error:", err)
+	}
+
+	b.Logf("using tables: %s_events, %s_cursors", appID, appID)
+
+	eventbus.Benchmark(b, bus)
+}
+
+func BenchmarkEventBusBatch(b *testing.B) {
+	bus, appID, err := newTestEventBus("")
+	if err != nil {
+		b.Fatal("there should be no error:", err)
+	}
+
+	b.Logf("using tables: %s_events, %s_cursors", appID, appID)
+
+	eventbus.BenchmarkBatch(b, bus, 100)
+}
+
+func newTestEventBus(appID string, options ...Option) (*EventBus, string, error) {
+	// Connect to localhost if not running inside docker
+	addr := os.Getenv("POSTGRES_ADDR")
+	if addr == "" {
+		addr = "localhost:5432"
+	}
+
+	dsn := fmt.Sprintf("postgres://postgres:password@%s/postgres?sslmode=disable", addr)
+
+	// Get a random app ID.
+	if appID == "" {
+		bts := make([]byte, 8)
+		if _, err := rand.Read(bts); err != nil {
+			return nil, "", fmt.Errorf("could not randomize app ID: %w", err)
+		}
+
+		appID = "app_" + hex.EncodeToString(bts)
+	}
+
+	bus, err := NewEventBus(dsn, appID, options...)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create event bus: %w", err)
+	}
+
+	return bus, appID, nil
+}
+
+func newTestEvent(content string) eh.Event {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	return eh.NewEvent(mocks.EventType, &mocks.EventData{Content: content}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+}
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,23 +28,31 @@ import (
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 // EventBus is a local event bus that delegates handling of published events
 // to all matching registered handlers, in order of registration.
 type EventBus struct {
-	appID        string
-	clientID     string
-	streamName   string
-	client       *redis.Client
-	clientOpts   *redis.Options
-	registered   map[eh.EventHandlerType]struct{}
-	registeredMu sync.RWMutex
-	errCh        chan error
-	cctx         context.Context
-	cancel       context.CancelFunc
-	wg           sync.WaitGroup
-	codec        eh.EventCodec
+	appID            string
+	clientID         string
+	streamName       string
+	client           *redis.Client
+	clientOpts       *redis.Options
+	deleteOnRemove   bool
+	reclaimPolicy    ReclaimPolicy
+	deadLetterStream string
+	maxLen           int64
+	minIDAge         time.Duration
+	registered       map[eh.EventHandlerType]*registration
+	registeredMu     sync.RWMutex
+	errCh            chan error
+	errorHandler     eh.ErrorHandler
+	cctx             context.Context
+	cancel           context.CancelFunc
+	wg               sync.WaitGroup
+	codec            eh.EventCodec
 }
 
 // NewEventBus creates an EventBus, with optional settings.
@@ -54,7 +63,7 @@ func NewEventBus(addr, appID, clientID string, options ...Option) (*EventBus, er
 		appID:      appID,
 		clientID:   clientID,
 		streamName: appID + "_events",
-		registered: map[eh.EventHandlerType]struct{}{},
+		registered: map[eh.EventHandlerType]*registration{},
 		errCh:      make(chan error, 100),
 		cctx:       ctx,
 		cancel:     cancel,
@@ -72,6 +81,10 @@ func NewEventBus(addr, appID, clientID string, options ...Option) (*EventBus, er
 		}
 	}
 
+	if b.maxLen > 0 && b.minIDAge > 0 {
+		return nil, errors.New("max length and min ID age can not be combined")
+	}
+
 	// Default client options.
 	if b.clientOpts == nil {
 		b.clientOpts = &redis.Options{
@@ -109,6 +122,62 @@ func WithRedisOptions(opts *redis.Options) Option {
 	}
 }
 
+// WithDeleteGroupOnRemove deletes the consumer group of a handler when it is
+// removed with RemoveHandler, which also removes it for other instances of the
+// app together with any pending messages. Consumer groups for ephemeral
+// handlers are always deleted.
+func WithDeleteGroupOnRemove() Option {
+	return func(b *EventBus) error {
+		b.deleteOnRemove = true
+
+		return nil
+	}
+}
+
+// WithMaxLen trims the stream to approximately the max length when publishing.
+// Trimmed messages are removed even if they have not been handled by all
+// consumer groups.
+func WithMaxLen(n int64) Option {
+	return func(b *EventBus) error {
+		if n <= 0 {
+			return errors.New("max length must be positive")
+		}
+
+		b.maxLen = n
+
+		return nil
+	}
+}
+
+// WithMinIDAge trims messages older than approximately the age from the stream
+// when publishing. Trimmed messages are removed even if they have not been
+// handled by all consumer groups.
+func WithMinIDAge(age time.Duration) Option {
+	return func(b *EventBus) error {
+		if age <= 0 {
+			return errors.New("min ID age must be positive")
+		}
+
+		b.minIDAge = age
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
 // HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandlerType() eh.EventHandlerType {
 	return "eventbus"
@@ -122,9 +191,45 @@ const (
 
 // HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
 func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	args, err := b.addArgs(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.client.XAdd(ctx, args).Result(); err != nil {
+		return fmt.Errorf("could not publish event: %w", err)
+	}
+
+	return nil
+}
+
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. The events are published in one
+// pipeline.
+func (b *EventBus) HandleEvents(ctx context.Context, events []eh.Event) error {
+	pipe := b.client.Pipeline()
+
+	for _, event := range events {
+		args, err := b.addArgs(ctx, event)
+		if err != nil {
+			return err
+		}
+
+		pipe.XAdd(ctx, args)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("could not publish events: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the arguments to publish an event to the stream.
+func (b *EventBus) addArgs(ctx context.Context, event eh.Event) (*redis.XAddArgs, error) {
 	data, err := b.codec.MarshalEvent(ctx, event)
 	if err != nil {
-		return fmt.Errorf("could not marshal event: %w", err)
+		return nil, fmt.Errorf("could not marshal event: %w", err)
 	}
 
 	args := &redis.XAddArgs{
@@ -135,11 +240,17 @@ func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
 			dataKey:          data,
 		},
 	}
-	if _, err := b.client.XAdd(ctx, args).Result(); err != nil {
-		return fmt.Errorf("could not publish event: %w", err)
+
+	// Trim the stream, approximately for efficiency.
+	if b.maxLen > 0 {
+		args.MaxLen = b.maxLen
+		args.Approx = true
+	} else if b.minIDAge > 0 {
+		args.MinID = strconv.FormatInt(time.Now().Add(-b.minIDAge).UnixMilli(), 10)
+		args.Approx = true
 	}
 
-	return nil
+	return args, nil
 }
 
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
@@ -160,9 +271,15 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 		return eh.ErrHandlerAlreadyAdded
 	}
 
-	// Get or create the subscription.
+	// Get or create the subscription. Ephemeral handlers get a consumer group
+	// per instance, so that every instance receives all events.
 	// TODO: Filter subscription.
-	groupName := fmt.Sprintf("%s_%s", b.appID, h.HandlerType())
+	isEphemeral := ephemeral.IsEphemeral(h)
+
+	groupName := b.groupName(h.HandlerType())
+	if isEphemeral {
+		groupName += "_" + uuid.New().String()
+	}
 
 	res, err := b.client.XGroupCreateMkStream(ctx, b.streamName, groupName, "$").Result()
 	if err != nil {
@@ -175,50 +292,177 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 	}
 
 	// Register handler.
-	b.registered[h.HandlerType()] = struct{}{}
+	hctx, cancel := context.WithCancel(b.cctx)
+	r := &registration{
+		groupName: groupName,
+		ephemeral: isEphemeral,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	b.registered[h.HandlerType()] = r
 
 	b.wg.Add(1)
 
 	// Handle until context is cancelled.
-	go b.handle(m, h, groupName)
+	go b.handle(hctx, m, h, groupName, isEphemeral, r.done)
 
 	return nil
 }
 
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	r, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop handling and wait for any ongoing handling to finish.
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !b.deleteOnRemove && !r.ephemeral {
+		return nil
+	}
+
+	if _, err := b.client.XGroupDestroy(ctx, b.streamName, r.groupName).Result(); err != nil {
+		return fmt.Errorf("could not delete consumer group: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the consumer group name for a handler type.
+func (b *EventBus) groupName(handlerType eh.EventHandlerType) string {
+	return fmt.Sprintf("%s_%s", b.appID, handlerType)
+}
+
 // Errors implements the Errors method of the eventhorizon.EventBus interface.
 func (b *EventBus) Errors() <-chan error {
 	return b.errCh
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (b *EventBus) HealthCheckName() string {
+	return "redis eventbus"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by pinging the Redis server, and checking that the consumers of all
+// added handlers are running.
+func (b *EventBus) Check(ctx context.Context) error {
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("could not ping Redis: %w", err)
+	}
+
+	return b.checkConsumers()
+}
+
+// Returns an error if the consumer of an added handler has stopped.
+func (b *EventBus) checkConsumers() error {
+	b.registeredMu.RLock()
+	defer b.registeredMu.RUnlock()
+
+	for handlerType, r := range b.registered {
+		select {
+		case <-r.done:
+			return fmt.Errorf("consumer for handler %s has stopped", handlerType)
+		default:
+		}
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.EventBus interface.
 func (b *EventBus) Close() error {
 	b.cancel()
 	b.wg.Wait()
 
+	// Remove the consumer groups of any ephemeral handlers.
+	b.registeredMu.RLock()
+	for _, r := range b.registered {
+		if r.ephemeral {
+			if err := b.client.XGroupDestroy(context.Background(), b.streamName, r.groupName).Err(); err != nil {
+				log.Printf("eventhorizon: could not delete ephemeral consumer group in Redis event bus: %s", err)
+			}
+		}
+	}
+	b.registeredMu.RUnlock()
+
 	return b.client.Close()
 }
 
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	groupName string
+	ephemeral bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
 // Handles all events coming in on the channel.
-func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, groupName string) {
+func (b *EventBus) handle(ctx context.Context, m eh.EventMatcher, h eh.EventHandler, groupName string, ephemeral bool, done chan struct{}) {
 	defer b.wg.Done()
+	defer close(done)
+
+	// Failed messages are left pending, and only retried when reclaimed.
+	retryable := b.reclaimPolicy.Interval > 0 && !ephemeral
+
+	handler := b.handler(m, h, groupName, retryable)
+	consumer := groupName + "_" + b.clientID
+
+	// Reclaim pending messages in the background, serialized with the
+	// handling of new messages. Ephemeral handlers don't keep any pending
+	// messages to reclaim.
+	if b.reclaimPolicy.Interval > 0 && !ephemeral {
+		var (
+			handleMu  sync.Mutex
+			reclaimWg sync.WaitGroup
+			handle    = handler
+		)
+
+		handler = func(ctx context.Context, msg *redis.XMessage) {
+			handleMu.Lock()
+			defer handleMu.Unlock()
+
+			handle(ctx, msg)
+		}
+
+		reclaimWg.Add(1)
 
-	handler := b.handler(m, h, groupName)
+		go func() {
+			defer reclaimWg.Done()
+
+			b.reclaim(ctx, h, handler, groupName, consumer)
+		}()
+
+		defer reclaimWg.Wait()
+	}
 
 	for {
-		streams, err := b.client.XReadGroup(b.cctx, &redis.XReadGroupArgs{
+		streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    groupName,
-			Consumer: groupName + "_" + b.clientID,
+			Consumer: consumer,
 			Streams:  []string{b.streamName, ">"},
+			NoAck:    ephemeral,
 		}).Result()
 		if errors.Is(err, context.Canceled) {
 			break
 		} else if err != nil {
 			err = fmt.Errorf("could not receive: %w", err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err}:
-			default:
-				log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
 
 			// Retry the receive loop if there was an error.
 			time.Sleep(time.Second)
@@ -233,22 +477,18 @@ func (b *EventBus) handle(m eh.EventMatcher, h eh.EventHandler, groupName string
 			}
 
 			for _, msg := range stream.Messages {
-				handler(b.cctx, &msg)
+				handler(ctx, &msg)
 			}
 		}
 	}
 }
 
-func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, groupName string) func(ctx context.Context, msg *redis.XMessage) {
+func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, groupName string, retryable bool) func(ctx context.Context, msg *redis.XMessage) {
 	return func(ctx context.Context, msg *redis.XMessage) {
 		data, ok := msg.Values[dataKey].(string)
 		if !ok {
 			err := fmt.Errorf("event data is of incorrect type %T", msg.Values[dataKey])
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx}:
-			default:
-				log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, HandlerType: h.HandlerType(), Retryable: retryable})
 
 			// TODO: Nack if possible.
 			return
@@ -257,11 +497,7 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, groupName strin
 		event, ctx, err := b.codec.UnmarshalEvent(ctx, []byte(data))
 		if err != nil {
 			err = fmt.Errorf("could not unmarshal event: %w", err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx}:
-			default:
-				log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, HandlerType: h.HandlerType(), Retryable: retryable})
 
 			// TODO: Nack if possible.
 			return
@@ -271,11 +507,13 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, groupName strin
 		if !m.Match(event) {
 			if _, err := b.client.XAck(ctx, b.streamName, groupName, msg.ID).Result(); err != nil {
 				err = fmt.Errorf("could not ack non-matching event: %w", err)
-				select {
-				case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
-				default:
-					log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
-				}
+				b.sendError(&eh.EventBusError{
+					Err:         err,
+					Ctx:         ctx,
+					Event:       event,
+					HandlerType: h.HandlerType(),
+					Retryable:   retryable,
+				})
 			}
 
 			return
@@ -284,11 +522,13 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, groupName strin
 		// Handle the event if it did match.
 		if err := h.HandleEvent(ctx, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", h.HandlerType(), err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
-			default:
-				log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{
+				Err:         err,
+				Ctx:         ctx,
+				Event:       event,
+				HandlerType: h.HandlerType(),
+				Retryable:   retryable,
+			})
 
 			// TODO: Nack if possible.
 			return
@@ -297,11 +537,28 @@ func (b *EventBus) handler(m eh.EventMatcher, h eh.EventHandler, groupName strin
 		_, err = b.client.XAck(ctx, b.streamName, groupName, msg.ID).Result()
 		if err != nil {
 			err = fmt.Errorf("could not ack handled event: %w", err)
-			select {
-			case b.errCh <- &eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
-			default:
-				log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
-			}
+			b.sendError(&eh.EventBusError{
+				Err:         err,
+				Ctx:         ctx,
+				Event:       event,
+				HandlerType: h.HandlerType(),
+				Retryable:   retryable,
+			})
+		}
+	}
+}
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in Redis event bus: %s", err)
 		}
 	}
 }
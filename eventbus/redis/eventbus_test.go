@@ -15,10 +15,13 @@
 package redis
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,6 +29,9 @@ import (
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/ephemeral"
+	"github.com/reidlai/eventhorizon/mocks"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 func TestAddHandlerIntegration(t *testing.T) {
@@ -40,6 +46,20 @@ func TestAddHandlerIntegration(t *testing.T) {
 
 	eventbus.TestAddHandler(t, bus1)
 }
+
+func TestRemoveHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestRemoveHandler(t, bus, time.Second)
+}
+
 func TestEventBusIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -75,6 +95,19 @@ func TestEventBusLoadtest(t *testing.T) {
 	eventbus.LoadTest(t, bus)
 }
 
+func TestHandleEventsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	eventbus.TestHandleEvents(t, bus, time.Second)
+}
+
 func BenchmarkEventBus(b *testing.B) {
 	bus, appID, err := newTestEventBus("")
 	if err != nil {
@@ -86,7 +119,319 @@ func BenchmarkEventBus(b *testing.B) {
 	eventbus.Benchmark(b, bus)
 }
 
-func newTestEventBus(appID string) (eh.EventBus, string, error) {
+func BenchmarkEventBusBatch(b *testing.B) {
+	bus, appID, err := newTestEventBus("")
+	if err != nil {
+		b.Fatal("there should be no error:", err)
+	}
+
+	b.Logf("using stream: %s_events", appID)
+
+	eventbus.BenchmarkBatch(b, bus, 100)
+}
+
+func TestReclaimIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("",
+		WithReclaimPolicy(ReclaimPolicy{
+			Interval:      50 * time.Millisecond,
+			MinIdle:       100 * time.Millisecond,
+			MaxDeliveries: 3,
+		}),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	redisBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("handler")
+	groupName := redisBus.groupName(handler.HandlerType())
+
+	// Read the message as a consumer which crashes before acking it, before
+	// the handler is added.
+	if err := redisBus.client.XGroupCreateMkStream(ctx, redisBus.streamName, groupName, "$").Err(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent("event1")
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := redisBus.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    groupName,
+		Consumer: groupName + "_crashed",
+		Streams:  []string{redisBus.streamName, ">"},
+		Count:    1,
+		Block:    -1,
+	}).Result(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Fatal("the pending message should be reclaimed")
+	}
+
+	if len(handler.Events) != 1 || handler.Events[0].EventType() != event.EventType() {
+		t.Error("the event should be correct:", handler.Events)
+	}
+
+	pending, err := redisBus.client.XPending(ctx, redisBus.streamName, groupName).Result()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if pending.Count != 0 {
+		t.Error("there should be no pending messages:", pending.Count)
+	}
+}
+
+func TestReclaimDeadLetterIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// Get a random app ID.
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	appID := "app-" + hex.EncodeToString(b)
+	deadLetterStream := appID + "_dead_letters"
+
+	bus, _, err := newTestEventBus(appID,
+		WithReclaimPolicy(ReclaimPolicy{
+			Interval:      50 * time.Millisecond,
+			MinIdle:       100 * time.Millisecond,
+			MaxDeliveries: 3,
+		}),
+		WithDeadLetterStream(deadLetterStream),
+	)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	redisBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("failing")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(ctx, newTestEvent("event1")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// All deliveries should fail before dead lettering.
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-bus.Errors():
+			if !errors.Is(err, handler.Err) {
+				t.Error("the error should be correct:", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	var msgs []redis.XMessage
+
+	for i := 0; i < 10; i++ {
+		if msgs, err = redisBus.client.XRange(ctx, deadLetterStream, "-", "+").Result(); err == nil && len(msgs) > 0 {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(msgs) != 1 {
+		t.Fatal("there should be a dead letter:", msgs, err)
+	}
+
+	if msgs[0].Values[deadLetterDeliveriesKey] != "3" {
+		t.Error("the number of deliveries should be correct:", msgs[0].Values)
+	}
+
+	if msgs[0].Values[deadLetterHandlerTypeKey] != handler.HandlerType().String() {
+		t.Error("the handler type should be correct:", msgs[0].Values)
+	}
+
+	if msgs[0].Values[dataKey] == nil {
+		t.Error("the event data should be kept:", msgs[0].Values)
+	}
+
+	pending, err := redisBus.client.XPending(ctx, redisBus.streamName, redisBus.groupName(handler.HandlerType())).Result()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if pending.Count != 0 {
+		t.Error("there should be no pending messages:", pending.Count)
+	}
+}
+
+func TestMaxLenIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("", WithMaxLen(10))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	redisBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	for i := 0; i < 1000; i++ {
+		if err := bus.HandleEvent(ctx, newTestEvent(fmt.Sprintf("event%d", i))); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+
+	// Trimming is approximate, but whole nodes of the stream should be removed.
+	n, err := redisBus.client.XLen(ctx, redisBus.streamName).Result()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if n >= 1000 {
+		t.Error("the stream should be trimmed:", n)
+	}
+}
+
+func TestEphemeralHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	bus, _, err := newTestEventBus("")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus.Close()
+
+	redisBus := bus.(*EventBus)
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("ephemeral")
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, eh.UseEventHandlerMiddleware(handler, ephemeral.NewMiddleware())); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(ctx, newTestEvent("event1")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Fatal("did not receive event in time")
+	}
+
+	// Messages are not kept pending for ephemeral handlers.
+	redisBus.registeredMu.RLock()
+	groupName := redisBus.registered[handler.HandlerType()].groupName
+	redisBus.registeredMu.RUnlock()
+
+	pending, err := redisBus.client.XPending(ctx, redisBus.streamName, groupName).Result()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if pending.Count != 0 {
+		t.Error("there should be no pending messages:", pending.Count)
+	}
+
+	if err := redisBus.RemoveHandler(ctx, handler.HandlerType()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	groups, err := redisBus.client.XInfoGroups(ctx, redisBus.streamName).Result()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	for _, g := range groups {
+		if g.Name == groupName {
+			t.Error("the consumer group should be deleted")
+		}
+	}
+}
+
+func TestReclaimPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		options     []Option
+		expectError string
+	}{
+		"negative interval": {
+			[]Option{WithReclaimPolicy(ReclaimPolicy{Interval: -1})},
+			"reclaim interval must not be negative",
+		},
+		"missing min idle": {
+			[]Option{WithReclaimPolicy(ReclaimPolicy{Interval: time.Second})},
+			"reclaim min idle time must be set",
+		},
+		"negative max deliveries": {
+			[]Option{WithReclaimPolicy(ReclaimPolicy{Interval: time.Second, MinIdle: time.Second, MaxDeliveries: -1})},
+			"reclaim max deliveries must not be negative",
+		},
+		"missing dead letter stream": {
+			[]Option{WithDeadLetterStream("")},
+			"missing dead letter stream name",
+		},
+		"invalid max length": {
+			[]Option{WithMaxLen(0)},
+			"max length must be positive",
+		},
+		"invalid min ID age": {
+			[]Option{WithMinIDAge(-time.Second)},
+			"min ID age must be positive",
+		},
+		"max length and min ID age": {
+			[]Option{WithMaxLen(10), WithMinIDAge(time.Hour)},
+			"max length and min ID age can not be combined",
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus("localhost:6379", "app", "client", tc.options...)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func newTestEvent(content string) eh.Event {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	return eh.NewEvent(mocks.EventType, &mocks.EventData{Content: content}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+}
+
+func newTestEventBus(appID string, options ...Option) (eh.EventBus, string, error) {
 	// Connect to localhost if not running inside docker
 	addr := os.Getenv("REDIS_ADDR")
 	if addr == "" {
@@ -108,7 +453,7 @@ func newTestEventBus(appID string) (eh.EventBus, string, error) {
 		appID = "app-" + hex.EncodeToString(bts)
 	}
 
-	bus, err := NewEventBus(addr, appID, "client", WithRedisOptions(opts))
+	bus, err := NewEventBus(addr, appID, "client", append([]Option{WithRedisOptions(opts)}, options...)...)
 	if err != nil {
 		return nil, "", fmt.Errorf("could not create event bus: %w", err)
 	}
@@ -0,0 +1,298 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	eh "github.com/reidlai/eventhorizon"
+)
+
+// DefaultReclaimBatchSize is the max number of pending messages checked each
+// reclaim interval if not set in the ReclaimPolicy.
+var DefaultReclaimBatchSize int64 = 100
+
+// ReclaimPolicy configures how messages left pending in a consumer group are
+// claimed again. Messages are left pending when a handler fails or when a
+// consumer crashes before acking them.
+type ReclaimPolicy struct {
+	// Interval is how often pending messages are checked, zero disables
+	// reclaiming of pending messages.
+	Interval time.Duration
+	// MinIdle is how long a message must have been pending before it is
+	// claimed, which should be longer than the time it takes to handle it.
+	MinIdle time.Duration
+	// MaxDeliveries is the number of deliveries after which a pending message
+	// is moved to the dead letter stream instead of being claimed again, zero
+	// retries the message forever.
+	MaxDeliveries int64
+	// BatchSize is the max number of pending messages checked each interval,
+	// DefaultReclaimBatchSize is used if zero.
+	BatchSize int64
+}
+
+func (p ReclaimPolicy) validate() error {
+	if p.Interval < 0 {
+		return errors.New("reclaim interval must not be negative")
+	}
+
+	if p.MinIdle < 0 {
+		return errors.New("reclaim min idle time must not be negative")
+	}
+
+	if p.Interval > 0 && p.MinIdle == 0 {
+		return errors.New("reclaim min idle time must be set")
+	}
+
+	if p.MaxDeliveries < 0 {
+		return errors.New("reclaim max deliveries must not be negative")
+	}
+
+	if p.BatchSize < 0 {
+		return errors.New("reclaim batch size must not be negative")
+	}
+
+	return nil
+}
+
+// WithReclaimPolicy periodically claims messages which have been pending for
+// longer than the min idle time of the policy, to retry messages which failed
+// or was left by a crashed consumer.
+func WithReclaimPolicy(p ReclaimPolicy) Option {
+	return func(b *EventBus) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+
+		b.reclaimPolicy = p
+
+		return nil
+	}
+}
+
+// WithDeadLetterStream moves messages which have been delivered the max number
+// of times of the reclaim policy to the stream. Without a dead letter stream
+// such messages are acked and dropped.
+func WithDeadLetterStream(name string) Option {
+	return func(b *EventBus) error {
+		if name == "" {
+			return errors.New("missing dead letter stream name")
+		}
+
+		b.deadLetterStream = name
+
+		return nil
+	}
+}
+
+// Keys added to messages moved to the dead letter stream, in addition to the
+// keys of the original message.
+const (
+	deadLetterHandlerTypeKey = "dead_letter_handler_type"
+	deadLetterStreamKey      = "dead_letter_stream"
+	deadLetterIDKey          = "dead_letter_id"
+	deadLetterDeliveriesKey  = "dead_letter_deliveries"
+)
+
+// Claims pending messages of a consumer group until the context is cancelled.
+func (b *EventBus) reclaim(ctx context.Context, h eh.EventHandler, handler func(context.Context, *redis.XMessage), groupName, consumer string) {
+	ticker := time.NewTicker(b.reclaimPolicy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := b.reclaimPending(ctx, h, handler, groupName, consumer); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			err = fmt.Errorf("could not reclaim pending messages: %w", err)
+			b.sendError(&eh.EventBusError{Err: err, HandlerType: h.HandlerType(), Retryable: true})
+		}
+	}
+}
+
+// Claims and handles the idle pending messages of a consumer group, messages
+// which have reached the max number of deliveries are dead lettered.
+func (b *EventBus) reclaimPending(ctx context.Context, h eh.EventHandler, handler func(context.Context, *redis.XMessage), groupName, consumer string) error {
+	count := b.reclaimPolicy.BatchSize
+	if count == 0 {
+		count = DefaultReclaimBatchSize
+	}
+
+	pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: b.streamName,
+		Group:  groupName,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("could not list pending messages: %w", err)
+	}
+
+	var retry, dead []string
+
+	deliveries := map[string]int64{}
+
+	for _, p := range pending {
+		if p.Idle < b.reclaimPolicy.MinIdle {
+			continue
+		}
+
+		deliveries[p.ID] = p.RetryCount
+
+		if b.reclaimPolicy.MaxDeliveries > 0 && p.RetryCount >= b.reclaimPolicy.MaxDeliveries {
+			dead = append(dead, p.ID)
+		} else {
+			retry = append(retry, p.ID)
+		}
+	}
+
+	if len(dead) > 0 {
+		msgs, err := b.claim(ctx, groupName, consumer, dead)
+		if err != nil {
+			return err
+		}
+
+		for i := range msgs {
+			if err := b.deadLetter(ctx, h.HandlerType(), groupName, &msgs[i], deliveries[msgs[i].ID]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(retry) > 0 {
+		msgs, err := b.claim(ctx, groupName, consumer, retry)
+		if err != nil {
+			return err
+		}
+
+		for i := range msgs {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			handler(ctx, &msgs[i])
+		}
+	}
+
+	return nil
+}
+
+// Claims the pending messages for the consumer. Messages which are still
+// pending but have been removed from the stream, for example by trimming, are
+// acked as they can't be handled.
+func (b *EventBus) claim(ctx context.Context, groupName, consumer string, ids []string) ([]redis.XMessage, error) {
+	args := &redis.XClaimArgs{
+		Stream:   b.streamName,
+		Group:    groupName,
+		Consumer: consumer,
+		MinIdle:  b.reclaimPolicy.MinIdle,
+		Messages: ids,
+	}
+
+	msgs, err := b.client.XClaim(ctx, args).Result()
+	if err == nil {
+		return b.ackDeleted(ctx, groupName, msgs)
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("could not claim pending messages: %w", err)
+	}
+
+	// Some Redis versions return nil for removed messages, which can't be
+	// parsed in a batch, claim them one by one instead.
+	msgs = nil
+
+	for _, id := range ids {
+		args.Messages = []string{id}
+
+		res, err := b.client.XClaim(ctx, args).Result()
+		if errors.Is(err, redis.Nil) {
+			if _, err := b.client.XAck(ctx, b.streamName, groupName, id).Result(); err != nil {
+				return nil, fmt.Errorf("could not ack removed message: %w", err)
+			}
+
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not claim pending message: %w", err)
+		}
+
+		msgs = append(msgs, res...)
+	}
+
+	return b.ackDeleted(ctx, groupName, msgs)
+}
+
+// Acks and filters out claimed messages without values, which have been
+// removed from the stream.
+func (b *EventBus) ackDeleted(ctx context.Context, groupName string, msgs []redis.XMessage) ([]redis.XMessage, error) {
+	res := msgs[:0]
+
+	for _, msg := range msgs {
+		if msg.Values != nil {
+			res = append(res, msg)
+
+			continue
+		}
+
+		if _, err := b.client.XAck(ctx, b.streamName, groupName, msg.ID).Result(); err != nil {
+			return nil, fmt.Errorf("could not ack removed message: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+// Moves a message to the dead letter stream, if any, and acks it. The message
+// is kept pending if it could not be dead lettered.
+func (b *EventBus) deadLetter(ctx context.Context, handlerType eh.EventHandlerType, groupName string, msg *redis.XMessage, deliveries int64) error {
+	if b.deadLetterStream == "" {
+		err := fmt.Errorf("dropping message %s after %d deliveries (%s)", msg.ID, deliveries, handlerType)
+		b.sendError(&eh.EventBusError{Err: err, Ctx: ctx, HandlerType: handlerType})
+	} else {
+		values := make(map[string]interface{}, len(msg.Values)+4)
+		for k, v := range msg.Values {
+			values[k] = v
+		}
+
+		values[deadLetterHandlerTypeKey] = handlerType.String()
+		values[deadLetterStreamKey] = b.streamName
+		values[deadLetterIDKey] = msg.ID
+		values[deadLetterDeliveriesKey] = deliveries
+
+		if _, err := b.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: b.deadLetterStream,
+			Values: values,
+		}).Result(); err != nil {
+			return fmt.Errorf("could not publish to dead letter stream: %w", err)
+		}
+	}
+
+	if _, err := b.client.XAck(ctx, b.streamName, groupName, msg.ID).Result(); err != nil {
+		return fmt.Errorf("could not ack dead lettered message: %w", err)
+	}
+
+	return nil
+}
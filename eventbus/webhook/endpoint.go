@@ -0,0 +1,205 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+)
+
+// Headers set on webhook requests.
+const (
+	// IdempotencyKeyHeader is the ID of the delivery, which is the same for all
+	// attempts to deliver an event to an endpoint, see DeliveryID.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// AttemptHeader is the attempt number of the delivery, starting at 1.
+	AttemptHeader = "X-Eventhorizon-Attempt"
+	// EventTypeHeader is the type of the event.
+	EventTypeHeader = "X-Eventhorizon-Event-Type"
+	// AggregateTypeHeader is the aggregate type of the event.
+	AggregateTypeHeader = "X-Eventhorizon-Aggregate-Type"
+	// AggregateIDHeader is the aggregate ID of the event.
+	AggregateIDHeader = "X-Eventhorizon-Aggregate-ID"
+	// VersionHeader is the aggregate version of the event.
+	VersionHeader = "X-Eventhorizon-Version"
+	// TimestampHeader is the Unix time when the request was signed.
+	TimestampHeader = "X-Eventhorizon-Timestamp"
+	// SignatureHeader is the signature of the request, see Sign.
+	SignatureHeader = "X-Eventhorizon-Signature"
+)
+
+// signaturePrefix is the prefix of the signature header value.
+const signaturePrefix = "sha256="
+
+// ErrInvalidSignature is returned by Verify when a signature is not valid.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Endpoint is a webhook endpoint which is sent events.
+type Endpoint struct {
+	// ID is the unique ID of the endpoint, which is used to resume pending
+	// deliveries after restarts.
+	ID string
+	// URL is where events are POSTed.
+	URL string
+	// Secret is used to sign requests.
+	Secret []byte
+}
+
+func (e Endpoint) validate() error {
+	if e.ID == "" {
+		return errors.New("missing endpoint ID")
+	}
+
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint URL: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid endpoint URL scheme: %q", u.Scheme)
+	}
+
+	if len(e.Secret) == 0 {
+		return errors.New("missing endpoint secret")
+	}
+
+	return nil
+}
+
+// EndpointHandlerType returns the handler type used for an endpoint.
+func EndpointHandlerType(id string) eh.EventHandlerType {
+	return eh.EventHandlerType("webhook_" + id)
+}
+
+// Sign returns the signature for a request body, which is the hex encoded
+// HMAC-SHA256 of the timestamp and the body separated by a dot, prefixed with
+// "sha256=".
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify verifies the signature of a request body, and that it was signed
+// within the tolerance. A zero tolerance skips the timestamp check.
+func Verify(secret []byte, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	if tolerance == 0 {
+		return nil
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	if d := time.Since(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("timestamp outside of tolerance: %s", d)
+	}
+
+	return nil
+}
+
+// VerifyRequest verifies the signature headers of a webhook request, and
+// returns the body.
+func VerifyRequest(r *http.Request, secret []byte, tolerance time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read body: %w", err)
+	}
+
+	if err := Verify(secret, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, tolerance); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// endpointHandler is an event handler which POSTs events to an endpoint.
+type endpointHandler struct {
+	Endpoint
+	client      *http.Client
+	codec       eh.EventCodec
+	contentType string
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (h *endpointHandler) HandlerType() eh.EventHandlerType {
+	return EndpointHandlerType(h.ID)
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
+func (h *endpointHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	body, err := h.codec.MarshalEvent(ctx, event)
+	if err != nil {
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", h.contentType)
+	req.Header.Set(EventTypeHeader, event.EventType().String())
+	req.Header.Set(AggregateTypeHeader, event.AggregateType().String())
+	req.Header.Set(AggregateIDHeader, event.AggregateID().String())
+	req.Header.Set(VersionHeader, strconv.Itoa(event.Version()))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(h.Secret, timestamp, body))
+
+	if d, ok := DeliveryFromContext(ctx); ok {
+		req.Header.Set(IdempotencyKeyHeader, d.ID.String())
+		req.Header.Set(AttemptHeader, strconv.Itoa(d.Attempts))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	// Drain the body to reuse the connection.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
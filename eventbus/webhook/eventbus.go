@@ -0,0 +1,555 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+// DefaultQueueSize is the default queue size per handler for deliveries.
+var DefaultQueueSize = 1000
+
+// EventBus is an event bus which delivers events to handlers with retries,
+// persisting the deliveries to survive restarts. It is mainly used to push
+// events to webhook endpoints over HTTP, added with AddEndpoint, but can be
+// used with any event handler.
+//
+// Deliveries are stored in a repo before publishing returns, and are removed
+// when handled. Deliveries which are still pending when the event bus is
+// closed are resumed when their handler is added again.
+type EventBus struct {
+	repo         eh.ReadWriteRepo
+	codec        eh.EventCodec
+	retryPolicy  RetryPolicy
+	httpClient   *http.Client
+	contentType  string
+	registered   map[eh.EventHandlerType]*registration
+	registeredMu sync.RWMutex
+	errCh        chan error
+	errorHandler eh.ErrorHandler
+	cctx         context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewEventBus creates an EventBus which stores pending deliveries in the repo.
+// The entity factory of the repo should be set to create a *Delivery.
+func NewEventBus(repo eh.ReadWriteRepo, options ...Option) (*EventBus, error) {
+	if repo == nil {
+		return nil, errors.New("missing delivery repo")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := &EventBus{
+		repo:        repo,
+		codec:       &json.EventCodec{},
+		retryPolicy: DefaultRetryPolicy,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		contentType: "application/json",
+		registered:  map[eh.EventHandlerType]*registration{},
+		errCh:       make(chan error, 100),
+		cctx:        ctx,
+		cancel:      cancel,
+	}
+
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+
+		if err := option(b); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventBus) error
+
+// WithCodec uses the specified codec for encoding events, both for storing
+// deliveries and as the body of webhook requests.
+func WithCodec(codec eh.EventCodec, contentType string) Option {
+	return func(b *EventBus) error {
+		if codec == nil {
+			return errors.New("missing codec")
+		}
+
+		b.codec = codec
+		b.contentType = contentType
+
+		return nil
+	}
+}
+
+// WithHTTPClient uses the HTTP client for webhook requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *EventBus) error {
+		if client == nil {
+			return errors.New("missing HTTP client")
+		}
+
+		b.httpClient = client
+
+		return nil
+	}
+}
+
+// RetryPolicy is the policy for retrying deliveries that failed.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts to deliver an event, after
+	// which the delivery is dropped. Zero means unlimited attempts.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, which is doubled
+	// for each following retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the max delay between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy retries failed deliveries 10 times, with a backoff from
+// one second up to one hour.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Hour,
+}
+
+// Returns the backoff before the next attempt after a failed attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return d
+}
+
+// WithRetryPolicy uses the retry policy for failed deliveries, instead of
+// the DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(b *EventBus) error {
+		if p.MaxAttempts < 0 {
+			return errors.New("max attempts must not be negative")
+		}
+
+		if p.InitialBackoff <= 0 {
+			return errors.New("initial backoff must be positive")
+		}
+
+		if p.MaxBackoff < p.InitialBackoff {
+			return errors.New("max backoff must not be less than the initial backoff")
+		}
+
+		b.retryPolicy = p
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(b *EventBus) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		b.errorHandler = h
+
+		return nil
+	}
+}
+
+// Delivery is a pending delivery of an event to a handler.
+type Delivery struct {
+	ID          uuid.UUID           `json:"id"                   bson:"_id"`
+	HandlerType eh.EventHandlerType `json:"handler_type"         bson:"handler_type"`
+	Event       []byte              `json:"event"                bson:"event"`
+	Attempts    int                 `json:"attempts"             bson:"attempts"`
+	NextAttempt time.Time           `json:"next_attempt"         bson:"next_attempt"`
+	LastError   string              `json:"last_error,omitempty" bson:"last_error,omitempty"`
+}
+
+// EntityID implements the EntityID method of the eventhorizon.Entity interface.
+func (d *Delivery) EntityID() uuid.UUID {
+	return d.ID
+}
+
+type contextKey int
+
+const deliveryKey contextKey = iota
+
+// DeliveryFromContext returns the delivery which is being handled, it can be
+// used by handlers to get the ID, which is the same for all attempts, and the
+// attempt number.
+func DeliveryFromContext(ctx context.Context) (*Delivery, bool) {
+	d, ok := ctx.Value(deliveryKey).(*Delivery)
+
+	return d, ok
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (b *EventBus) HandlerType() eh.EventHandlerType {
+	return "eventbus"
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler
+// interface. A delivery is stored for each matching handler before returning.
+func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	// Find the matching handlers without holding the lock while queueing.
+	b.registeredMu.RLock()
+
+	matching := map[eh.EventHandlerType]*registration{}
+
+	for handlerType, r := range b.registered {
+		if r.matcher.Match(event) {
+			matching[handlerType] = r
+		}
+	}
+
+	b.registeredMu.RUnlock()
+
+	if len(matching) == 0 {
+		return nil
+	}
+
+	data, err := b.codec.MarshalEvent(ctx, event)
+	if err != nil {
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+
+	for handlerType, r := range matching {
+		id := DeliveryID(handlerType, event)
+
+		// Skip events which are published again while the delivery is pending,
+		// to not reset the attempts or queue the delivery twice.
+		if _, err := b.repo.Find(ctx, id); err == nil {
+			continue
+		} else if !errors.Is(err, eh.ErrEntityNotFound) {
+			return fmt.Errorf("could not find delivery: %w", err)
+		}
+
+		d := &Delivery{
+			ID:          id,
+			HandlerType: handlerType,
+			Event:       data,
+			NextAttempt: time.Now(),
+		}
+
+		if err := b.repo.Save(ctx, d); err != nil {
+			return fmt.Errorf("could not save delivery: %w", err)
+		}
+
+		// Queue in the background if the queue is full, to not block publishing.
+		// The delivery is resumed when the handler is added again if stopped.
+		select {
+		case r.queue <- d:
+		default:
+			r.schedule(d)
+		}
+	}
+
+	return nil
+}
+
+// Namespace for the delivery IDs, which are derived from the handler type and
+// the event.
+var deliveryNamespace = uuid.MustParse("3f6d2b0e-8a4c-4e57-b1d9-6c2e7a9f0b41")
+
+// DeliveryID returns the ID of the delivery of an event to a handler, which
+// is used as the idempotency key for webhook requests. The ID is derived from
+// the handler type and the whole event, without its context, so that the key
+// is the same if the event is published again but differs between events of
+// the same aggregate and version. Events without an aggregate get a random ID.
+func DeliveryID(handlerType eh.EventHandlerType, event eh.Event) uuid.UUID {
+	if event.AggregateID() == uuid.Nil {
+		return uuid.New()
+	}
+
+	data, err := (&json.EventCodec{}).MarshalEvent(context.Background(), event)
+	if err != nil {
+		return uuid.New()
+	}
+
+	return uuid.NewSHA1(deliveryNamespace, append([]byte(handlerType.String()+"."), data...))
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus
+// interface. Any pending deliveries for the handler are resumed.
+func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
+	if m == nil {
+		return eh.ErrMissingMatcher
+	}
+
+	if h == nil {
+		return eh.ErrMissingHandler
+	}
+
+	// Check handler existence.
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	if _, ok := b.registered[h.HandlerType()]; ok {
+		return eh.ErrHandlerAlreadyAdded
+	}
+
+	pending, err := b.pendingDeliveries(ctx, h.HandlerType())
+	if err != nil {
+		return err
+	}
+
+	// Register handler.
+	hctx, cancel := context.WithCancel(b.cctx)
+	r := &registration{
+		matcher: m,
+		queue:   make(chan *Delivery, DefaultQueueSize),
+		ctx:     hctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	b.registered[h.HandlerType()] = r
+
+	for _, d := range pending {
+		r.schedule(d)
+	}
+
+	b.wg.Add(1)
+
+	// Handle until context is cancelled.
+	go b.handle(h, r)
+
+	return nil
+}
+
+// AddEndpoint adds a webhook endpoint which is sent all matching events.
+func (b *EventBus) AddEndpoint(ctx context.Context, m eh.EventMatcher, e Endpoint) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	return b.AddHandler(ctx, m, &endpointHandler{
+		Endpoint:    e,
+		client:      b.httpClient,
+		codec:       b.codec,
+		contentType: b.contentType,
+	})
+}
+
+// RemoveEndpoint removes a webhook endpoint by ID. Pending deliveries are kept
+// until the endpoint is added again.
+func (b *EventBus) RemoveEndpoint(ctx context.Context, id string) error {
+	return b.RemoveHandler(ctx, EndpointHandlerType(id))
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface. Pending deliveries are kept
+// until the handler is added again.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	b.registeredMu.Lock()
+	defer b.registeredMu.Unlock()
+
+	r, ok := b.registered[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(b.registered, handlerType)
+
+	// Stop handling and wait for any ongoing delivery to finish.
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// Errors implements the Errors method of the eventhorizon.EventBus interface.
+func (b *EventBus) Errors() <-chan error {
+	return b.errCh
+}
+
+// Close implements the Close method of the eventhorizon.EventBus interface.
+// The delivery repo is not closed.
+func (b *EventBus) Close() error {
+	b.cancel()
+	b.wg.Wait()
+
+	return nil
+}
+
+// registration is an added handler, which can be stopped by cancelling.
+type registration struct {
+	matcher eh.EventMatcher
+	queue   chan *Delivery
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Queues the delivery at the time of its next attempt.
+func (r *registration) schedule(d *Delivery) {
+	time.AfterFunc(time.Until(d.NextAttempt), func() {
+		select {
+		case r.queue <- d:
+		case <-r.ctx.Done():
+		}
+	})
+}
+
+// Returns the stored deliveries for a handler type.
+func (b *EventBus) pendingDeliveries(ctx context.Context, handlerType eh.EventHandlerType) ([]*Delivery, error) {
+	entities, err := b.repo.FindAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not load pending deliveries: %w", err)
+	}
+
+	var deliveries []*Delivery
+
+	for _, entity := range entities {
+		d, ok := entity.(*Delivery)
+		if !ok {
+			return nil, fmt.Errorf("delivery is of incorrect type %T", entity)
+		}
+
+		if d.HandlerType == handlerType {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	return deliveries, nil
+}
+
+// Handles all deliveries coming in on the queue.
+func (b *EventBus) handle(h eh.EventHandler, r *registration) {
+	defer b.wg.Done()
+	defer close(r.done)
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case d := <-r.queue:
+			b.deliver(r, h, d)
+		}
+	}
+}
+
+// Makes an attempt to deliver an event to a handler, and schedules a retry if
+// it failed.
+func (b *EventBus) deliver(r *registration, h eh.EventHandler, d *Delivery) {
+	event, ctx, err := b.codec.UnmarshalEvent(r.ctx, d.Event)
+	if err != nil {
+		// The delivery can never succeed.
+		b.sendError(&eh.EventBusError{
+			Err:         fmt.Errorf("could not unmarshal event: %w", err),
+			Ctx:         ctx,
+			HandlerType: h.HandlerType(),
+		})
+		b.removeDelivery(d)
+
+		return
+	}
+
+	d.Attempts++
+
+	err = h.HandleEvent(context.WithValue(ctx, deliveryKey, d), event)
+	if err == nil {
+		b.removeDelivery(d)
+
+		return
+	}
+
+	retry := b.retryPolicy.MaxAttempts == 0 || d.Attempts < b.retryPolicy.MaxAttempts
+
+	b.sendError(&eh.EventBusError{
+		Err:         fmt.Errorf("could not deliver event (%s), attempt %d: %w", h.HandlerType(), d.Attempts, err),
+		Ctx:         ctx,
+		Event:       event,
+		HandlerType: h.HandlerType(),
+		Retryable:   retry,
+	})
+
+	if !retry {
+		b.sendError(&eh.EventBusError{
+			Err:         fmt.Errorf("dropping delivery %s (%s) after %d attempts", d.ID, h.HandlerType(), d.Attempts),
+			Ctx:         ctx,
+			Event:       event,
+			HandlerType: h.HandlerType(),
+		})
+		b.removeDelivery(d)
+
+		return
+	}
+
+	d.NextAttempt = time.Now().Add(b.retryPolicy.backoff(d.Attempts))
+	d.LastError = err.Error()
+
+	// Use a new context to store the attempt also when stopping.
+	if err := b.repo.Save(context.Background(), d); err != nil {
+		b.sendError(&eh.EventBusError{
+			Err:         fmt.Errorf("could not save delivery: %w", err),
+			Ctx:         ctx,
+			Event:       event,
+			HandlerType: h.HandlerType(),
+			Retryable:   true,
+		})
+	}
+
+	r.schedule(d)
+}
+
+func (b *EventBus) removeDelivery(d *Delivery) {
+	// Use a new context to remove the delivery also when stopping.
+	if err := b.repo.Remove(context.Background(), d.ID); err != nil && !errors.Is(err, eh.ErrEntityNotFound) {
+		b.sendError(&eh.EventBusError{Err: fmt.Errorf("could not remove delivery: %w", err), HandlerType: d.HandlerType})
+	}
+}
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (b *EventBus) sendError(err error) {
+	if b.errorHandler != nil {
+		b.errorHandler.HandleError(err)
+	}
+
+	select {
+	case b.errCh <- err:
+	default:
+		if b.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in webhook event bus: %s", err)
+		}
+	}
+}
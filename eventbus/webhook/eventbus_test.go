@@ -0,0 +1,616 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/codec/json"
+	"github.com/reidlai/eventhorizon/eventbus"
+	"github.com/reidlai/eventhorizon/mocks"
+	repo "github.com/reidlai/eventhorizon/repo/memory"
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+func TestAddHandler(t *testing.T) {
+	bus, _ := newTestEventBus(t)
+
+	eventbus.TestAddHandler(t, bus)
+}
+
+func TestRemoveHandler(t *testing.T) {
+	bus, _ := newTestEventBus(t)
+
+	eventbus.TestRemoveHandler(t, bus, 100*time.Millisecond)
+}
+
+func TestEndpoint(t *testing.T) {
+	bus, deliveries := newTestEventBus(t)
+	defer bus.Close()
+
+	secret := []byte("secret")
+	server, requests := newTestServer(t, secret, nil)
+
+	ctx := context.Background()
+	if err := bus.AddEndpoint(ctx, eh.MatchEvents{mocks.EventType}, Endpoint{
+		ID:     "partner",
+		URL:    server.URL,
+		Secret: secret,
+	}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	other := eh.NewEvent(mocks.EventOtherType, nil, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+	if err := bus.HandleEvent(ctx, other); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent()
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	r := waitForRequest(t, requests)
+
+	if err := eh.CompareEvents(r.event, event, eh.IgnorePositionMetadata()); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+
+	if r.header.Get("Content-Type") != "application/json" {
+		t.Error("the content type should be correct:", r.header.Get("Content-Type"))
+	}
+
+	if r.header.Get(EventTypeHeader) != mocks.EventType.String() ||
+		r.header.Get(AggregateTypeHeader) != mocks.AggregateType.String() ||
+		r.header.Get(AggregateIDHeader) != event.AggregateID().String() ||
+		r.header.Get(VersionHeader) != "1" {
+		t.Error("the event headers should be correct:", r.header)
+	}
+
+	if r.header.Get(IdempotencyKeyHeader) != DeliveryID(EndpointHandlerType("partner"), event).String() {
+		t.Error("the idempotency key should be derived from the event:", r.header.Get(IdempotencyKeyHeader))
+	}
+
+	if r.header.Get(AttemptHeader) != "1" {
+		t.Error("the attempt should be correct:", r.header.Get(AttemptHeader))
+	}
+
+	// The non-matching event should not be sent.
+	select {
+	case r := <-requests:
+		t.Error("there should be no other request:", r.event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	waitForDeliveries(t, deliveries, 0)
+
+	if err := bus.RemoveEndpoint(ctx, "partner"); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestEndpointRetry(t *testing.T) {
+	bus, deliveries := newTestEventBus(t, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	}))
+	defer bus.Close()
+
+	secret := []byte("secret")
+
+	var (
+		mu       sync.Mutex
+		failures = 2
+	)
+
+	server, requests := newTestServer(t, secret, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failures > 0 {
+			failures--
+
+			return http.StatusServiceUnavailable
+		}
+
+		return http.StatusOK
+	})
+
+	ctx := context.Background()
+	if err := bus.AddEndpoint(ctx, eh.MatchAll{}, Endpoint{
+		ID:     "partner",
+		URL:    server.URL,
+		Secret: secret,
+	}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(ctx, newTestEvent()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	var idempotencyKey string
+
+	for i := 1; i <= 3; i++ {
+		r := waitForRequest(t, requests)
+
+		if r.header.Get(AttemptHeader) != strconv.Itoa(i) {
+			t.Error("the attempt should be correct:", r.header.Get(AttemptHeader))
+		}
+
+		if i == 1 {
+			idempotencyKey = r.header.Get(IdempotencyKeyHeader)
+		} else if r.header.Get(IdempotencyKeyHeader) != idempotencyKey {
+			t.Error("the idempotency key should be the same for all attempts")
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-bus.Errors():
+			if !strings.Contains(err.Error(), "unexpected status 503") {
+				t.Error("the error should be correct:", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	waitForDeliveries(t, deliveries, 0)
+}
+
+func TestEndpointResume(t *testing.T) {
+	bus, deliveries := newTestEventBus(t, WithRetryPolicy(RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
+	}))
+
+	secret := []byte("secret")
+
+	var (
+		mu     sync.Mutex
+		status = http.StatusInternalServerError
+	)
+
+	server, requests := newTestServer(t, secret, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return status
+	})
+
+	ctx := context.Background()
+	endpoint := Endpoint{
+		ID:     "partner",
+		URL:    server.URL,
+		Secret: secret,
+	}
+
+	if err := bus.AddEndpoint(ctx, eh.MatchAll{}, endpoint); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent()
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	first := waitForRequest(t, requests)
+
+	select {
+	case <-bus.Errors():
+	case <-time.After(time.Second):
+		t.Fatal("there should be an error")
+	}
+
+	// Stop before the retry, the failed delivery should be stored.
+	if err := bus.Close(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	entities := waitForDeliveries(t, deliveries, 1)
+
+	d, ok := entities[0].(*Delivery)
+	if !ok {
+		t.Fatalf("the delivery should be of correct type: %T", entities[0])
+	}
+
+	if d.HandlerType != EndpointHandlerType("partner") || d.Attempts != 1 ||
+		!strings.Contains(d.LastError, "unexpected status 500") {
+		t.Errorf("the delivery should be correct: %+v", d)
+	}
+
+	mu.Lock()
+	status = http.StatusNoContent
+	mu.Unlock()
+
+	// A new event bus should resume the delivery when the endpoint is added.
+	bus2, err := NewEventBus(deliveries)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	defer bus2.Close()
+
+	if err := bus2.AddEndpoint(ctx, eh.MatchAll{}, endpoint); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	r := waitForRequest(t, requests)
+
+	if err := eh.CompareEvents(r.event, event, eh.IgnorePositionMetadata()); err != nil {
+		t.Error("the event should be correct:", err)
+	}
+
+	if r.header.Get(IdempotencyKeyHeader) != first.header.Get(IdempotencyKeyHeader) {
+		t.Error("the idempotency key should be the same after resuming")
+	}
+
+	if r.header.Get(AttemptHeader) != "2" {
+		t.Error("the attempt should be correct:", r.header.Get(AttemptHeader))
+	}
+
+	waitForDeliveries(t, deliveries, 0)
+}
+
+func TestEndpointPublishAgain(t *testing.T) {
+	bus, deliveries := newTestEventBus(t, WithRetryPolicy(RetryPolicy{
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     200 * time.Millisecond,
+	}))
+	defer bus.Close()
+
+	secret := []byte("secret")
+	server, requests := newTestServer(t, secret, func() int {
+		return http.StatusInternalServerError
+	})
+
+	ctx := context.Background()
+	if err := bus.AddEndpoint(ctx, eh.MatchAll{}, Endpoint{
+		ID:     "partner",
+		URL:    server.URL,
+		Secret: secret,
+	}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event := newTestEvent()
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	waitForRequest(t, requests)
+
+	select {
+	case <-bus.Errors():
+	case <-time.After(time.Second):
+		t.Fatal("there should be an error")
+	}
+
+	// Publishing the event again while the delivery is pending should keep the
+	// delivery as is, and not send it again before the retry.
+	if err := bus.HandleEvent(ctx, event); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	select {
+	case r := <-requests:
+		t.Error("there should be no request before the retry:", r.header.Get(AttemptHeader))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	entities := waitForDeliveries(t, deliveries, 1)
+
+	d, ok := entities[0].(*Delivery)
+	if !ok {
+		t.Fatalf("the delivery should be of correct type: %T", entities[0])
+	}
+
+	if d.Attempts != 1 {
+		t.Error("the delivery should be kept:", d.Attempts)
+	}
+
+	if r := waitForRequest(t, requests); r.header.Get(AttemptHeader) != "2" {
+		t.Error("the attempt should be correct:", r.header.Get(AttemptHeader))
+	}
+}
+
+func TestDeliveryID(t *testing.T) {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	id := uuid.New()
+
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 0))
+	same := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 0))
+	other := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "other"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id, 0))
+
+	if DeliveryID("handler", event) != DeliveryID("handler", same) {
+		t.Error("the delivery ID should be the same for the same event")
+	}
+
+	if DeliveryID("handler", event) == DeliveryID("handler", other) {
+		t.Error("the delivery ID should differ for events with the same version")
+	}
+
+	if DeliveryID("handler", event) == DeliveryID("another_handler", event) {
+		t.Error("the delivery ID should differ between handlers")
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	bus, deliveries := newTestEventBus(t, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}))
+	defer bus.Close()
+
+	ctx := context.Background()
+
+	handler := mocks.NewEventHandler("handler")
+	handler.Err = errors.New("handler error")
+
+	if err := bus.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := bus.HandleEvent(ctx, newTestEvent()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	for _, expected := range []string{
+		"could not deliver event (handler), attempt 1: handler error",
+		"could not deliver event (handler), attempt 2: handler error",
+		"after 2 attempts",
+	} {
+		select {
+		case err := <-bus.Errors():
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("the error should contain %q: %s", expected, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("there should be an error")
+		}
+	}
+
+	waitForDeliveries(t, deliveries, 0)
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"event_type":"test"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := Sign(secret, timestamp, body)
+
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Error("the signature should have the correct prefix:", signature)
+	}
+
+	if err := Verify(secret, timestamp, signature, body, time.Minute); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if err := Verify([]byte("other"), timestamp, signature, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Error("the error should be correct:", err)
+	}
+
+	if err := Verify(secret, timestamp, signature, []byte(`{}`), time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Error("the error should be correct:", err)
+	}
+
+	if err := Verify(secret, "0", signature, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Error("the error should be correct:", err)
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if err := Verify(secret, old, Sign(secret, old, body), body, time.Minute); err == nil ||
+		!strings.HasPrefix(err.Error(), "timestamp outside of tolerance") {
+		t.Error("the error should be correct:", err)
+	}
+
+	if err := Verify(secret, old, Sign(secret, old, body), body, 0); err != nil {
+		t.Error("there should be no error without tolerance:", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+	}
+
+	for attempt, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if d := p.backoff(attempt); d != expected {
+			t.Errorf("the backoff for attempt %d should be %s: %s", attempt, expected, d)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	if _, err := NewEventBus(nil); err == nil || err.Error() != "missing delivery repo" {
+		t.Error("the error should be correct:", err)
+	}
+
+	testCases := map[string]struct {
+		option      Option
+		expectError string
+	}{
+		"missing codec": {
+			WithCodec(nil, "application/json"),
+			"missing codec",
+		},
+		"missing HTTP client": {
+			WithHTTPClient(nil),
+			"missing HTTP client",
+		},
+		"negative max attempts": {
+			WithRetryPolicy(RetryPolicy{MaxAttempts: -1, InitialBackoff: time.Second, MaxBackoff: time.Second}),
+			"max attempts must not be negative",
+		},
+		"invalid initial backoff": {
+			WithRetryPolicy(RetryPolicy{MaxBackoff: time.Second}),
+			"initial backoff must be positive",
+		},
+		"invalid max backoff": {
+			WithRetryPolicy(RetryPolicy{InitialBackoff: time.Second}),
+			"max backoff must not be less than the initial backoff",
+		},
+	}
+
+	for desc, tc := range testCases {
+		tc := tc
+
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewEventBus(repo.NewRepo(), tc.option)
+			if err == nil || !strings.HasSuffix(err.Error(), tc.expectError) {
+				t.Fatalf("expected error %s, got: %v", tc.expectError, err)
+			}
+		})
+	}
+
+	bus, _ := newTestEventBus(t)
+	defer bus.Close()
+
+	for desc, e := range map[string]Endpoint{
+		"missing endpoint ID":               {URL: "http://localhost", Secret: []byte("secret")},
+		"invalid endpoint URL scheme: \"\"": {ID: "id", Secret: []byte("secret")},
+		"missing endpoint secret":           {ID: "id", URL: "http://localhost"},
+	} {
+		if err := bus.AddEndpoint(context.Background(), eh.MatchAll{}, e); err == nil || err.Error() != desc {
+			t.Errorf("the error should be %q: %v", desc, err)
+		}
+	}
+}
+
+func newTestEventBus(t *testing.T, options ...Option) (*EventBus, *repo.Repo) {
+	t.Helper()
+
+	deliveries := repo.NewRepo()
+	deliveries.SetEntityFactory(func() eh.Entity { return &Delivery{} })
+
+	bus, err := NewEventBus(deliveries, options...)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	return bus, deliveries
+}
+
+func newTestEvent() eh.Event {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	return eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+}
+
+type testRequest struct {
+	header http.Header
+	event  eh.Event
+}
+
+// Creates a server which verifies and decodes requests, and responds with the
+// status from the func, or 200 OK if nil.
+func newTestServer(t *testing.T, secret []byte, status func() int) (*httptest.Server, <-chan testRequest) {
+	t.Helper()
+
+	requests := make(chan testRequest, 10)
+	codec := &json.EventCodec{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Error("the method should be POST:", r.Method)
+		}
+
+		body, err := VerifyRequest(r, secret, time.Minute)
+		if err != nil {
+			t.Error("the request should be verified:", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+
+			return
+		}
+
+		event, _, err := codec.UnmarshalEvent(r.Context(), body)
+		if err != nil {
+			t.Error("the event should be decoded:", err)
+		}
+
+		requests <- testRequest{header: r.Header.Clone(), event: event}
+
+		if status != nil {
+			w.WriteHeader(status())
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+func waitForRequest(t *testing.T, requests <-chan testRequest) testRequest {
+	t.Helper()
+
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("did not receive request in time")
+	}
+
+	return testRequest{}
+}
+
+// Waits until the number of stored deliveries is the count.
+func waitForDeliveries(t *testing.T, deliveries eh.ReadRepo, count int) []eh.Entity {
+	t.Helper()
+
+	var (
+		entities []eh.Entity
+		err      error
+	)
+
+	for i := 0; i < 100; i++ {
+		if entities, err = deliveries.FindAll(context.Background()); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		if len(entities) == count {
+			return entities
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("there should be %d deliveries: %d", count, len(entities))
+
+	return nil
+}
@@ -44,6 +44,43 @@ type EventHandler interface {
 	HandleEvent(context.Context, Event) error
 }
 
+// EventBatchHandler is an optional interface for event handlers that can handle
+// several events at once more efficiently than one by one, for example event
+// buses that can publish events in one round trip.
+type EventBatchHandler interface {
+	// HandleEvents handles events in order.
+	HandleEvents(context.Context, []Event) error
+}
+
+// HandleEvents lets the handler handle the events at once if it implements
+// EventBatchHandler, otherwise one by one until the first error.
+func HandleEvents(ctx context.Context, h EventHandler, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if bh, ok := h.(EventBatchHandler); ok {
+		if err := bh.HandleEvents(ctx, events); err != nil {
+			return &EventHandlerError{
+				Err: err,
+			}
+		}
+
+		return nil
+	}
+
+	for _, e := range events {
+		if err := h.HandleEvent(ctx, e); err != nil {
+			return &EventHandlerError{
+				Err:   err,
+				Event: e,
+			}
+		}
+	}
+
+	return nil
+}
+
 // EventHandlerFunc is a function that can be used as a event handler.
 type EventHandlerFunc func(context.Context, Event) error
 
@@ -16,6 +16,7 @@ package eventhorizon
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
 	"time"
@@ -41,3 +42,66 @@ func TestEventHandlerFunc(t *testing.T) {
 		t.Log(events)
 	}
 }
+
+func TestHandleEvents(t *testing.T) {
+	ctx := context.Background()
+	e1 := NewEvent("test", nil, time.Now())
+	e2 := NewEvent("test", nil, time.Now())
+	handlerErr := errors.New("handler error")
+
+	// One by one, until the first error.
+	var handled []Event
+
+	h := EventHandlerFunc(func(ctx context.Context, e Event) error {
+		handled = append(handled, e)
+
+		if len(handled) == 2 {
+			return handlerErr
+		}
+
+		return nil
+	})
+
+	err := HandleEvents(ctx, h, []Event{e1, e2, e1})
+
+	var handlerError *EventHandlerError
+	if !errors.As(err, &handlerError) || !errors.Is(err, handlerErr) || handlerError.Event != e2 {
+		t.Error("the error should be correct:", err)
+	}
+
+	if !reflect.DeepEqual(handled, []Event{e1, e2}) {
+		t.Error("the events should be correct:", handled)
+	}
+
+	// At once with a batch handler.
+	bh := &batchHandler{EventHandlerFunc: h}
+	if err := HandleEvents(ctx, bh, []Event{e1, e2}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !reflect.DeepEqual(bh.batches, [][]Event{{e1, e2}}) {
+		t.Error("the events should be handled at once:", bh.batches)
+	}
+
+	bh.err = handlerErr
+	if err := HandleEvents(ctx, bh, []Event{e1}); !errors.As(err, &handlerError) || !errors.Is(err, handlerErr) {
+		t.Error("the error should be correct:", err)
+	}
+
+	// No events.
+	if err := HandleEvents(ctx, bh, nil); err != nil || len(bh.batches) != 2 {
+		t.Error("no events should be handled:", err, bh.batches)
+	}
+}
+
+type batchHandler struct {
+	EventHandlerFunc
+	batches [][]Event
+	err     error
+}
+
+func (h *batchHandler) HandleEvents(ctx context.Context, events []Event) error {
+	h.batches = append(h.batches, events)
+
+	return h.err
+}
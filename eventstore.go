@@ -38,6 +38,26 @@ type EventStore interface {
 	Close() error
 }
 
+// GlobalEventLoader is an event store which can load all events in the order
+// they were saved, using a global position which is increasing by one for each
+// saved event.
+type GlobalEventLoader interface {
+	// LoadAll loads at most limit events with a global position after the
+	// position, in the order of their global positions. All remaining events
+	// are loaded if limit is 0.
+	LoadAll(ctx context.Context, position, limit int) ([]PositionedEvent, error)
+
+	// LastPosition returns the global position of the last saved event, or 0
+	// if there are no events.
+	LastPosition(ctx context.Context) (int, error)
+}
+
+// PositionedEvent is an event with its global position in an event store.
+type PositionedEvent struct {
+	Event    Event
+	Position int
+}
+
 // SnapshotStore is an interface for snapshot store.
 type SnapshotStore interface {
 	LoadSnapshot(ctx context.Context, id uuid.UUID) (*Snapshot, error)
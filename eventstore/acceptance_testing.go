@@ -288,6 +288,99 @@ func SnapshotAcceptanceTest(t *testing.T, store eh.EventStore, ctx context.Conte
 	assert.Equal(t, snapshot.State, loaded.State)
 }
 
+// GlobalEventLoaderAcceptanceTest tests loading of events by global position
+// for implementations of EventStore which also implements eh.GlobalEventLoader.
+func GlobalEventLoaderAcceptanceTest(t *testing.T, store eh.EventStore, ctx context.Context) {
+	loader, ok := store.(eh.GlobalEventLoader)
+	if !ok {
+		return
+	}
+
+	// Find the current last position, the store could contain other events.
+	existing, err := loader.LoadAll(ctx, 0, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	lastPosition := 0
+	for i, e := range existing {
+		if e.Position <= lastPosition {
+			t.Error("the positions should be increasing:", e.Position, i)
+		}
+
+		lastPosition = e.Position
+	}
+
+	// Save events for two aggregates, interleaved.
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 1))
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id2, 1))
+	event3 := eh.NewEvent(mocks.EventOtherType, nil, timestamp,
+		eh.ForAggregate(mocks.AggregateType, id1, 2))
+
+	if err := store.Save(ctx, []eh.Event{event1}, 0); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := store.Save(ctx, []eh.Event{event2}, 0); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := store.Save(ctx, []eh.Event{event3}, 1); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if pos, err := loader.LastPosition(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	} else if pos != lastPosition+3 {
+		t.Error("the last position should be correct:", pos)
+	}
+
+	expected := []eh.Event{event1, event2, event3}
+
+	events, err := loader.LoadAll(ctx, lastPosition, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("there should be %d events, got: %d", len(expected), len(events))
+	}
+
+	for i, e := range events {
+		if e.Position != lastPosition+i+1 {
+			t.Errorf("the position of event %d should be correct: %d", i, e.Position)
+		}
+
+		if err := eh.CompareEvents(e.Event, expected[i], eh.IgnorePositionMetadata()); err != nil {
+			t.Errorf("event %d should be correct: %s", i, err)
+		}
+	}
+
+	// Load with a limit.
+	events, err = loader.LoadAll(ctx, lastPosition, 2)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(events) != 2 || events[1].Position != lastPosition+2 {
+		t.Error("the limited events should be correct:", events)
+	}
+
+	// Load after the last event.
+	events, err = loader.LoadAll(ctx, lastPosition+3, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(events) != 0 {
+		t.Error("there should be no events:", events)
+	}
+}
+
 func eventsToString(events []eh.Event) string {
 	parts := make([]string, len(events))
 	for i, e := range events {
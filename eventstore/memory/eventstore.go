@@ -29,6 +29,7 @@ import (
 // memory and not persisted. Useful for testing and experimenting.
 type EventStore struct {
 	db           map[uuid.UUID]aggregateRecord
+	positions    []eventPosition
 	dbMu         sync.RWMutex
 	eventHandler eh.EventHandler
 }
@@ -70,13 +71,8 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 	// Let the optional event handler handle the events. Aborts the transaction
 	// in case of error.
 	if s.eventHandler != nil {
-		for _, e := range events {
-			if err := s.eventHandler.HandleEvent(ctx, e); err != nil {
-				return &eh.EventHandlerError{
-					Err:   err,
-					Event: e,
-				}
-			}
+		if err := eh.HandleEvents(ctx, s.eventHandler, events); err != nil {
+			return err
 		}
 	}
 
@@ -162,6 +158,7 @@ func (s *EventStore) save(ctx context.Context, events []eh.Event, originalVersio
 		}
 
 		s.db[id] = aggregate
+		s.addPositions(dbEvents)
 	} else {
 		// Increment aggregate version on insert of new event record, and
 		// only insert if version of aggregate is matching (ie not changed
@@ -182,6 +179,7 @@ func (s *EventStore) save(ctx context.Context, events []eh.Event, originalVersio
 			aggregate.Events = append(aggregate.Events, dbEvents...)
 
 			s.db[id] = aggregate
+			s.addPositions(dbEvents)
 		}
 	}
 
@@ -232,6 +230,71 @@ func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([
 	return events, nil
 }
 
+// LoadAll implements the LoadAll method of the eventhorizon.GlobalEventLoader interface.
+func (s *EventStore) LoadAll(ctx context.Context, position, limit int) ([]eh.PositionedEvent, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	if position < 0 {
+		position = 0
+	}
+
+	end := len(s.positions)
+	if limit > 0 && position+limit < end {
+		end = position + limit
+	}
+
+	var events []eh.PositionedEvent
+
+	// Positions start at 1 and are stored in order.
+	for i := position; i < end; i++ {
+		p := s.positions[i]
+		event := s.db[p.AggregateID].Events[p.Version-1]
+
+		e, err := copyEvent(ctx, event)
+		if err != nil {
+			return nil, &eh.EventStoreError{
+				Err:              fmt.Errorf("could not copy event: %w", err),
+				Op:               eh.EventStoreOpLoad,
+				AggregateType:    event.AggregateType(),
+				AggregateID:      p.AggregateID,
+				AggregateVersion: p.Version,
+			}
+		}
+
+		events = append(events, eh.PositionedEvent{
+			Event:    e,
+			Position: i + 1,
+		})
+	}
+
+	return events, nil
+}
+
+// LastPosition implements the LastPosition method of the eventhorizon.GlobalEventLoader interface.
+func (s *EventStore) LastPosition(ctx context.Context) (int, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	return len(s.positions), nil
+}
+
+// Adds the global positions of saved events.
+func (s *EventStore) addPositions(events []eh.Event) {
+	for _, e := range events {
+		s.positions = append(s.positions, eventPosition{
+			AggregateID: e.AggregateID(),
+			Version:     e.Version(),
+		})
+	}
+}
+
+// eventPosition refers to an event at a global position.
+type eventPosition struct {
+	AggregateID uuid.UUID
+	Version     int
+}
+
 type aggregateRecord struct {
 	AggregateID uuid.UUID
 	Version     int
@@ -258,6 +321,15 @@ func copyEvent(ctx context.Context, event eh.Event) (eh.Event, error) {
 		copier.Copy(data, event.Data())
 	}
 
+	// Copy the metadata, keeping the types of the values.
+	var metadata map[string]interface{}
+	if event.Metadata() != nil {
+		metadata = make(map[string]interface{}, len(event.Metadata()))
+		for k, v := range event.Metadata() {
+			metadata[k] = v
+		}
+	}
+
 	return eh.NewEvent(
 		event.EventType(),
 		data,
@@ -267,6 +339,6 @@ func copyEvent(ctx context.Context, event eh.Event) (eh.Event, error) {
 			event.AggregateID(),
 			event.Version(),
 		),
-		eh.WithMetadata(event.Metadata()),
+		eh.WithMetadata(metadata),
 	), nil
 }
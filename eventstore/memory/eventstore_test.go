@@ -37,6 +37,8 @@ func TestEventStore(t *testing.T) {
 
 	eventstore.AcceptanceTest(t, store, context.Background())
 
+	eventstore.GlobalEventLoaderAcceptanceTest(t, store, context.Background())
+
 	if err := store.Close(); err != nil {
 		t.Error("there should be no error:", err)
 	}
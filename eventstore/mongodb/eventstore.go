@@ -27,7 +27,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 
 	// Register uuid.UUID as BSON type.
-	_ "github.com/reidlai/eventhorizon/codec/bson"
+	bsonCodec "github.com/reidlai/eventhorizon/codec/bson"
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/uuid"
@@ -276,10 +276,8 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 				return nil, err
 			}
 
-			for _, e := range events {
-				if err := s.eventHandlerInTX.HandleEvent(ctx, e); err != nil {
-					return nil, fmt.Errorf("could not handle event in transaction: %w", err)
-				}
+			if err := eh.HandleEvents(ctx, s.eventHandlerInTX, events); err != nil {
+				return nil, fmt.Errorf("could not handle event in transaction: %w", err)
 			}
 
 			return nil, nil
@@ -309,13 +307,8 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 
 	// Let the optional event handler handle the events.
 	if s.eventHandlerAfterSave != nil {
-		for _, e := range events {
-			if err := s.eventHandlerAfterSave.HandleEvent(ctx, e); err != nil {
-				return &eh.EventHandlerError{
-					Err:   err,
-					Event: e,
-				}
-			}
+		if err := eh.HandleEvents(ctx, s.eventHandlerAfterSave, events); err != nil {
+			return err
 		}
 	}
 
@@ -378,6 +371,9 @@ func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([
 			e.RawData = nil
 		}
 
+		// Restore the metadata types lost in BSON.
+		bsonCodec.RestoreMetadata(e.Metadata, e.MetadataTypes)
+
 		event := eh.NewEvent(
 			e.EventType,
 			e.data,
@@ -396,6 +392,22 @@ func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([
 	return events, nil
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (s *EventStore) HealthCheckName() string {
+	return "mongodb eventstore"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by pinging the primary MongoDB server.
+func (s *EventStore) Check(ctx context.Context) error {
+	if err := s.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("could not ping database: %w", err)
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.EventStore interface.
 func (s *EventStore) Close() error {
 	if s.clientOwnership == externalClient {
@@ -426,6 +438,7 @@ type evt struct {
 	AggregateID   uuid.UUID              `bson:"_id"`
 	Version       int                    `bson:"version"`
 	Metadata      map[string]interface{} `bson:"metadata"`
+	MetadataTypes map[string]string      `bson:"metadata_types,omitempty"`
 }
 
 // newEvt returns a new evt for an event.
@@ -437,6 +450,7 @@ func newEvt(ctx context.Context, event eh.Event) (*evt, error) {
 		AggregateID:   event.AggregateID(),
 		Version:       event.Version(),
 		Metadata:      event.Metadata(),
+		MetadataTypes: eh.MetadataTypes(event.Metadata()),
 	}
 
 	// Marshal event data if there is any.
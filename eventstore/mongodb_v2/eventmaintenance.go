@@ -82,6 +82,7 @@ func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
 		}
 		e.Position = eventToReplace.Position
 		e.Metadata["position"] = eventToReplace.Position
+		e.setMetadataType("position", eh.MetadataTypeInt)
 
 		// Find and replace the event.
 		if r, err := s.events.ReplaceOne(ctx, bson.M{
@@ -34,7 +34,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 
 	// Register uuid.UUID as BSON type.
-	_ "github.com/reidlai/eventhorizon/codec/bson"
+	bsonCodec "github.com/reidlai/eventhorizon/codec/bson"
 
 	eh "github.com/reidlai/eventhorizon"
 	"github.com/reidlai/eventhorizon/uuid"
@@ -326,6 +326,7 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 			event.Position = allStream.Position + i + 1
 			// Also store the position in the event metadata.
 			event.Metadata["position"] = event.Position
+			event.setMetadataType("position", eh.MetadataTypeInt)
 
 			// Use the last event to set the new stream position.
 			if i == len(dbEvents)-1 {
@@ -394,10 +395,8 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 		}
 
 		if s.eventHandlerInTX != nil {
-			for _, e := range events {
-				if err := s.eventHandlerInTX.HandleEvent(txCtx, e); err != nil {
-					return nil, fmt.Errorf("could not handle event in transaction: %w", err)
-				}
+			if err := eh.HandleEvents(txCtx, s.eventHandlerInTX, events); err != nil {
+				return nil, fmt.Errorf("could not handle event in transaction: %w", err)
 			}
 		}
 
@@ -415,13 +414,8 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 
 	// Let the optional event handler handle the events.
 	if s.eventHandlerAfterSave != nil {
-		for _, e := range events {
-			if err := s.eventHandlerAfterSave.HandleEvent(ctx, e); err != nil {
-				return &eh.EventHandlerError{
-					Err:   err,
-					Event: e,
-				}
-			}
+		if err := eh.HandleEvents(ctx, s.eventHandlerAfterSave, events); err != nil {
+			return err
 		}
 	}
 
@@ -470,45 +464,18 @@ func (s *EventStore) loadFromCursor(ctx context.Context, id uuid.UUID, cursor *m
 			}
 		}
 
-		// Create an event of the correct type and decode from raw BSON.
-		if len(e.RawData) > 0 {
-			var err error
-			if e.data, err = eh.CreateEventData(e.EventType); err != nil {
-				return nil, &eh.EventStoreError{
-					Err:              fmt.Errorf("could not create event data: %w", err),
-					Op:               eh.EventStoreOpLoad,
-					AggregateType:    e.AggregateType,
-					AggregateID:      id,
-					AggregateVersion: e.Version,
-					Events:           events,
-				}
-			}
-
-			if err := bson.Unmarshal(e.RawData, e.data); err != nil {
-				return nil, &eh.EventStoreError{
-					Err:              fmt.Errorf("could not unmarshal event data: %w", err),
-					Op:               eh.EventStoreOpLoad,
-					AggregateType:    e.AggregateType,
-					AggregateID:      id,
-					AggregateVersion: e.Version,
-					Events:           events,
-				}
+		event, err := e.event()
+		if err != nil {
+			return nil, &eh.EventStoreError{
+				Err:              err,
+				Op:               eh.EventStoreOpLoad,
+				AggregateType:    e.AggregateType,
+				AggregateID:      id,
+				AggregateVersion: e.Version,
+				Events:           events,
 			}
-
-			e.RawData = nil
 		}
 
-		event := eh.NewEvent(
-			e.EventType,
-			e.data,
-			e.Timestamp,
-			eh.ForAggregate(
-				e.AggregateType,
-				e.AggregateID,
-				e.Version,
-			),
-			eh.WithMetadata(e.Metadata),
-		)
 		events = append(events, event)
 	}
 
@@ -523,6 +490,76 @@ func (s *EventStore) loadFromCursor(ctx context.Context, id uuid.UUID, cursor *m
 	return events, nil
 }
 
+// LoadAll implements the LoadAll method of the eventhorizon.GlobalEventLoader interface.
+func (s *EventStore) LoadAll(ctx context.Context, position, limit int) ([]eh.PositionedEvent, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := s.events.Find(ctx, bson.M{"_id": bson.M{"$gt": position}}, opts)
+	if err != nil {
+		return nil, &eh.EventStoreError{
+			Err: fmt.Errorf("could not find events: %w", err),
+			Op:  eh.EventStoreOpLoad,
+		}
+	}
+	defer cursor.Close(ctx)
+
+	var events []eh.PositionedEvent
+
+	for cursor.Next(ctx) {
+		var e evt
+		if err := cursor.Decode(&e); err != nil {
+			return nil, &eh.EventStoreError{
+				Err: fmt.Errorf("could not decode event: %w", err),
+				Op:  eh.EventStoreOpLoad,
+			}
+		}
+
+		event, err := e.event()
+		if err != nil {
+			return nil, &eh.EventStoreError{
+				Err:              err,
+				Op:               eh.EventStoreOpLoad,
+				AggregateType:    e.AggregateType,
+				AggregateID:      e.AggregateID,
+				AggregateVersion: e.Version,
+			}
+		}
+
+		events = append(events, eh.PositionedEvent{
+			Event:    event,
+			Position: e.Position,
+		})
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, &eh.EventStoreError{
+			Err: fmt.Errorf("could not load events: %w", err),
+			Op:  eh.EventStoreOpLoad,
+		}
+	}
+
+	return events, nil
+}
+
+// LastPosition implements the LastPosition method of the eventhorizon.GlobalEventLoader interface.
+func (s *EventStore) LastPosition(ctx context.Context) (int, error) {
+	var allStream struct {
+		Position int `bson:"position"`
+	}
+
+	if err := s.streams.FindOne(ctx, bson.M{"_id": "$all"}).Decode(&allStream); err != nil {
+		return 0, &eh.EventStoreError{
+			Err: fmt.Errorf("could not find the $all stream document: %w", err),
+			Op:  eh.EventStoreOpLoad,
+		}
+	}
+
+	return allStream.Position, nil
+}
+
 func (s *EventStore) LoadSnapshot(ctx context.Context, id uuid.UUID) (*eh.Snapshot, error) {
 	result := s.snapshots.FindOne(ctx, bson.M{"aggregate_id": id}, options.FindOne().SetSort(bson.M{"version": -1}))
 	if err := result.Err(); err != nil {
@@ -626,6 +663,22 @@ func (s *EventStore) SaveSnapshot(ctx context.Context, id uuid.UUID, snapshot eh
 	return nil
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (s *EventStore) HealthCheckName() string {
+	return "mongodb_v2 eventstore"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by pinging the primary MongoDB server.
+func (s *EventStore) Check(ctx context.Context) error {
+	if err := s.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("could not ping database: %w", err)
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.EventStore interface.
 func (s *EventStore) Close() error {
 	if s.clientOwnership == externalClient {
@@ -699,6 +752,7 @@ type evt struct {
 	RawData       bson.Raw               `bson:"data,omitempty"`
 	data          eh.EventData           `bson:"-"`
 	Metadata      map[string]interface{} `bson:"metadata"`
+	MetadataTypes map[string]string      `bson:"metadata_types,omitempty"`
 }
 
 // newEvt returns a new evt for an event.
@@ -710,10 +764,13 @@ func newEvt(_ context.Context, event eh.Event) (*evt, error) {
 		AggregateID:   event.AggregateID(),
 		Version:       event.Version(),
 		Metadata:      event.Metadata(),
+		MetadataTypes: eh.MetadataTypes(event.Metadata()),
 	}
 
-	if e.Metadata == nil {
-		e.Metadata = map[string]interface{}{}
+	// Copy the metadata, as the position is added to it when saving.
+	e.Metadata = make(map[string]interface{}, len(event.Metadata())+1)
+	for k, v := range event.Metadata() {
+		e.Metadata[k] = v
 	}
 
 	// Marshal event data if there is any.
@@ -730,3 +787,43 @@ func newEvt(_ context.Context, event eh.Event) (*evt, error) {
 
 	return e, nil
 }
+
+// setMetadataType sets the type of a metadata value set after creation.
+func (e *evt) setMetadataType(key, t string) {
+	if e.MetadataTypes == nil {
+		e.MetadataTypes = map[string]string{}
+	}
+
+	e.MetadataTypes[key] = t
+}
+
+// event creates an event of the correct type, decoded from raw BSON.
+func (e *evt) event() (eh.Event, error) {
+	if len(e.RawData) > 0 {
+		var err error
+		if e.data, err = eh.CreateEventData(e.EventType); err != nil {
+			return nil, fmt.Errorf("could not create event data: %w", err)
+		}
+
+		if err := bson.Unmarshal(e.RawData, e.data); err != nil {
+			return nil, fmt.Errorf("could not unmarshal event data: %w", err)
+		}
+
+		e.RawData = nil
+	}
+
+	// Restore the metadata types lost in BSON.
+	bsonCodec.RestoreMetadata(e.Metadata, e.MetadataTypes)
+
+	return eh.NewEvent(
+		e.EventType,
+		e.data,
+		e.Timestamp,
+		eh.ForAggregate(
+			e.AggregateType,
+			e.AggregateID,
+			e.Version,
+		),
+		eh.WithMetadata(e.Metadata),
+	), nil
+}
@@ -64,6 +64,8 @@ func TestEventStoreIntegration(t *testing.T) {
 
 	eventstore.SnapshotAcceptanceTest(t, store, context.Background())
 
+	eventstore.GlobalEventLoaderAcceptanceTest(t, store, context.Background())
+
 	if err := store.Close(); err != nil {
 		t.Error("there should be no error:", err)
 	}
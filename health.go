@@ -0,0 +1,30 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+)
+
+// HealthChecker is an optional interface for components that can check the
+// health of their dependencies, like connections to databases and brokers, for
+// example to be used in readiness probes.
+type HealthChecker interface {
+	// HealthCheckName returns the name of the component, used in reports.
+	HealthCheckName() string
+
+	// Check returns an error if the component is not healthy.
+	Check(context.Context) error
+}
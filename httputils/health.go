@@ -0,0 +1,89 @@
+// Copyright (c) 2014 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	eh "github.com/reidlai/eventhorizon"
+)
+
+// HealthReport is the JSON report written by the HealthHandler.
+type HealthReport struct {
+	Healthy bool                `json:"healthy"`
+	Checks  []HealthCheckResult `json:"checks"`
+}
+
+// HealthCheckResult is the result of checking a single component.
+type HealthCheckResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthHandler is a HTTP handler that checks all the health checkers and
+// writes the results as a JSON HealthReport. It responds with status 200 if
+// all components are healthy, otherwise with 503, which makes it suitable to use
+// for readiness probes.
+func HealthHandler(checkers ...eh.HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		report := HealthReport{
+			Healthy: true,
+			Checks:  make([]HealthCheckResult, 0, len(checkers)),
+		}
+
+		for _, c := range checkers {
+			result := HealthCheckResult{
+				Name:    c.HealthCheckName(),
+				Healthy: true,
+			}
+
+			if err := c.Check(r.Context()); err != nil {
+				result.Healthy = false
+				result.Error = err.Error()
+				report.Healthy = false
+			}
+
+			report.Checks = append(report.Checks, result)
+		}
+
+		b, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, "could not encode health report: "+err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		if _, err := w.Write(b); err != nil {
+			log.Printf("eventhorizon: could not write health report: %s", err)
+		}
+	})
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Names of the metadata value types returned by MetadataTypes.
+const (
+	MetadataTypeInt     = "int"
+	MetadataTypeInt8    = "int8"
+	MetadataTypeInt16   = "int16"
+	MetadataTypeInt32   = "int32"
+	MetadataTypeInt64   = "int64"
+	MetadataTypeUint    = "uint"
+	MetadataTypeUint8   = "uint8"
+	MetadataTypeUint16  = "uint16"
+	MetadataTypeUint32  = "uint32"
+	MetadataTypeUint64  = "uint64"
+	MetadataTypeFloat32 = "float32"
+	MetadataTypeTime    = "time"
+)
+
+// MetadataTypes returns the type names of the top level metadata values that
+// would change type when marshaled to JSON or BSON, which are integers,
+// float32 and time.Time. Codecs and stores should save the types along with
+// the metadata, and use RestoreMetadataTypes when loading it so that consumers
+// get the same types as were set when creating the event. Returns nil if no
+// values need their types saved.
+func MetadataTypes(metadata map[string]interface{}) map[string]string {
+	var types map[string]string
+
+	for k, v := range metadata {
+		var t string
+
+		switch v.(type) {
+		case int:
+			t = MetadataTypeInt
+		case int8:
+			t = MetadataTypeInt8
+		case int16:
+			t = MetadataTypeInt16
+		case int32:
+			t = MetadataTypeInt32
+		case int64:
+			t = MetadataTypeInt64
+		case uint:
+			t = MetadataTypeUint
+		case uint8:
+			t = MetadataTypeUint8
+		case uint16:
+			t = MetadataTypeUint16
+		case uint32:
+			t = MetadataTypeUint32
+		case uint64:
+			t = MetadataTypeUint64
+		case float32:
+			t = MetadataTypeFloat32
+		case time.Time:
+			t = MetadataTypeTime
+		default:
+			continue
+		}
+
+		if types == nil {
+			types = map[string]string{}
+		}
+
+		types[k] = t
+	}
+
+	return types
+}
+
+// RestoreMetadataTypes converts the metadata values in place back to the types
+// returned by MetadataTypes when the metadata was saved. Values are converted
+// from any numeric type, json.Number and BSON date times, as well as from
+// RFC 3339 strings for times. Values that can not be converted are left as is.
+//
+// Any other json.Number values, also in nested maps and slices, are converted
+// to float64 as when decoding JSON without json.Decoder.UseNumber, which lets
+// codecs decode metadata with UseNumber to not lose precision of large integers.
+func RestoreMetadataTypes(metadata map[string]interface{}, types map[string]string) {
+	for k, v := range metadata {
+		if t, ok := types[k]; ok {
+			if v, ok := restoreMetadataType(v, t); ok {
+				metadata[k] = v
+
+				continue
+			}
+		}
+
+		metadata[k] = numbersToFloats(v)
+	}
+}
+
+// Converts all json.Number values to float64, also in nested maps and slices.
+func numbersToFloats(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	case map[string]interface{}:
+		for k, vv := range v {
+			v[k] = numbersToFloats(vv)
+		}
+	case []interface{}:
+		for i, vv := range v {
+			v[i] = numbersToFloats(vv)
+		}
+	}
+
+	return v
+}
+
+func restoreMetadataType(v interface{}, t string) (interface{}, bool) {
+	switch t {
+	case MetadataTypeTime:
+		return metadataTime(v)
+	case MetadataTypeFloat32:
+		f, ok := metadataFloat(v)
+
+		return float32(f), ok
+	case MetadataTypeUint, MetadataTypeUint8, MetadataTypeUint16, MetadataTypeUint32, MetadataTypeUint64:
+		u, ok := metadataUint(v)
+		if !ok {
+			return nil, false
+		}
+
+		switch t {
+		case MetadataTypeUint:
+			return uint(u), true
+		case MetadataTypeUint8:
+			return uint8(u), true
+		case MetadataTypeUint16:
+			return uint16(u), true
+		case MetadataTypeUint32:
+			return uint32(u), true
+		default:
+			return u, true
+		}
+	}
+
+	i, ok := metadataInt(v)
+	if !ok {
+		return nil, false
+	}
+
+	switch t {
+	case MetadataTypeInt:
+		return int(i), true
+	case MetadataTypeInt8:
+		return int8(i), true
+	case MetadataTypeInt16:
+		return int16(i), true
+	case MetadataTypeInt32:
+		return int32(i), true
+	case MetadataTypeInt64:
+		return i, true
+	default:
+		return nil, false
+	}
+}
+
+// Converts any integral numeric value to an int64.
+func metadataInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint, uint8, uint16, uint32, uint64:
+		u, _ := metadataUint(v)
+		if u > math.MaxInt64 {
+			return 0, false
+		}
+
+		return int64(u), true
+	case float32:
+		return metadataInt(float64(v))
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+
+		return int64(v), true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+
+		if f, err := v.Float64(); err == nil {
+			return metadataInt(f)
+		}
+	}
+
+	return 0, false
+}
+
+// Converts any non-negative integral numeric value to an uint64.
+func metadataUint(v interface{}) (uint64, bool) {
+	switch v := v.(type) {
+	case uint:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case json.Number:
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u, true
+		}
+	case float64:
+		if v >= 0 && v < math.MaxUint64 && v == math.Trunc(v) {
+			return uint64(v), true
+		}
+
+		return 0, false
+	}
+
+	if i, ok := metadataInt(v); ok && i >= 0 {
+		return uint64(i), true
+	}
+
+	return 0, false
+}
+
+// Converts any numeric value to a float64.
+func metadataFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+
+		return f, err == nil
+	}
+
+	if i, ok := metadataInt(v); ok {
+		return float64(i), true
+	}
+
+	if u, ok := metadataUint(v); ok {
+		return float64(u), true
+	}
+
+	return 0, false
+}
+
+// Converts a time, RFC 3339 string or BSON date time (or any other type with a
+// Time method, converted to UTC) to a time.Time.
+func metadataTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+
+		return t, err == nil
+	case interface{ Time() time.Time }:
+		return v.Time().UTC(), true
+	}
+
+	return time.Time{}, false
+}
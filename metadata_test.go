@@ -0,0 +1,132 @@
+// Copyright (c) 2021 - The Event Horizon authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventMetadataAccessors(t *testing.T) {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := NewEvent(TestEventType, nil, timestamp,
+		WithGlobalPosition(3),
+		WithMetadata(map[string]interface{}{
+			"float":     42.0,
+			"fraction":  1.5,
+			"number":    json.Number("7"),
+			"string":    "string",
+			"time":      timestamp,
+			"timestr":   timestamp.Format(time.RFC3339Nano),
+			"notnumber": "42",
+		}),
+	)
+
+	if v, ok := event.MetadataInt("position"); !ok || v != 3 {
+		t.Error("the int should be correct:", v, ok)
+	}
+
+	if v, ok := event.MetadataInt("float"); !ok || v != 42 {
+		t.Error("the int should be correct:", v, ok)
+	}
+
+	if v, ok := event.MetadataInt("number"); !ok || v != 7 {
+		t.Error("the int should be correct:", v, ok)
+	}
+
+	if _, ok := event.MetadataInt("fraction"); ok {
+		t.Error("a fraction should not be an int")
+	}
+
+	if _, ok := event.MetadataInt("notnumber"); ok {
+		t.Error("a string should not be an int")
+	}
+
+	if _, ok := event.MetadataInt("missing"); ok {
+		t.Error("a missing value should not be an int")
+	}
+
+	if v, ok := event.MetadataFloat("fraction"); !ok || v != 1.5 {
+		t.Error("the float should be correct:", v, ok)
+	}
+
+	if v, ok := event.MetadataFloat("position"); !ok || v != 3 {
+		t.Error("the float should be correct:", v, ok)
+	}
+
+	if v, ok := event.MetadataString("string"); !ok || v != "string" {
+		t.Error("the string should be correct:", v, ok)
+	}
+
+	if _, ok := event.MetadataString("float"); ok {
+		t.Error("a float should not be a string")
+	}
+
+	if v, ok := event.MetadataTime("time"); !ok || !v.Equal(timestamp) {
+		t.Error("the time should be correct:", v, ok)
+	}
+
+	if v, ok := event.MetadataTime("timestr"); !ok || !v.Equal(timestamp) {
+		t.Error("the time should be correct:", v, ok)
+	}
+}
+
+func TestRestoreMetadataTypes(t *testing.T) {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	metadata := map[string]interface{}{
+		"int":     42,
+		"int64":   int64(1) << 60,
+		"uint64":  uint64(1) << 63,
+		"float32": float32(0.5),
+		"float":   1.5,
+		"time":    timestamp,
+		"string":  "string",
+	}
+
+	types := MetadataTypes(metadata)
+	expectedTypes := map[string]string{
+		"int":     MetadataTypeInt,
+		"int64":   MetadataTypeInt64,
+		"uint64":  MetadataTypeUint64,
+		"float32": MetadataTypeFloat32,
+		"time":    MetadataTypeTime,
+	}
+
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Error("the types should be correct:", types)
+	}
+
+	// Simulate decoding from JSON with json.Number.
+	decoded := map[string]interface{}{
+		"int":     json.Number("42"),
+		"int64":   json.Number("1152921504606846976"),
+		"uint64":  json.Number("9223372036854775808"),
+		"float32": json.Number("0.5"),
+		"float":   json.Number("1.5"),
+		"time":    timestamp.Format(time.RFC3339Nano),
+		"string":  "string",
+		"nested":  map[string]interface{}{"num": json.Number("2")},
+	}
+
+	RestoreMetadataTypes(decoded, types)
+
+	metadata["nested"] = map[string]interface{}{"num": 2.0}
+
+	if !reflect.DeepEqual(decoded, metadata) {
+		t.Errorf("the metadata should be restored: %#v", decoded)
+	}
+}
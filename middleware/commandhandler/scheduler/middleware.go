@@ -56,7 +56,7 @@ func (c *command) ExecuteAt() time.Time {
 }
 
 // NewMiddleware returns a new command handler middleware and a scheduler helper.
-func NewMiddleware(repo eh.ReadWriteRepo, codec eh.CommandCodec) (eh.CommandHandlerMiddleware, *Scheduler) {
+func NewMiddleware(repo eh.ReadWriteRepo, codec eh.CommandCodec, options ...Option) (eh.CommandHandlerMiddleware, *Scheduler) {
 	s := &Scheduler{
 		repo:             repo,
 		cmdCh:            make(chan *scheduledCommand, ScheduledCommandsQueueSize),
@@ -65,6 +65,15 @@ func NewMiddleware(repo eh.ReadWriteRepo, codec eh.CommandCodec) (eh.CommandHand
 		codec:            codec,
 	}
 
+	// Apply configuration options.
+	for _, option := range options {
+		if option == nil {
+			continue
+		}
+
+		option(s)
+	}
+
 	return eh.CommandHandlerMiddleware(func(h eh.CommandHandler) eh.CommandHandler {
 		s.setHandler(h)
 
@@ -89,6 +98,18 @@ func NewMiddleware(repo eh.ReadWriteRepo, codec eh.CommandCodec) (eh.CommandHand
 	}), s
 }
 
+// Option is an option setter used to configure creation.
+type Option func(*Scheduler)
+
+// WithErrorHandler calls the error handler with all errors from handling of
+// scheduled commands. Errors are then only sent on the errors channel if it is
+// not full, instead of blocking until they are received.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(s *Scheduler) {
+		s.errorHandler = h
+	}
+}
+
 // PersistedCommand is a persisted command.
 type PersistedCommand struct {
 	ID         uuid.UUID       `json:"_"                 bson:"_id"`
@@ -113,6 +134,7 @@ type Scheduler struct {
 	cancelScheduling   map[uuid.UUID]chan struct{}
 	cancelSchedulingMu sync.Mutex
 	errCh              chan error
+	errorHandler       eh.ErrorHandler
 	cctx               context.Context
 	cancel             context.CancelFunc
 	done               chan struct{}
@@ -318,35 +340,34 @@ loop:
 					// Stop without removing persisted cmd.
 				case <-t.C:
 					if err := s.h.HandleCommand(sc.ctx, sc.cmd); err != nil {
-						// Always try to deliver errors.
-						s.errCh <- &Error{
+						s.sendError(&Error{
 							Err:     err,
 							Ctx:     sc.ctx,
 							Command: sc.cmd,
-						}
+						})
 					}
 
 					if err := s.repo.Remove(sc.ctx, sc.id); err != nil {
-						s.errCh <- &Error{
+						s.sendError(&Error{
 							Err:     fmt.Errorf("could not remove persisted command: %w", err),
 							Ctx:     sc.ctx,
 							Command: sc.cmd,
-						}
+						})
 					}
 				case <-cancel:
 					if err := s.repo.Remove(sc.ctx, sc.id); err != nil {
-						s.errCh <- &Error{
+						s.sendError(&Error{
 							Err:     fmt.Errorf("could not remove persisted command: %w", err),
 							Ctx:     sc.ctx,
 							Command: sc.cmd,
-						}
+						})
 					}
 
-					s.errCh <- &Error{
+					s.sendError(&Error{
 						Err:     ErrCanceled,
 						Ctx:     sc.ctx,
 						Command: sc.cmd,
-					}
+					})
 				}
 			}(cancel)
 		}
@@ -357,6 +378,23 @@ loop:
 	close(s.done)
 }
 
+// Sends the error to the error handler, if any, and on the errors channel.
+func (s *Scheduler) sendError(err *Error) {
+	if s.errorHandler == nil {
+		// Always try to deliver errors.
+		s.errCh <- err
+
+		return
+	}
+
+	s.errorHandler.HandleError(err)
+
+	select {
+	case s.errCh <- err:
+	default:
+	}
+}
+
 // Error is an async error containing the error and the command.
 type Error struct {
 	// Err is the error that happened when handling the command.
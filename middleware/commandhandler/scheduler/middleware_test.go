@@ -317,6 +317,54 @@ func TestMiddleware_Errors(t *testing.T) {
 	}
 }
 
+func TestMiddleware_ErrorHandler(t *testing.T) {
+	repo := &mocks.Repo{}
+	errs := make(chan error, 1)
+	m, s := NewMiddleware(repo, &json.CommandCodec{}, WithErrorHandler(eh.ErrorHandlerFunc(func(err error) {
+		errs <- err
+	})))
+
+	handlerErr := errors.New("handler error")
+	inner := &mocks.CommandHandler{
+		Err: handlerErr,
+	}
+
+	h := eh.UseCommandHandlerMiddleware(inner, m)
+
+	if err := s.Start(); err != nil {
+		t.Fatal("could not start scheduler:", err)
+	}
+
+	cmd := &mocks.Command{
+		ID:      uuid.New(),
+		Content: "content",
+	}
+	c := CommandWithExecuteTime(cmd, time.Now().Add(5*time.Millisecond))
+
+	if err := h.HandleCommand(context.Background(), c); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-time.After(time.Second):
+	}
+
+	var schedulerErr *Error
+	if !errors.As(err, &schedulerErr) || !errors.Is(err, handlerErr) {
+		t.Error("there should be an error:", err)
+	}
+
+	if schedulerErr != nil && schedulerErr.Command != cmd {
+		t.Error("the command should be correct:", schedulerErr.Command)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal("could not stop scheduler:", err)
+	}
+}
+
 func TestMiddleware_Cancel(t *testing.T) {
 	repo := memory.NewRepo()
 
@@ -23,17 +23,41 @@ import (
 
 // NewMiddleware returns a new async handling middleware that returns any errors
 // on a error channel.
-func NewMiddleware() (eh.EventHandlerMiddleware, chan *Error) {
+func NewMiddleware(options ...Option) (eh.EventHandlerMiddleware, chan *Error) {
 	errCh := make(chan *Error, 20)
 
 	return eh.EventHandlerMiddleware(func(h eh.EventHandler) eh.EventHandler {
-		return &eventHandler{h, errCh}
+		handler := &eventHandler{EventHandler: h, errCh: errCh}
+
+		// Apply configuration options.
+		for _, option := range options {
+			if option == nil {
+				continue
+			}
+
+			option(handler)
+		}
+
+		return handler
 	}), errCh
 }
 
+// Option is an option setter used to configure creation.
+type Option func(*eventHandler)
+
+// WithErrorHandler calls the error handler with all errors from handling of
+// events. Errors are then only sent on the errors channel if it is not full,
+// instead of blocking until they are received.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(handler *eventHandler) {
+		handler.errorHandler = h
+	}
+}
+
 type eventHandler struct {
 	eh.EventHandler
-	errCh chan *Error
+	errCh        chan *Error
+	errorHandler eh.ErrorHandler
 }
 
 // InnerHandler implements EventHandlerChain
@@ -45,14 +69,35 @@ func (h *eventHandler) InnerHandler() eh.EventHandler {
 func (h *eventHandler) HandleEvent(ctx context.Context, event eh.Event) error {
 	go func() {
 		if err := h.EventHandler.HandleEvent(ctx, event); err != nil {
-			// Always try to deliver errors.
-			h.errCh <- &Error{err, ctx, event}
+			h.sendError(&Error{
+				Err:         err,
+				Ctx:         ctx,
+				Event:       event,
+				HandlerType: h.EventHandler.HandlerType(),
+			})
 		}
 	}()
 
 	return nil
 }
 
+// Sends the error to the error handler, if any, and on the errors channel.
+func (h *eventHandler) sendError(err *Error) {
+	if h.errorHandler == nil {
+		// Always try to deliver errors.
+		h.errCh <- err
+
+		return
+	}
+
+	h.errorHandler.HandleError(err)
+
+	select {
+	case h.errCh <- err:
+	default:
+	}
+}
+
 // Error is an async error containing the error and the event.
 type Error struct {
 	// Err is the error that happened when handling the event.
@@ -61,6 +106,8 @@ type Error struct {
 	Ctx context.Context
 	// Event is the event handeled when the error happened.
 	Event eh.Event
+	// HandlerType is the type of the handler that failed.
+	HandlerType eh.EventHandlerType
 }
 
 // Error implements the Error method of the error interface.
@@ -91,3 +91,38 @@ func TestMiddleware(t *testing.T) {
 		t.Error("the event should not have been handeled:", inner.Events)
 	}
 }
+
+func TestMiddlewareErrorHandler(t *testing.T) {
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, time.Now(),
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	errs := make(chan error, 1)
+	inner := mocks.NewEventHandler("test")
+	inner.Err = errors.New("handling error")
+	m, _ := NewMiddleware(WithErrorHandler(eh.ErrorHandlerFunc(func(err error) {
+		errs <- err
+	})))
+	h := eh.UseEventHandlerMiddleware(inner, m)
+
+	if err := h.HandleEvent(context.Background(), event); err != nil {
+		t.Error("there should never be an error:", err)
+	}
+
+	select {
+	case err := <-errs:
+		var asyncErr *Error
+		if !errors.As(err, &asyncErr) || !errors.Is(err, inner.Err) {
+			t.Fatal("the error should be correct:", err)
+		}
+
+		if asyncErr.HandlerType != inner.HandlerType() {
+			t.Error("the handler type should be correct:", asyncErr.HandlerType)
+		}
+
+		if asyncErr.Event != event {
+			t.Error("the event should be correct:", asyncErr.Event)
+		}
+	case <-time.After(time.Second):
+		t.Error("there should be an error")
+	}
+}
@@ -53,3 +53,22 @@ func (h *eventHandler) IsEphemeralHandler() bool {
 func (h *eventHandler) InnerHandler() eh.EventHandler {
 	return h.EventHandler
 }
+
+// IsEphemeral traverses the middleware chain of a handler and checks for the
+// ephemeral middleware and queries its status.
+func IsEphemeral(h eh.EventHandler) bool {
+	for h != nil {
+		if e, ok := h.(EphemeralHandler); ok {
+			return e.IsEphemeralHandler()
+		}
+
+		c, ok := h.(eh.EventHandlerChain)
+		if !ok {
+			return false
+		}
+
+		h = c.InnerHandler()
+	}
+
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/middleware/eventhandler/observer"
 	"github.com/reidlai/eventhorizon/mocks"
 )
 
@@ -15,3 +16,20 @@ func TestInnerHandler(t *testing.T) {
 		t.Error("handler is not an EventHandlerChain")
 	}
 }
+
+func TestIsEphemeral(t *testing.T) {
+	h := mocks.NewEventHandler("test")
+	if IsEphemeral(h) {
+		t.Error("the handler should not be ephemeral")
+	}
+
+	if !IsEphemeral(NewMiddleware()(h)) {
+		t.Error("the handler should be ephemeral")
+	}
+
+	// Wrapped by other middleware.
+	wrapped := eh.UseEventHandlerMiddleware(h, observer.Middleware, NewMiddleware())
+	if !IsEphemeral(wrapped) {
+		t.Error("the wrapped handler should be ephemeral")
+	}
+}
@@ -105,6 +105,39 @@ func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([
 	return store.LoadFrom(ctx, id, version)
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (s *EventStore) HealthCheckName() string {
+	return "namespace eventstore"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by checking the event store of every created namespace that
+// supports it.
+func (s *EventStore) Check(ctx context.Context) error {
+	s.eventStoresMu.RLock()
+	defer s.eventStoresMu.RUnlock()
+
+	var errStrs []string
+
+	for ns, store := range s.eventStores {
+		hc, ok := store.(eh.HealthChecker)
+		if !ok {
+			continue
+		}
+
+		if err := hc.Check(ctx); err != nil {
+			errStrs = append(errStrs, fmt.Sprintf("namespace '%s': %s", ns, err))
+		}
+	}
+
+	if len(errStrs) > 0 {
+		return fmt.Errorf("multiple errors: %s", strings.Join(errStrs, ", "))
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.EventStore interface.
 func (s *EventStore) Close() error {
 	s.eventStoresMu.RLock()
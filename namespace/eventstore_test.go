@@ -16,6 +16,8 @@ package namespace
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"sync"
 	"testing"
 
@@ -79,3 +81,50 @@ func TestEventStore(t *testing.T) {
 		t.Error("there should be no error:", err)
 	}
 }
+
+type checkedEventStore struct {
+	eh.EventStore
+	err error
+}
+
+func (s *checkedEventStore) HealthCheckName() string {
+	return "checked"
+}
+
+func (s *checkedEventStore) Check(ctx context.Context) error {
+	return s.err
+}
+
+func TestEventStoreCheck(t *testing.T) {
+	checkErr := errors.New("check error")
+
+	store := NewEventStore(func(ns string) (eh.EventStore, error) {
+		s, err := memory.NewEventStore()
+		if err != nil {
+			return nil, err
+		}
+
+		if ns == "other" {
+			return &checkedEventStore{EventStore: s, err: checkErr}, nil
+		}
+
+		return &checkedEventStore{EventStore: s}, nil
+	})
+
+	if err := store.PreRegisterNamespace(DefaultNamespace); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := store.Check(context.Background()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if err := store.PreRegisterNamespace("other"); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	err := store.Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "namespace 'other': check error") {
+		t.Error("there should be a check error for the other namespace:", err)
+	}
+}
@@ -16,6 +16,7 @@ package namespace
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -117,6 +118,53 @@ func (o *Outbox) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHa
 	return nil
 }
 
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface. The handler is removed from all
+// namespace outboxes, which must also support removing handlers.
+func (o *Outbox) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	o.handlersMu.Lock()
+	defer o.handlersMu.Unlock()
+
+	mh, ok := o.handlersByType[handlerType]
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(o.handlersByType, handlerType)
+
+	for i, h := range o.handlers {
+		if h == mh {
+			o.handlers = append(o.handlers[:i], o.handlers[i+1:]...)
+
+			break
+		}
+	}
+
+	o.outboxesMu.RLock()
+	defer o.outboxesMu.RUnlock()
+
+	var errStrs []string
+
+	for ns, ob := range o.outboxes {
+		r, ok := ob.(eh.EventHandlerRemover)
+		if !ok {
+			errStrs = append(errStrs, fmt.Sprintf("namespace '%s': %s", ns, eh.ErrRemoveHandlerNotSupported))
+
+			continue
+		}
+
+		if err := r.RemoveHandler(ctx, handlerType); err != nil && !errors.Is(err, eh.ErrHandlerNotFound) {
+			errStrs = append(errStrs, fmt.Sprintf("namespace '%s': %s", ns, err))
+		}
+	}
+
+	if len(errStrs) > 0 {
+		return fmt.Errorf("could not remove handler: %s", strings.Join(errStrs, ", "))
+	}
+
+	return nil
+}
+
 // PreRegisterNamespace will make sure that a namespace exists in the outbox
 // and that processing of that namespace is active. In normal cases the outbox
 // for a namespace is started when an event for that namespace is first seen.
@@ -149,6 +197,38 @@ func (o *Outbox) Start() {
 	}
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (o *Outbox) HealthCheckName() string {
+	return "namespace outbox"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by checking the outbox of every created namespace that supports it.
+func (o *Outbox) Check(ctx context.Context) error {
+	o.outboxesMu.RLock()
+	defer o.outboxesMu.RUnlock()
+
+	var errStrs []string
+
+	for ns, ob := range o.outboxes {
+		hc, ok := ob.(eh.HealthChecker)
+		if !ok {
+			continue
+		}
+
+		if err := hc.Check(ctx); err != nil {
+			errStrs = append(errStrs, fmt.Sprintf("namespace '%s': %s", ns, err))
+		}
+	}
+
+	if len(errStrs) > 0 {
+		return fmt.Errorf("multiple errors: %s", strings.Join(errStrs, ", "))
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.Outbox interface.
 func (o *Outbox) Close() error {
 	o.outboxesMu.RLock()
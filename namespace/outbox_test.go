@@ -94,3 +94,24 @@ func TestOutbox(t *testing.T) {
 		t.Error("there should be no error:", err)
 	}
 }
+
+func TestOutboxRemoveHandler(t *testing.T) {
+	o := NewOutbox(func(ns string) (eh.Outbox, error) {
+		return memory.NewOutbox()
+	})
+	if o == nil {
+		t.Fatal("there should be an outbox")
+	}
+
+	o.Start()
+
+	if err := o.PreRegisterNamespace(DefaultNamespace); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	outbox.TestRemoveHandler(t, o, context.Background())
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
@@ -134,6 +134,38 @@ func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
 	return repo.Remove(ctx, id)
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (r *Repo) HealthCheckName() string {
+	return "namespace repo"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by checking the repo of every created namespace that supports it.
+func (r *Repo) Check(ctx context.Context) error {
+	r.reposMu.RLock()
+	defer r.reposMu.RUnlock()
+
+	var errStrs []string
+
+	for ns, repo := range r.repos {
+		hc, ok := repo.(eh.HealthChecker)
+		if !ok {
+			continue
+		}
+
+		if err := hc.Check(ctx); err != nil {
+			errStrs = append(errStrs, fmt.Sprintf("namespace '%s': %s", ns, err))
+		}
+	}
+
+	if len(errStrs) > 0 {
+		return fmt.Errorf("multiple errors: %s", strings.Join(errStrs, ", "))
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Close() error {
 	r.reposMu.RLock()
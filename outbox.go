@@ -50,6 +50,11 @@ type OutboxError struct {
 	Ctx context.Context
 	// Event is the event handeled when the error happened.
 	Event Event
+	// HandlerType is the type of the handler that failed, if any.
+	HandlerType EventHandlerType
+	// Retryable is true if the failed operation will be retried, for example
+	// by delivering the event to the handler again.
+	Retryable bool
 }
 
 // Error implements the Error method of the errors.Error interface.
@@ -47,6 +47,83 @@ func TestAddHandler(t *testing.T, o eh.Outbox, ctx context.Context) {
 	}
 }
 
+// TestRemoveHandler tests removing handlers for implementations of Outbox which
+// also implements eh.EventHandlerRemover. The outbox should be started.
+func TestRemoveHandler(t *testing.T, o eh.Outbox, ctx context.Context) {
+	r, ok := o.(eh.EventHandlerRemover)
+	if !ok {
+		t.Fatal("the outbox should be an eh.EventHandlerRemover")
+	}
+
+	// Error on non-added handler.
+	if err := r.RemoveHandler(ctx, "not-added"); err != eh.ErrHandlerNotFound {
+		t.Error("the error should be correct:", err)
+	}
+
+	removedHandler := mocks.NewEventHandler("removed")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, removedHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	otherHandler := mocks.NewEventHandler("other")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, otherHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := r.RemoveHandler(ctx, removedHandler.HandlerType()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Error on already removed handler.
+	if err := r.RemoveHandler(ctx, removedHandler.HandlerType()); err != eh.ErrHandlerNotFound {
+		t.Error("the error should be correct:", err)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := o.HandleEvent(ctx, event1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !otherHandler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	if removedHandler.Wait(100 * time.Millisecond) {
+		t.Error("the removed handler should not receive the event")
+	}
+
+	// Adding the same handler type again should be possible.
+	addedHandler := mocks.NewEventHandler("removed")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, addedHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := o.HandleEvent(ctx, event2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !addedHandler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	addedHandler.Lock()
+
+	if !eh.CompareEventSlices(addedHandler.Events, []eh.Event{event2}) {
+		t.Error("the events were incorrect:")
+		t.Log(addedHandler.Events)
+	}
+
+	addedHandler.Unlock()
+
+	checkOutboxErrors(t, o)
+}
+
 // AcceptanceTest is the acceptance test that all implementations of Outbox
 // should pass. It should manually be called from a test case in each
 // implementation:
@@ -23,6 +23,9 @@ var (
 	// to be processed by the periodic sweep.
 	PeriodicSweepAge   = 15 * time.Second
 	PeriodicCleanupAge = 10 * time.Minute
+
+	// Interval in which to compact the write-ahead log, if used.
+	PeriodicWALCompactionInterval = 5 * time.Minute
 )
 
 // Outbox implements an eventhorizon.Outbox for MongoDB.
@@ -34,11 +37,14 @@ type Outbox struct {
 	handlersMu     sync.RWMutex
 	watchCh        chan *outboxDoc
 	errCh          chan error
+	errorHandler   eh.ErrorHandler
 	processingMu   sync.Mutex
 	cctx           context.Context
 	cancel         context.CancelFunc
 	wg             sync.WaitGroup
 	codec          eh.EventCodec
+	wal            *wal
+	purgeOnRemove  bool
 }
 
 type matcherHandler struct {
@@ -46,8 +52,8 @@ type matcherHandler struct {
 	eh.EventHandler
 }
 
-// NewOutbox creates a new Outbox with a MongoDB URI: `mongodb://hostname`.
-func NewOutbox() (*Outbox, error) {
+// NewOutbox creates a new Outbox.
+func NewOutbox(options ...Option) (*Outbox, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	o := &Outbox{
@@ -60,9 +66,36 @@ func NewOutbox() (*Outbox, error) {
 		codec:          &bsonCodec.EventCodec{},
 	}
 
+	for _, option := range options {
+		if err := option(o); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
 	return o, nil
 }
 
+// Option is an option setter used to configure creation.
+type Option func(*Outbox) error
+
+// WithWAL persists the outbox in a write-ahead log at the path, which makes
+// unprocessed events survive restarts. All new events and handler completions
+// are recorded in the log, which is replayed on Start() and compacted
+// periodically. Events are stored using the BSON codec, including any context
+// values registered with eh.RegisterContextMarshaler.
+func WithWAL(path string) Option {
+	return func(o *Outbox) error {
+		w, err := openWAL(path)
+		if err != nil {
+			return err
+		}
+
+		o.wal = w
+
+		return nil
+	}
+}
+
 // HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
 func (o *Outbox) HandlerType() eh.EventHandlerType {
 	return "outbox"
@@ -92,6 +125,118 @@ func (o *Outbox) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHa
 	return nil
 }
 
+// WithPurgeOnRemove removes a handler from all pending events when it is
+// removed with RemoveHandler. Otherwise the pending events are kept, and will
+// be handled if the handler is added again.
+func WithPurgeOnRemove() Option {
+	return func(o *Outbox) error {
+		o.purgeOnRemove = true
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(o *Outbox) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		o.errorHandler = h
+
+		return nil
+	}
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface. It waits for any ongoing
+// processing of events to finish.
+func (o *Outbox) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	o.handlersMu.Lock()
+
+	if _, ok := o.handlersByType[handlerType]; !ok {
+		o.handlersMu.Unlock()
+
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(o.handlersByType, handlerType)
+
+	for i, mh := range o.handlers {
+		if mh.HandlerType() == handlerType {
+			o.handlers = append(o.handlers[:i], o.handlers[i+1:]...)
+
+			break
+		}
+	}
+
+	o.handlersMu.Unlock()
+
+	// Processing is done while holding the DB lock.
+	o.dbMu.Lock()
+	defer o.dbMu.Unlock()
+
+	if !o.purgeOnRemove {
+		return nil
+	}
+
+	for _, r := range o.db {
+		if !containsHandler(r.Handlers, handlerType.String()) {
+			continue
+		}
+
+		if o.wal != nil {
+			if err := o.wal.append(&walRecord{
+				Op:       walOpHandled,
+				ID:       r.ID.String(),
+				Handlers: []string{handlerType.String()},
+			}); err != nil {
+				return fmt.Errorf("could not purge handler: %w", err)
+			}
+		}
+
+		r.Handlers = removeHandlers(r.Handlers, []string{handlerType.String()})
+	}
+
+	return nil
+}
+
+// BackfillHandler adds an added handler to all pending events that it matches,
+// to let a handler added at runtime handle events stored before it was added.
+// Note that events that are still pending for other handlers could already have
+// been handled by the handler, and will then be handled again.
+func (o *Outbox) BackfillHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	mh, ok := o.handler(handlerType.String())
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	o.dbMu.Lock()
+	defer o.dbMu.Unlock()
+
+	for _, r := range o.db {
+		if containsHandler(r.Handlers, handlerType.String()) || !mh.Match(r.Event) {
+			continue
+		}
+
+		if o.wal != nil {
+			if err := o.wal.append(&walRecord{
+				Op:       walOpBackfilled,
+				ID:       r.ID.String(),
+				Handlers: []string{handlerType.String()},
+			}); err != nil {
+				return fmt.Errorf("could not backfill handler: %w", err)
+			}
+		}
+
+		r.Handlers = append(r.Handlers, handlerType.String())
+	}
+
+	return nil
+}
+
 // Returns an added handler and matcher for a handler type.
 func (o *Outbox) handler(handlerType string) (*matcherHandler, bool) {
 	o.handlersMu.RLock()
@@ -114,40 +259,70 @@ type outboxDoc struct {
 
 // HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
 func (o *Outbox) HandleEvent(ctx context.Context, event eh.Event) error {
+	return o.HandleEvents(ctx, []eh.Event{event})
+}
+
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. The events are written to the WAL,
+// if used, with one sync.
+func (o *Outbox) HandleEvents(ctx context.Context, events []eh.Event) error {
 	o.handlersMu.RLock()
 	defer o.handlersMu.RUnlock()
 
 	o.dbMu.Lock()
 	defer o.dbMu.Unlock()
 
-	var handlerNames []string
+	docs := make([]*outboxDoc, 0, len(events))
+
+	for _, event := range events {
+		var handlerNames []string
 
-	for _, h := range o.handlers {
-		if h.Match(event) {
-			handlerNames = append(handlerNames, h.HandlerType().String())
+		for _, h := range o.handlers {
+			if h.Match(event) {
+				handlerNames = append(handlerNames, h.HandlerType().String())
+			}
+		}
+
+		// Create the event record with timestamp.
+		e, err := copyEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("could not copy event: %w", err)
 		}
-	}
 
-	// Create the event record with timestamp.
-	e, err := copyEvent(ctx, event)
-	if err != nil {
-		return fmt.Errorf("could not copy event: %w", err)
+		docs = append(docs, &outboxDoc{
+			ID:        uuid.New(),
+			Event:     e,
+			Ctx:       ctx,
+			Handlers:  handlerNames,
+			CreatedAt: time.Now(),
+		})
 	}
 
-	r := &outboxDoc{
-		ID:        uuid.New(),
-		Event:     e,
-		Ctx:       ctx,
-		Handlers:  handlerNames,
-		CreatedAt: time.Now(),
+	if o.wal != nil && len(docs) > 0 {
+		recs := make([]*walRecord, 0, len(docs))
+
+		for _, r := range docs {
+			rec, err := o.walAddRecord(ctx, r)
+			if err != nil {
+				return err
+			}
+
+			recs = append(recs, rec)
+		}
+
+		if err := o.wal.append(recs...); err != nil {
+			return fmt.Errorf("could not queue event: %w", err)
+		}
 	}
 
-	o.db[r.ID] = r
+	for _, r := range docs {
+		o.db[r.ID] = r
 
-	select {
-	case o.watchCh <- r:
-	default:
-		// TODO: Error.
+		select {
+		case o.watchCh <- r:
+		default:
+			// TODO: Error.
+		}
 	}
 
 	return nil
@@ -155,6 +330,18 @@ func (o *Outbox) HandleEvent(ctx context.Context, event eh.Event) error {
 
 // Start implements the Start method of the eventhorizon.Outbox interface.
 func (o *Outbox) Start() {
+	if o.wal != nil {
+		// Restore unprocessed events, which are processed by the first sweep.
+		if err := o.replayWAL(); err != nil {
+			err = fmt.Errorf("could not replay WAL: %w", err)
+			o.sendError(&eh.OutboxError{Err: err})
+		}
+
+		o.wg.Add(1)
+
+		go o.runPeriodicallyUntilCancelled(o.compactWAL, PeriodicWALCompactionInterval)
+	}
+
 	o.wg.Add(2)
 
 	go o.runPeriodicallyUntilCancelled(o.processWithWatch, time.Second)
@@ -166,6 +353,10 @@ func (o *Outbox) Close() error {
 	o.cancel()
 	o.wg.Wait()
 
+	if o.wal != nil {
+		return o.wal.close()
+	}
+
 	return nil
 }
 
@@ -183,11 +374,7 @@ func (o *Outbox) runPeriodicallyUntilCancelled(f func(context.Context) error, d
 				return
 			}
 
-			select {
-			case o.errCh <- &eh.OutboxError{Err: err}:
-			default:
-				log.Printf("eventhorizon: missed error in MongoDB outbox processing: %s", err)
-			}
+			o.sendError(&eh.OutboxError{Err: err, Retryable: true})
 		}
 
 		// Wait until next full run or cancelled.
@@ -210,11 +397,7 @@ func (o *Outbox) processWithWatch(ctx context.Context) error {
 			// Use a new context to let processing finish when canceled.
 			if err := o.processOutboxEvent(context.Background(), r, time.Now()); err != nil {
 				err = fmt.Errorf("could not process outbox event: %w", err)
-				select {
-				case o.errCh <- &eh.OutboxError{Err: err}:
-				default:
-					log.Printf("outbox: missed error in MongoDB outbox: %s", err)
-				}
+				o.sendError(&eh.OutboxError{Err: err, Retryable: true})
 			}
 
 			o.dbMu.Unlock()
@@ -274,19 +457,45 @@ func (o *Outbox) processOutboxEvent(ctx context.Context, r *outboxDoc, now time.
 
 		if err := mh.HandleEvent(r.Ctx, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", mh.HandlerType(), err)
-			select {
-			case o.errCh <- &eh.OutboxError{Err: err, Ctx: ctx, Event: event}:
-			default:
-				log.Printf("outbox: missed error in MongoDB outbox: %s", err)
-			}
+			o.sendError(&eh.OutboxError{
+				Err:         err,
+				Ctx:         ctx,
+				Event:       event,
+				HandlerType: mh.HandlerType(),
+				Retryable:   true,
+			})
 		} else {
 			processedHandlers = append(processedHandlers, handlerType)
 		}
 	}
 
 	if len(processedHandlers) == len(r.Handlers) {
+		if o.wal != nil {
+			if err := o.wal.append(&walRecord{
+				Op: walOpDone,
+				ID: r.ID.String(),
+			}); err != nil {
+				return &eh.OutboxError{Err: err, Ctx: ctx, Event: event}
+			}
+		}
+
 		delete(o.db, r.ID)
 	} else if len(processedHandlers) > 0 {
+		if o.wal != nil {
+			handlers := make([]string, len(processedHandlers))
+			for i, ph := range processedHandlers {
+				handlers[i] = ph.(string)
+			}
+
+			if err := o.wal.append(&walRecord{
+				Op:       walOpHandled,
+				ID:       r.ID.String(),
+				Handlers: handlers,
+			}); err != nil {
+				return &eh.OutboxError{Err: err, Ctx: ctx, Event: event}
+			}
+		}
+
 		for _, ph := range processedHandlers {
 			j := 0
 			for _, h := range r.Handlers {
@@ -302,6 +511,17 @@ func (o *Outbox) processOutboxEvent(ctx context.Context, r *outboxDoc, now time.
 	return nil
 }
 
+// Checks if the handler is in the handlers.
+func containsHandler(handlers []string, handler string) bool {
+	for _, h := range handlers {
+		if h == handler {
+			return true
+		}
+	}
+
+	return false
+}
+
 // copyEvent duplicates an event.
 func copyEvent(ctx context.Context, event eh.Event) (eh.Event, error) {
 	var data eh.EventData
@@ -316,6 +536,15 @@ func copyEvent(ctx context.Context, event eh.Event) (eh.Event, error) {
 		copier.Copy(data, event.Data())
 	}
 
+	// Copy the metadata, keeping the types of the values.
+	var metadata map[string]interface{}
+	if event.Metadata() != nil {
+		metadata = make(map[string]interface{}, len(event.Metadata()))
+		for k, v := range event.Metadata() {
+			metadata[k] = v
+		}
+	}
+
 	return eh.NewEvent(
 		event.EventType(),
 		data,
@@ -325,6 +554,21 @@ func copyEvent(ctx context.Context, event eh.Event) (eh.Event, error) {
 			event.AggregateID(),
 			event.Version(),
 		),
-		eh.WithMetadata(event.Metadata()),
+		eh.WithMetadata(metadata),
 	), nil
 }
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (o *Outbox) sendError(err error) {
+	if o.errorHandler != nil {
+		o.errorHandler.HandleError(err)
+	}
+
+	select {
+	case o.errCh <- err:
+	default:
+		if o.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in memory outbox: %s", err)
+		}
+	}
+}
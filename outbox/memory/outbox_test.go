@@ -2,11 +2,19 @@ package memory
 
 import (
 	"context"
+	"errors"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/mocks"
 	"github.com/reidlai/eventhorizon/outbox"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 func init() {
@@ -41,6 +49,407 @@ func TestOutbox(t *testing.T) {
 	}
 }
 
+func TestOutboxRemoveHandler(t *testing.T) {
+	o, err := NewOutbox(WithPurgeOnRemove())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Start()
+
+	outbox.TestRemoveHandler(t, o, context.Background())
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxBackfillHandler(t *testing.T) {
+	ctx := context.Background()
+
+	o, err := NewOutbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := mocks.NewEventHandler("handler")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := o.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Add handlers after the event was stored.
+	lateHandler := mocks.NewEventHandler("late_handler")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, lateHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	otherHandler := mocks.NewEventHandler("other_handler")
+	if err := o.AddHandler(ctx, eh.MatchEvents{mocks.EventOtherType}, otherHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := o.BackfillHandler(ctx, "not-added"); err != eh.ErrHandlerNotFound {
+		t.Error("the error should be correct:", err)
+	}
+
+	if err := o.BackfillHandler(ctx, lateHandler.HandlerType()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if err := o.BackfillHandler(ctx, otherHandler.HandlerType()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	o.Start()
+
+	if !handler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	if !lateHandler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	if otherHandler.Wait(100 * time.Millisecond) {
+		t.Error("the non-matching handler should not receive the event")
+	}
+
+	lateHandler.Lock()
+
+	if !eh.CompareEventSlices(lateHandler.Events, []eh.Event{event}) {
+		t.Error("the events were incorrect:")
+		t.Log(lateHandler.Events)
+	}
+
+	lateHandler.Unlock()
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxWithWAL(t *testing.T) {
+	// Shorter sweeps for testing
+	PeriodicSweepInterval = 2 * time.Second
+	PeriodicSweepAge = 2 * time.Second
+
+	path := filepath.Join(t.TempDir(), "outbox.wal")
+
+	o, err := NewOutbox(WithWAL(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Start()
+
+	outbox.AcceptanceTest(t, o, context.Background(), "none")
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// All events from the acceptance test should be handled after a restart.
+	o, err = NewOutbox(WithWAL(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.replayWAL(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(o.db) != 0 {
+		t.Error("there should be no unprocessed events:", len(o.db))
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxWithWALRestart(t *testing.T) {
+	// Shorter sweeps for testing
+	PeriodicSweepInterval = 2 * time.Second
+	PeriodicSweepAge = 2 * time.Second
+
+	path := filepath.Join(t.TempDir(), "outbox.wal")
+	ctx := mocks.WithContextOne(context.Background(), "testval")
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1),
+		eh.WithMetadata(map[string]interface{}{"meta": "data", "num": 42.0}),
+	)
+
+	// Store an event which fails for one of two handlers, then stop.
+	o, err := NewOutbox(WithWAL(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := mocks.NewEventHandler("handler")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	errorHandler := mocks.NewEventHandler("error_handler")
+	errorHandler.Err = errors.New("handler error")
+
+	if err := o.AddHandler(ctx, eh.MatchAll{}, errorHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	o.Start()
+
+	if err := o.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if !handler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Error("there should be an async error")
+	case <-o.Errors():
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Restart and let only the failed handler handle the event.
+	o, err = NewOutbox(WithWAL(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler = mocks.NewEventHandler("handler")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	errorHandler = mocks.NewEventHandler("error_handler")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, errorHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	o.Start()
+
+	if !errorHandler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	errorHandler.Lock()
+
+	if !eh.CompareEventSlices(errorHandler.Events, []eh.Event{event}) {
+		t.Error("the events were incorrect:")
+		t.Log(errorHandler.Events)
+	}
+
+	if val, ok := mocks.ContextOne(errorHandler.Context); !ok || val != "testval" {
+		t.Error("the context should be correct:", errorHandler.Context)
+	}
+
+	errorHandler.Unlock()
+
+	if handler.Wait(100 * time.Millisecond) {
+		t.Error("the event should not be handled again")
+	}
+
+	// Compact and restart without any unprocessed events.
+	if err := o.compactWAL(context.Background()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	o, err = NewOutbox(WithWAL(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.replayWAL(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(o.db) != 0 {
+		t.Error("there should be no unprocessed events:", len(o.db))
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxHandleEventsWithWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.wal")
+	ctx := context.Background()
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	events := []eh.Event{
+		eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, id, 1)),
+		eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, id, 2)),
+	}
+
+	o, err := NewOutbox(WithWAL(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.AddHandler(ctx, eh.MatchAll{}, mocks.NewEventHandler("handler")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := o.HandleEvents(ctx, events); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if len(o.db) != len(events) {
+		t.Error("all events should be stored:", len(o.db))
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// All events of the batch should be restored from the WAL.
+	o, err = NewOutbox(WithWAL(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.replayWAL(); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if len(o.db) != len(events) {
+		t.Error("all events should be restored:", len(o.db))
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxWithWALPartialRecord(t *testing.T) {
+	testCases := map[string]func(b []byte) int{
+		"half record":           func(b []byte) int { return len(b) / 2 },
+		"only length prefix":    func(b []byte) int { return 4 },
+		"partial length prefix": func(b []byte) int { return 2 },
+	}
+
+	for desc, partialLen := range testCases {
+		partialLen := partialLen
+
+		t.Run(desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "outbox.wal")
+			ctx := context.Background()
+
+			timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+			id := uuid.New()
+			event1 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+				eh.ForAggregate(mocks.AggregateType, id, 1))
+			event2 := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp,
+				eh.ForAggregate(mocks.AggregateType, id, 2))
+
+			o, err := NewOutbox(WithWAL(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := o.AddHandler(ctx, eh.MatchAll{}, mocks.NewEventHandler("handler")); err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			if err := o.HandleEvent(ctx, event1); err != nil {
+				t.Error("there should be no error:", err)
+			}
+
+			if err := o.Close(); err != nil {
+				t.Error("there should be no error:", err)
+			}
+
+			// Simulate a crash during a write, leaving a partial record.
+			b, err := bson.Marshal(&walRecord{Op: walOpAdd, ID: uuid.New().String()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := f.Write(b[:partialLen(b)]); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Restart and append a new event after the partial record.
+			o, err = NewOutbox(WithWAL(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := o.replayWAL(); err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			if len(o.db) != 1 {
+				t.Error("the complete event should be restored:", len(o.db))
+			}
+
+			if err := o.AddHandler(ctx, eh.MatchAll{}, mocks.NewEventHandler("handler")); err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			if err := o.HandleEvent(ctx, event2); err != nil {
+				t.Error("there should be no error:", err)
+			}
+
+			if err := o.Close(); err != nil {
+				t.Error("there should be no error:", err)
+			}
+
+			// Both events should be restored after another restart.
+			o, err = NewOutbox(WithWAL(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := o.replayWAL(); err != nil {
+				t.Fatal("there should be no error:", err)
+			}
+
+			if len(o.db) != 2 {
+				t.Error("all events should be restored:", len(o.db))
+			}
+
+			if err := o.Close(); err != nil {
+				t.Error("there should be no error:", err)
+			}
+		})
+	}
+}
+
 func BenchmarkOutbox(b *testing.B) {
 	// Shorter sweeps for testing.
 	PeriodicSweepInterval = 1 * time.Second
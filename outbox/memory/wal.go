@@ -0,0 +1,341 @@
+package memory
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/reidlai/eventhorizon/uuid"
+)
+
+// walOp is the operation of a record in the write-ahead log.
+type walOp string
+
+const (
+	// walOpAdd records a new outbox entry.
+	walOpAdd walOp = "add"
+	// walOpHandled records that some handlers have handled an entry.
+	walOpHandled walOp = "handled"
+	// walOpDone records that all handlers have handled an entry.
+	walOpDone walOp = "done"
+	// walOpBackfilled records that handlers have been added to an entry.
+	walOpBackfilled walOp = "backfilled"
+)
+
+// walRecord is the on-disk representation of a write-ahead log entry. Records
+// are stored as consecutive BSON documents, which are self delimiting.
+type walRecord struct {
+	Op        walOp     `bson:"op"`
+	ID        string    `bson:"id"`
+	Event     bson.Raw  `bson:"event,omitempty"`
+	Handlers  []string  `bson:"handlers,omitempty"`
+	CreatedAt time.Time `bson:"created_at,omitempty"`
+}
+
+// wal is an append only write-ahead log of outbox entries, used to restore
+// unprocessed entries after a restart.
+type wal struct {
+	path string
+	f    *os.File
+}
+
+func openWAL(path string) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open WAL file: %w", err)
+	}
+
+	return &wal{path: path, f: f}, nil
+}
+
+// append writes a record to the end of the log and syncs it to disk.
+func (w *wal) append(records ...*walRecord) error {
+	var buf []byte
+
+	for _, r := range records {
+		b, err := bson.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("could not marshal WAL record: %w", err)
+		}
+
+		buf = append(buf, b...)
+	}
+
+	if _, err := w.f.Write(buf); err != nil {
+		return fmt.Errorf("could not write WAL record: %w", err)
+	}
+
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("could not sync WAL: %w", err)
+	}
+
+	return nil
+}
+
+// replay reads all records in the log and returns the entries which has not
+// yet been handled by all handlers, in the order they were added. A partially
+// written last record, from a crash during a write, is truncated from the log
+// so that new records are appended after the last complete record.
+func (w *wal) replay() ([]*walRecord, error) {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek WAL: %w", err)
+	}
+
+	var (
+		order   []string
+		entries = map[string]*walRecord{}
+		br      = bufio.NewReader(w.f)
+		offset  int64
+	)
+
+	for {
+		raw, err := bson.NewFromIOReader(br)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// A partial record is truncated below, the error is EOF when
+			// only the length prefix was written.
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read WAL record: %w", err)
+		}
+
+		offset += int64(len(raw))
+
+		var r walRecord
+		if err := bson.Unmarshal(raw, &r); err != nil {
+			return nil, fmt.Errorf("could not unmarshal WAL record: %w", err)
+		}
+
+		switch r.Op {
+		case walOpAdd:
+			order = append(order, r.ID)
+			entries[r.ID] = &r
+		case walOpHandled:
+			if e, ok := entries[r.ID]; ok {
+				e.Handlers = removeHandlers(e.Handlers, r.Handlers)
+			}
+		case walOpDone:
+			delete(entries, r.ID)
+		case walOpBackfilled:
+			if e, ok := entries[r.ID]; ok {
+				e.Handlers = append(e.Handlers, r.Handlers...)
+			}
+		default:
+			return nil, fmt.Errorf("unknown WAL operation: %s", r.Op)
+		}
+	}
+
+	info, err := w.f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat WAL: %w", err)
+	}
+
+	if info.Size() > offset {
+		if err := w.truncate(offset); err != nil {
+			return nil, err
+		}
+	}
+
+	var records []*walRecord
+
+	for _, id := range order {
+		if r, ok := entries[id]; ok {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+// truncate removes everything after the offset from the log.
+func (w *wal) truncate(offset int64) error {
+	if err := w.f.Truncate(offset); err != nil {
+		return fmt.Errorf("could not truncate WAL: %w", err)
+	}
+
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("could not sync WAL: %w", err)
+	}
+
+	return nil
+}
+
+// compact rewrites the log with only the records, which should be the current
+// unprocessed entries. The new log is written to a temporary file which
+// atomically replaces the old log.
+func (w *wal) compact(records []*walRecord) error {
+	tmpPath := w.path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create compacted WAL: %w", err)
+	}
+
+	bw := bufio.NewWriter(f)
+
+	for _, r := range records {
+		b, err := bson.Marshal(r)
+		if err != nil {
+			f.Close()
+
+			return fmt.Errorf("could not marshal WAL record: %w", err)
+		}
+
+		if _, err := bw.Write(b); err != nil {
+			f.Close()
+
+			return fmt.Errorf("could not write WAL record: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		f.Close()
+
+		return fmt.Errorf("could not write compacted WAL: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+
+		return fmt.Errorf("could not sync compacted WAL: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not close compacted WAL: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return fmt.Errorf("could not replace WAL: %w", err)
+	}
+
+	// Reopen the log for appending to the new file.
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("could not close old WAL: %w", err)
+	}
+
+	if w.f, err = os.OpenFile(w.path, os.O_RDWR|os.O_APPEND, 0o600); err != nil {
+		return fmt.Errorf("could not reopen WAL: %w", err)
+	}
+
+	return nil
+}
+
+func (w *wal) close() error {
+	return w.f.Close()
+}
+
+// Returns the handlers without the removed handlers.
+func removeHandlers(handlers, removed []string) []string {
+	var res []string
+
+	for _, h := range handlers {
+		keep := true
+
+		for _, r := range removed {
+			if h == r {
+				keep = false
+
+				break
+			}
+		}
+
+		if keep {
+			res = append(res, h)
+		}
+	}
+
+	return res
+}
+
+// Creates a WAL record for adding an outbox entry.
+func (o *Outbox) walAddRecord(ctx context.Context, r *outboxDoc) (*walRecord, error) {
+	e, err := o.codec.MarshalEvent(ctx, r.Event)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal event: %w", err)
+	}
+
+	return &walRecord{
+		Op:        walOpAdd,
+		ID:        r.ID.String(),
+		Event:     e,
+		Handlers:  r.Handlers,
+		CreatedAt: r.CreatedAt,
+	}, nil
+}
+
+// Restores all unprocessed entries from the WAL into the outbox.
+func (o *Outbox) replayWAL() error {
+	o.dbMu.Lock()
+	defer o.dbMu.Unlock()
+
+	records, err := o.wal.replay()
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		id, err := uuid.Parse(rec.ID)
+		if err != nil {
+			return fmt.Errorf("could not parse WAL record ID: %w", err)
+		}
+
+		event, ctx, err := o.codec.UnmarshalEvent(context.Background(), rec.Event)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal WAL event: %w", err)
+		}
+
+		// Keep entries added before the replay, they have the original context.
+		if _, ok := o.db[id]; ok {
+			continue
+		}
+
+		o.db[id] = &outboxDoc{
+			ID:        id,
+			Event:     event,
+			Ctx:       ctx,
+			Handlers:  rec.Handlers,
+			CreatedAt: rec.CreatedAt,
+		}
+	}
+
+	return nil
+}
+
+// Rewrites the WAL to only contain the current unprocessed entries.
+func (o *Outbox) compactWAL(ctx context.Context) error {
+	o.dbMu.Lock()
+	defer o.dbMu.Unlock()
+
+	docs := make([]*outboxDoc, 0, len(o.db))
+	for _, r := range o.db {
+		docs = append(docs, r)
+	}
+
+	// Keep the order of the entries when replaying.
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].CreatedAt.Before(docs[j].CreatedAt)
+	})
+
+	records := make([]*walRecord, 0, len(docs))
+
+	for _, r := range docs {
+		rec, err := o.walAddRecord(r.Ctx, r)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, rec)
+	}
+
+	if err := o.wal.compact(records); err != nil {
+		return fmt.Errorf("could not compact WAL: %w", err)
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	bsonCodec "github.com/reidlai/eventhorizon/codec/bson"
@@ -30,6 +31,10 @@ var (
 	// to be processed by the periodic sweep.
 	PeriodicSweepAge   = 15 * time.Second
 	PeriodicCleanupAge = 10 * time.Minute
+
+	// DefaultPollBatchSize is the default max number of events to claim in each
+	// poll when using polling instead of change streams.
+	DefaultPollBatchSize = 100
 )
 
 // Outbox implements an eventhorizon.Outbox for MongoDB.
@@ -41,9 +46,15 @@ type Outbox struct {
 	handlersByType  map[eh.EventHandlerType]*matcherHandler
 	handlersMu      sync.RWMutex
 	errCh           chan error
+	errorHandler    eh.ErrorHandler
 	watchToken      string
 	resumeToken     bson.Raw
+	pollInterval    time.Duration
+	pollBatchSize   int
+	purgeOnRemove   bool
 	processingMu    sync.Mutex
+	started         atomic.Bool
+	watching        atomic.Bool
 	cctx            context.Context
 	cancel          context.CancelFunc
 	wg              sync.WaitGroup
@@ -95,6 +106,7 @@ func newOutboxWithClient(client *mongo.Client, clientOwnership clientOwnership,
 		outbox:          client.Database(dbName).Collection("outbox"),
 		handlersByType:  map[eh.EventHandlerType]*matcherHandler{},
 		errCh:           make(chan error, 100),
+		pollBatchSize:   DefaultPollBatchSize,
 		cctx:            ctx,
 		cancel:          cancel,
 		codec:           &bsonCodec.EventCodec{},
@@ -128,6 +140,39 @@ func WithWatchToken(token string) Option {
 	}
 }
 
+// WithPolling processes the outbox by polling for new events at the interval
+// instead of using a change stream. This enables the use of the outbox with
+// standalone MongoDB servers which does not support change streams. Events are
+// claimed one at a time using a lease timestamp, which lets the periodic sweep
+// retry events whose handling did not finish in time.
+func WithPolling(interval time.Duration) Option {
+	return func(o *Outbox) error {
+		if interval <= 0 {
+			return fmt.Errorf("poll interval must be greater than 0")
+		}
+
+		o.pollInterval = interval
+
+		return nil
+	}
+}
+
+// WithPollBatchSize sets the max number of events to claim in each poll, only
+// used together with WithPolling.
+//
+// Defaults to: DefaultPollBatchSize
+func WithPollBatchSize(size int) Option {
+	return func(o *Outbox) error {
+		if size < 1 {
+			return fmt.Errorf("poll batch size must be greater than 0")
+		}
+
+		o.pollBatchSize = size
+
+		return nil
+	}
+}
+
 // WithCollectionName uses different collections from the default "outbox" collection.
 func WithCollectionName(outboxColl string) Option {
 	return func(s *Outbox) error {
@@ -176,6 +221,129 @@ func (o *Outbox) AddHandler(_ context.Context, m eh.EventMatcher, h eh.EventHand
 	return nil
 }
 
+// WithPurgeOnRemove removes a handler from all pending events when it is
+// removed with RemoveHandler. Otherwise the pending events are kept, and will
+// be handled if the handler is added again. Only events with the same watch
+// token as the outbox are purged.
+func WithPurgeOnRemove() Option {
+	return func(o *Outbox) error {
+		o.purgeOnRemove = true
+
+		return nil
+	}
+}
+
+// WithErrorHandler calls the error handler with all async errors, in addition
+// to sending them on the errors channel.
+func WithErrorHandler(h eh.ErrorHandler) Option {
+	return func(o *Outbox) error {
+		if h == nil {
+			return errors.New("missing error handler")
+		}
+
+		o.errorHandler = h
+
+		return nil
+	}
+}
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface. It waits for any ongoing
+// processing of events to finish.
+func (o *Outbox) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	o.handlersMu.Lock()
+
+	if _, ok := o.handlersByType[handlerType]; !ok {
+		o.handlersMu.Unlock()
+
+		return eh.ErrHandlerNotFound
+	}
+
+	delete(o.handlersByType, handlerType)
+
+	for i, mh := range o.handlers {
+		if mh.HandlerType() == handlerType {
+			o.handlers = append(o.handlers[:i], o.handlers[i+1:]...)
+
+			break
+		}
+	}
+
+	o.handlersMu.Unlock()
+
+	// All processing is done while holding the processing lock.
+	o.processingMu.Lock()
+	defer o.processingMu.Unlock()
+
+	if !o.purgeOnRemove {
+		return nil
+	}
+
+	filter := bson.M{"handlers": handlerType.String()}
+	if o.watchToken != "" {
+		filter["watch_token"] = o.watchToken
+	}
+
+	if _, err := o.outbox.UpdateMany(ctx, filter,
+		bson.M{"$pull": bson.M{"handlers": handlerType.String()}},
+	); err != nil {
+		return fmt.Errorf("could not purge handler: %w", err)
+	}
+
+	return nil
+}
+
+// BackfillHandler adds an added handler to all pending events that it matches,
+// to let a handler added at runtime handle events stored before it was added.
+// Only events with the same watch token as the outbox are backfilled. Note that
+// events that are still pending for other handlers could already have been
+// handled by the handler, and will then be handled again.
+func (o *Outbox) BackfillHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	mh, ok := o.handler(handlerType.String())
+	if !ok {
+		return eh.ErrHandlerNotFound
+	}
+
+	// Avoid modifying events that are being processed.
+	o.processingMu.Lock()
+	defer o.processingMu.Unlock()
+
+	filter := bson.M{"handlers": bson.M{"$ne": handlerType.String()}}
+	if o.watchToken != "" {
+		filter["watch_token"] = o.watchToken
+	}
+
+	cur, err := o.outbox.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("could not find outbox events: %w", err)
+	}
+
+	for cur.Next(ctx) {
+		var r outboxDoc
+		if err := cur.Decode(&r); err != nil {
+			return fmt.Errorf("could not unmarshal outbox event: %w", err)
+		}
+
+		event, _, err := o.codec.UnmarshalEvent(ctx, r.Event)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal event: %w", err)
+		}
+
+		if !mh.Match(event) {
+			continue
+		}
+
+		if _, err := o.outbox.UpdateOne(ctx,
+			bson.M{"_id": r.ID},
+			bson.M{"$addToSet": bson.M{"handlers": handlerType.String()}},
+		); err != nil {
+			return fmt.Errorf("could not backfill outbox event: %w", err)
+		}
+	}
+
+	return cur.Close(ctx)
+}
+
 // Returns an added handler and matcher for a handler type.
 func (o *Outbox) handler(handlerType string) (*matcherHandler, bool) {
 	o.handlersMu.RLock()
@@ -194,36 +362,53 @@ type outboxDoc struct {
 	WatchToken string             `bson:"watch_token,omitempty"`
 	CreatedAt  time.Time          `bson:"created_at"`
 	TakenAt    time.Time          `bson:"taken_at,omitempty"`
+	ClaimToken primitive.ObjectID `bson:"claim_token,omitempty"`
 }
 
 // HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
 func (o *Outbox) HandleEvent(ctx context.Context, event eh.Event) error {
+	return o.HandleEvents(ctx, []eh.Event{event})
+}
+
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. The events are inserted at once.
+func (o *Outbox) HandleEvents(ctx context.Context, events []eh.Event) error {
 	o.handlersMu.RLock()
 	defer o.handlersMu.RUnlock()
 
-	e, err := o.codec.MarshalEvent(ctx, event)
-	if err != nil {
-		return fmt.Errorf("could not marshal event: %w", err)
-	}
+	docs := make([]interface{}, 0, len(events))
+
+	for _, event := range events {
+		e, err := o.codec.MarshalEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("could not marshal event: %w", err)
+		}
 
-	var handlerNames []string
+		var handlerNames []string
 
-	for _, h := range o.handlers {
-		if h.Match(event) {
-			handlerNames = append(handlerNames, h.HandlerType().String())
+		for _, h := range o.handlers {
+			if h.Match(event) {
+				handlerNames = append(handlerNames, h.HandlerType().String())
+			}
 		}
-	}
 
-	r := &outboxDoc{
-		Event:     e,
-		Handlers:  handlerNames,
-		CreatedAt: time.Now(),
+		r := &outboxDoc{
+			Event:     e,
+			Handlers:  handlerNames,
+			CreatedAt: time.Now(),
+		}
+		if o.watchToken != "" {
+			r.WatchToken = o.watchToken
+		}
+
+		docs = append(docs, r)
 	}
-	if o.watchToken != "" {
-		r.WatchToken = o.watchToken
+
+	if len(docs) == 0 {
+		return nil
 	}
 
-	if _, err := o.outbox.InsertOne(ctx, r); err != nil {
+	if _, err := o.outbox.InsertMany(ctx, docs); err != nil {
 		return fmt.Errorf("could not queue event: %w", err)
 	}
 
@@ -232,9 +417,15 @@ func (o *Outbox) HandleEvent(ctx context.Context, event eh.Event) error {
 
 // Start implements the Start method of the eventhorizon.Outbox interface.
 func (o *Outbox) Start() {
+	o.started.Store(true)
 	o.wg.Add(2)
 
-	go o.runPeriodicallyUntilCancelled(o.processWithWatch, time.Second)
+	if o.pollInterval > 0 {
+		go o.runPeriodicallyUntilCancelled(o.processWithPolling, o.pollInterval)
+	} else {
+		go o.runPeriodicallyUntilCancelled(o.processWithWatch, time.Second)
+	}
+
 	go o.runPeriodicallyUntilCancelled(o.processFullOutbox, PeriodicSweepInterval)
 }
 
@@ -256,6 +447,27 @@ func (o *Outbox) Errors() <-chan error {
 	return o.errCh
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (o *Outbox) HealthCheckName() string {
+	return "mongodb outbox"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by pinging the primary MongoDB server, and checking that the
+// change stream is watched when the outbox is started without polling.
+func (o *Outbox) Check(ctx context.Context) error {
+	if err := o.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("could not ping database: %w", err)
+	}
+
+	if o.started.Load() && o.pollInterval == 0 && !o.watching.Load() {
+		return errors.New("outbox change stream is not running")
+	}
+
+	return nil
+}
+
 func (o *Outbox) runPeriodicallyUntilCancelled(f func(context.Context) error, d time.Duration) {
 	defer o.wg.Done()
 
@@ -265,11 +477,7 @@ func (o *Outbox) runPeriodicallyUntilCancelled(f func(context.Context) error, d
 				return
 			}
 
-			select {
-			case o.errCh <- &eh.OutboxError{Err: err}:
-			default:
-				log.Printf("eventhorizon: missed error in MongoDB outbox processing: %s", err)
-			}
+			o.sendError(&eh.OutboxError{Err: err, Retryable: true})
 		}
 
 		// Wait until next full run or cancelled.
@@ -300,6 +508,9 @@ func (o *Outbox) processWithWatch(ctx context.Context) error {
 		return fmt.Errorf("could not watch outbox: %w", err)
 	}
 
+	o.watching.Store(true)
+	defer o.watching.Store(false)
+
 	// Graceful cancel handling.
 	gracefulCtx, keepAlive, cancel := NewGracefulContext(ctx, 500*time.Millisecond, 5*time.Second)
 
@@ -313,11 +524,7 @@ func (o *Outbox) processWithWatch(ctx context.Context) error {
 	// Watch loop.
 	for stream.Next(gracefulCtx) {
 		if err := o.processStreamEvent(stream.Current); err != nil {
-			select {
-			case o.errCh <- &eh.OutboxError{Err: err}:
-			default:
-				log.Printf("outbox: missed error in MongoDB outbox: %s", err)
-			}
+			o.sendError(&eh.OutboxError{Err: err, Retryable: true})
 		}
 
 		// Ping the graceful exit handling, before exiting it's a no-op.
@@ -353,6 +560,86 @@ func (o *Outbox) processStreamEvent(streamEvent bson.Raw) error {
 	return nil
 }
 
+func (o *Outbox) processWithPolling(ctx context.Context) error {
+	o.processingMu.Lock()
+	defer o.processingMu.Unlock()
+
+	docs, err := o.claimEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range docs {
+		// Use a new context to let processing finish when canceled.
+		if err := o.handleOutboxEvent(context.Background(), r); err != nil {
+			err = fmt.Errorf("could not process outbox event: %w", err)
+			o.sendError(&eh.OutboxError{Err: err, Retryable: true})
+		}
+	}
+
+	return nil
+}
+
+// Claims a batch of the oldest unclaimed events by setting their lease
+// timestamp together with a token for the poll, and returns the events which
+// were claimed with the token. Events claimed by other instances in between are
+// skipped.
+func (o *Outbox) claimEvents(ctx context.Context) ([]*outboxDoc, error) {
+	filter := bson.M{"taken_at": nil}
+
+	// Match only documents with the same group token if that option is set.
+	if o.watchToken != "" {
+		filter["watch_token"] = o.watchToken
+	}
+
+	cur, err := o.outbox.Find(ctx, filter, options.Find().
+		SetSort(bson.M{"created_at": 1}).
+		SetLimit(int64(o.pollBatchSize)).
+		SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not find outbox events: %w", err)
+	}
+
+	var found []outboxDoc
+	if err := cur.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("could not decode outbox events: %w", err)
+	}
+
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	ids := make(bson.A, len(found))
+	for i, r := range found {
+		ids[i] = r.ID
+	}
+
+	token := primitive.NewObjectID()
+
+	if res, err := o.outbox.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "taken_at": nil},
+		bson.M{"$set": bson.M{"taken_at": time.Now(), "claim_token": token}},
+	); err != nil {
+		return nil, fmt.Errorf("could not claim outbox events: %w", err)
+	} else if res.ModifiedCount == 0 {
+		return nil, nil
+	}
+
+	if cur, err = o.outbox.Find(ctx, bson.M{"claim_token": token},
+		options.Find().SetSort(bson.M{"created_at": 1}),
+	); err != nil {
+		return nil, fmt.Errorf("could not find claimed outbox events: %w", err)
+	}
+
+	var docs []*outboxDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("could not decode claimed outbox events: %w", err)
+	}
+
+	return docs, nil
+}
+
 func (o *Outbox) processFullOutbox(ctx context.Context) error {
 	o.processingMu.Lock()
 	defer o.processingMu.Unlock()
@@ -388,14 +675,6 @@ func (o *Outbox) processFullOutbox(ctx context.Context) error {
 // The current time is passed to avoid data races between concurrent sweeps where
 // the fetch time and taken time could differ.
 func (o *Outbox) processOutboxEvent(ctx context.Context, r *outboxDoc, now time.Time) error {
-	event, ctx, err := o.codec.UnmarshalEvent(ctx, r.Event)
-	if err != nil {
-		return &eh.OutboxError{
-			Err: fmt.Errorf("could not unmarshal event: %w", err),
-			Ctx: ctx,
-		}
-	}
-
 	if res, err := o.outbox.UpdateOne(ctx, bson.M{
 		"_id": r.ID,
 		"$or": bson.A{
@@ -406,14 +685,26 @@ func (o *Outbox) processOutboxEvent(ctx context.Context, r *outboxDoc, now time.
 		bson.M{"$set": bson.M{"taken_at": now}},
 	); err != nil {
 		return &eh.OutboxError{
-			Err:   fmt.Errorf("could not take event for handling: %w", err),
-			Ctx:   ctx,
-			Event: event,
+			Err: fmt.Errorf("could not take event for handling: %w", err),
+			Ctx: ctx,
 		}
 	} else if res.MatchedCount == 0 {
 		return nil
 	}
 
+	return o.handleOutboxEvent(ctx, r)
+}
+
+// Handles an event which has already been taken, by either a sweep or a poll.
+func (o *Outbox) handleOutboxEvent(ctx context.Context, r *outboxDoc) error {
+	event, ctx, err := o.codec.UnmarshalEvent(ctx, r.Event)
+	if err != nil {
+		return &eh.OutboxError{
+			Err: fmt.Errorf("could not unmarshal event: %w", err),
+			Ctx: ctx,
+		}
+	}
+
 	var processedHandlers []interface{}
 
 	// Process all handlers without returning handler errors.
@@ -429,11 +720,13 @@ func (o *Outbox) processOutboxEvent(ctx context.Context, r *outboxDoc, now time.
 
 		if err := mh.HandleEvent(ctx, event); err != nil {
 			err = fmt.Errorf("could not handle event (%s): %w", mh.HandlerType(), err)
-			select {
-			case o.errCh <- &eh.OutboxError{Err: err, Ctx: ctx, Event: event}:
-			default:
-				log.Printf("outbox: missed error in MongoDB outbox: %s", err)
-			}
+			o.sendError(&eh.OutboxError{
+				Err:         err,
+				Ctx:         ctx,
+				Event:       event,
+				HandlerType: mh.HandlerType(),
+				Retryable:   true,
+			})
 		} else {
 			processedHandlers = append(processedHandlers, handlerType)
 		}
@@ -470,3 +763,18 @@ func (o *Outbox) processOutboxEvent(ctx context.Context, r *outboxDoc, now time.
 
 	return nil
 }
+
+// Sends the error to the error handler, if any, and on the errors channel.
+func (o *Outbox) sendError(err error) {
+	if o.errorHandler != nil {
+		o.errorHandler.HandleError(err)
+	}
+
+	select {
+	case o.errCh <- err:
+	default:
+		if o.errorHandler == nil {
+			log.Printf("eventhorizon: missed error in MongoDB outbox: %s", err)
+		}
+	}
+}
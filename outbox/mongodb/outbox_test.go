@@ -8,7 +8,10 @@ import (
 	"testing"
 	"time"
 
+	eh "github.com/reidlai/eventhorizon"
+	"github.com/reidlai/eventhorizon/mocks"
 	"github.com/reidlai/eventhorizon/outbox"
+	"github.com/reidlai/eventhorizon/uuid"
 )
 
 func init() {
@@ -55,6 +58,183 @@ func TestOutboxIntegration(t *testing.T) {
 	}
 }
 
+func TestOutboxWithPollingIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	url, db := makeDB(t)
+
+	// Shorter sweeps for testing
+	PeriodicSweepInterval = 2 * time.Second
+	PeriodicSweepAge = 2 * time.Second
+
+	o, err := NewOutbox(url, db,
+		WithPolling(100*time.Millisecond),
+		WithPollBatchSize(10),
+		WithWatchToken("polling"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Start()
+
+	outbox.AcceptanceTest(t, o, context.Background(), "none")
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxClaimEventsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	ctx := context.Background()
+	url, db := makeDB(t)
+
+	// Not started, to claim the events manually.
+	o, err := NewOutbox(url, db, WithPolling(time.Second), WithPollBatchSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.AddHandler(ctx, eh.MatchAll{}, mocks.NewEventHandler("handler")); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	var events []eh.Event
+	for i := 0; i < 5; i++ {
+		events = append(events, eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp,
+			eh.ForAggregate(mocks.AggregateType, uuid.New(), 1)))
+	}
+
+	if err := o.HandleEvents(ctx, events); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	// Each poll should claim a batch with a single token.
+	for _, expected := range []int{3, 2, 0} {
+		docs, err := o.claimEvents(ctx)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+
+		if len(docs) != expected {
+			t.Errorf("there should be %d claimed events: %d", expected, len(docs))
+		}
+
+		for _, r := range docs {
+			if r.TakenAt.IsZero() || r.ClaimToken != docs[0].ClaimToken {
+				t.Errorf("the event should be claimed with the token: %+v", r)
+			}
+		}
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxRemoveHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	url, db := makeDB(t)
+
+	o, err := NewOutbox(url, db, WithPurgeOnRemove())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Start()
+
+	outbox.TestRemoveHandler(t, o, context.Background())
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestOutboxBackfillHandlerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	ctx := context.Background()
+	url, db := makeDB(t)
+
+	// Use polling to also process events stored before starting.
+	o, err := NewOutbox(url, db, WithPolling(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := mocks.NewEventHandler("handler")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, handler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEvent(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp,
+		eh.ForAggregate(mocks.AggregateType, uuid.New(), 1))
+
+	if err := o.HandleEvent(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	// Add a handler after the event was stored.
+	lateHandler := mocks.NewEventHandler("late_handler")
+	if err := o.AddHandler(ctx, eh.MatchAll{}, lateHandler); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := o.BackfillHandler(ctx, "not-added"); err != eh.ErrHandlerNotFound {
+		t.Error("the error should be correct:", err)
+	}
+
+	if err := o.BackfillHandler(ctx, lateHandler.HandlerType()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	o.Start()
+
+	if !handler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	if !lateHandler.Wait(time.Second) {
+		t.Error("did not receive event in time")
+	}
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestWithPollingInvalidOptions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	url, db := makeDB(t)
+
+	_, err := NewOutbox(url, db, WithPolling(0))
+	if err == nil || err.Error() != "error while applying option: poll interval must be greater than 0" {
+		t.Fatal("there should be an error")
+	}
+
+	_, err = NewOutbox(url, db, WithPolling(time.Second), WithPollBatchSize(0))
+	if err == nil || err.Error() != "error while applying option: poll batch size must be greater than 0" {
+		t.Fatal("there should be an error")
+	}
+}
+
 func TestWithCollectionNameIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
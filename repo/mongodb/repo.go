@@ -426,6 +426,22 @@ func (r *Repo) Clear(ctx context.Context) error {
 	return nil
 }
 
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface.
+func (r *Repo) HealthCheckName() string {
+	return "mongodb repo"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface by pinging the primary MongoDB server.
+func (r *Repo) Check(ctx context.Context) error {
+	if err := r.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("could not ping database: %w", err)
+	}
+
+	return nil
+}
+
 // Close implements the Close method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Close() error {
 	if r.clientOwnership == externalClient {
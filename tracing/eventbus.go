@@ -16,7 +16,10 @@ package tracing
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	eh "github.com/reidlai/eventhorizon"
 )
 
@@ -41,6 +44,13 @@ func (b *EventBus) HandleEvent(ctx context.Context, event eh.Event) error {
 	return b.h.HandleEvent(ctx, event)
 }
 
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. Events are handled in one span if
+// the wrapped event bus supports batches, otherwise one by one.
+func (b *EventBus) HandleEvents(ctx context.Context, events []eh.Event) error {
+	return handleEvents(ctx, b.EventBus, b.h, events)
+}
+
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
 func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
 	if h == nil {
@@ -52,3 +62,74 @@ func (b *EventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.Event
 
 	return b.EventBus.AddHandler(ctx, m, h)
 }
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface, if supported by the wrapped event bus.
+func (b *EventBus) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	r, ok := b.EventBus.(eh.EventHandlerRemover)
+	if !ok {
+		return eh.ErrRemoveHandlerNotSupported
+	}
+
+	return r.RemoveHandler(ctx, handlerType)
+}
+
+// Handles events in one span with the inner handler if it supports batches,
+// otherwise one by one with the traced handler.
+func handleEvents(ctx context.Context, inner, traced eh.EventHandler, events []eh.Event) error {
+	bh, ok := inner.(eh.EventBatchHandler)
+	if !ok {
+		for _, event := range events {
+			if err := traced.HandleEvent(ctx, event); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	opName := fmt.Sprintf("%s.Events", inner.HandlerType())
+	sp, ctx := opentracing.StartSpanFromContext(ctx, opName)
+
+	err := bh.HandleEvents(ctx, events)
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+
+	sp.SetTag("eh.num_events", len(events))
+
+	sp.Finish()
+
+	return err
+}
+
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface, using the name of the wrapped event bus
+// if it has one.
+func (b *EventBus) HealthCheckName() string {
+	if hc, ok := b.EventBus.(eh.HealthChecker); ok {
+		return hc.HealthCheckName()
+	}
+
+	return "tracing eventbus"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface, if supported by the wrapped event bus.
+func (b *EventBus) Check(ctx context.Context) error {
+	hc, ok := b.EventBus.(eh.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "EventBus.Check")
+
+	err := hc.Check(ctx)
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+
+	sp.Finish()
+
+	return err
+}
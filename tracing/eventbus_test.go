@@ -75,6 +75,34 @@ func TestEventBus(t *testing.T) {
 	eventbus.AcceptanceTest(t, bus1, bus2, time.Second)
 }
 
+func TestEventBusRemoveHandler(t *testing.T) {
+	innerBus := local.NewEventBus()
+	if innerBus == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	bus := NewEventBus(innerBus)
+	if bus == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	eventbus.TestRemoveHandler(t, bus, 100*time.Millisecond)
+}
+
+func TestEventBusHandleEvents(t *testing.T) {
+	innerBus := local.NewEventBus()
+	if innerBus == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	bus := NewEventBus(innerBus)
+	if bus == nil {
+		t.Fatal("there should be a bus")
+	}
+
+	eventbus.TestHandleEvents(t, bus, 100*time.Millisecond)
+}
+
 func TestEventBusLoadtest(t *testing.T) {
 	innerBus := local.NewEventBus()
 	if innerBus == nil {
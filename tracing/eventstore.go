@@ -83,3 +83,34 @@ func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error)
 
 	return events, err
 }
+
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface, using the name of the wrapped event store
+// if it has one.
+func (s *EventStore) HealthCheckName() string {
+	if hc, ok := s.EventStore.(eh.HealthChecker); ok {
+		return hc.HealthCheckName()
+	}
+
+	return "tracing eventstore"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface, if supported by the wrapped event store.
+func (s *EventStore) Check(ctx context.Context) error {
+	hc, ok := s.EventStore.(eh.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "EventStore.Check")
+
+	err := hc.Check(ctx)
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+
+	sp.Finish()
+
+	return err
+}
@@ -17,6 +17,8 @@ package tracing
 import (
 	"context"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	eh "github.com/reidlai/eventhorizon"
 )
 
@@ -41,6 +43,13 @@ func (b *Outbox) HandleEvent(ctx context.Context, event eh.Event) error {
 	return b.h.HandleEvent(ctx, event)
 }
 
+// HandleEvents implements the HandleEvents method of the
+// eventhorizon.EventBatchHandler interface. Events are handled in one span if
+// the wrapped outbox supports batches, otherwise one by one.
+func (b *Outbox) HandleEvents(ctx context.Context, events []eh.Event) error {
+	return handleEvents(ctx, b.Outbox, b.h, events)
+}
+
 // AddHandler implements the AddHandler method of the eventhorizon.Outbox interface.
 func (b *Outbox) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
 	if h == nil {
@@ -52,3 +61,45 @@ func (b *Outbox) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHa
 
 	return b.Outbox.AddHandler(ctx, m, h)
 }
+
+// RemoveHandler implements the RemoveHandler method of the
+// eventhorizon.EventHandlerRemover interface, if supported by the wrapped outbox.
+func (b *Outbox) RemoveHandler(ctx context.Context, handlerType eh.EventHandlerType) error {
+	r, ok := b.Outbox.(eh.EventHandlerRemover)
+	if !ok {
+		return eh.ErrRemoveHandlerNotSupported
+	}
+
+	return r.RemoveHandler(ctx, handlerType)
+}
+
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface, using the name of the wrapped outbox
+// if it has one.
+func (b *Outbox) HealthCheckName() string {
+	if hc, ok := b.Outbox.(eh.HealthChecker); ok {
+		return hc.HealthCheckName()
+	}
+
+	return "tracing outbox"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface, if supported by the wrapped outbox.
+func (b *Outbox) Check(ctx context.Context) error {
+	hc, ok := b.Outbox.(eh.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "Outbox.Check")
+
+	err := hc.Check(ctx)
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+
+	sp.Finish()
+
+	return err
+}
@@ -67,6 +67,26 @@ func TestOutbox(t *testing.T) {
 	}
 }
 
+func TestOutboxRemoveHandler(t *testing.T) {
+	innerOutbox, err := memory.NewOutbox()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	o := NewOutbox(innerOutbox)
+	if o == nil {
+		t.Fatal("there should be an outbox")
+	}
+
+	o.Start()
+
+	outbox.TestRemoveHandler(t, o, context.Background())
+
+	if err := o.Close(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
 func BenchmarkOutbox(b *testing.B) {
 	// Shorter sweeps for testing
 	memory.PeriodicSweepInterval = 2 * time.Second
@@ -118,3 +118,34 @@ func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
 
 	return err
 }
+
+// HealthCheckName implements the HealthCheckName method of the
+// eventhorizon.HealthChecker interface, using the name of the wrapped repo
+// if it has one.
+func (r *Repo) HealthCheckName() string {
+	if hc, ok := r.ReadWriteRepo.(eh.HealthChecker); ok {
+		return hc.HealthCheckName()
+	}
+
+	return "tracing repo"
+}
+
+// Check implements the Check method of the eventhorizon.HealthChecker
+// interface, if supported by the wrapped repo.
+func (r *Repo) Check(ctx context.Context) error {
+	hc, ok := r.ReadWriteRepo.(eh.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "Repo.Check")
+
+	err := hc.Check(ctx)
+	if err != nil {
+		ext.LogError(sp, err)
+	}
+
+	sp.Finish()
+
+	return err
+}
@@ -26,3 +26,9 @@ func Parse(s string) (UUID, error) {
 func MustParse(s string) UUID {
 	return UUID(uuid.MustParse(s))
 }
+
+// NewSHA1 returns a UUID based on the SHA1 hash of the namespace and data, the
+// same namespace and data always gives the same UUID.
+func NewSHA1(space UUID, data []byte) UUID {
+	return UUID(uuid.NewSHA1(space, data))
+}